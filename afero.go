@@ -100,7 +100,7 @@ type Fs interface {
 }
 
 var (
-	ErrFileClosed        = errors.New("File is closed")
+	ErrFileClosed        = os.ErrClosed
 	ErrInvalid           = os.ErrInvalid
 	ErrTooLarge          = errors.New("Too large")
 	ErrFileNotFound      = os.ErrNotExist
@@ -111,27 +111,22 @@ var (
 	ErrNotEmpty          = syscall.ENOTEMPTY
 )
 
-// IsNotDir returns a boolean indicating whether the error is known to report when encountering a file along a path intended for a new directory. It is satisfied by ErrNotDir as well as some syscall errors.
+// IsNotDir returns a boolean indicating whether the error is known to report when encountering a file along a path intended for a new directory. It is satisfied by ErrNotDir and the errors wrapping it, like *os.PathError.
 func IsNotDir(err error) bool {
-	pathErr, ok := err.(*os.PathError)
-	return err == ErrNotDir || (ok && pathErr.Unwrap() == ErrNotDir)
+	return errors.Is(err, ErrNotDir)
 }
 
-// IsNotEmpty returns a boolean indicating whether the error is known to report when removing a non-empty directory. It is satisfied by ErrNotEmpty as well as some syscall errors.
+// IsNotEmpty returns a boolean indicating whether the error is known to report when removing a non-empty directory. It is satisfied by ErrNotEmpty and the errors wrapping it, like *os.PathError.
 func IsNotEmpty(err error) bool {
-	pathErr, ok := err.(*os.PathError)
-	return err == ErrNotEmpty || (ok && pathErr.Unwrap() == ErrNotEmpty)
+	return errors.Is(err, ErrNotEmpty)
 }
 
-// IsDirErr returns a boolean indicating whether the error is known to report when encountering a directory along a path intended for a new file. It is satisfied by ErrIsDir as well as some syscall errors.
+// IsDirErr returns a boolean indicating whether the error is known to report when encountering a directory along a path intended for a new file. It is satisfied by ErrIsDir and the errors wrapping it, like *os.PathError.
 func IsDirErr(err error) bool {
-	pathErr, ok := err.(*os.PathError)
-	return err == ErrIsDir || (ok && pathErr.Unwrap() == ErrIsDir)
+	return errors.Is(err, ErrIsDir)
 }
 
-// IsInvalid returns a boolean indicating whether the error is known to report when receiving an invalid argument for a range. It is satisfied by ErrInvalid as well as some syscall errors.
+// IsInvalid returns a boolean indicating whether the error is known to report when receiving an invalid argument for a range. It is satisfied by ErrInvalid, syscall.EINVAL and the errors wrapping them, like *os.PathError.
 func IsInvalid(err error) bool {
-	pathErr, ok := err.(*os.PathError)
-	return err == ErrInvalid || (ok && pathErr.Unwrap() == ErrInvalid) ||
-		err == syscall.EINVAL || (ok && pathErr.Unwrap() == syscall.EINVAL)
+	return errors.Is(err, ErrInvalid) || errors.Is(err, syscall.EINVAL)
 }
@@ -25,6 +25,7 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 )
 
 var testName = "test.txt"
@@ -459,6 +460,34 @@ func setupTestFiles(t *testing.T, fs Fs, path string) string {
 	return testSubDir
 }
 
+// treeModTime is the modification time of the files written by writeTree.
+var treeModTime = time.Date(2019, 5, 6, 7, 8, 9, 0, time.UTC)
+
+// writeTree creates the files under root with their contents, mode 0644 and
+// treeModTime, along with their parent directories. The names, relative to
+// root and separated by /, ending with a / are empty directories.
+func writeTree(t *testing.T, fs Fs, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := fs.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(fs, path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes(path, treeModTime, treeModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestReaddirnames(t *testing.T) {
 	defer removeAllTestFiles(t)
 	for _, fs := range Fss {
@@ -0,0 +1,314 @@
+// Package aferotest helps testing code using afero: it builds fixture trees
+// from compact literals or txtar archives, checks trees against an expected
+// spec or a golden txtar file, and checks that an Fs implementation behaves
+// like OsFs.
+package aferotest
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// update is namespaced not to collide with the -update flag of the tests
+// importing aferotest.
+var update = flag.Bool("aferotest.update", false, "rewrite the golden files checked by aferotest.AssertGolden")
+
+// Tree describes a directory tree. Each key is a slash separated path,
+// relative to the root of the tree, with a trailing slash for a directory,
+// optionally followed by a space and an octal mode:
+//
+//	Tree{
+//		"README":         "# Project\n",
+//		"bin/run.sh 755": "#!/bin/sh\n",
+//		"empty/":         "",
+//		"private/ 700":   "",
+//	}
+//
+// The value is the contents of a file. Parent directories are implied. The
+// mode of the entries without one is not checked; files are created 0644 and
+// directories 0755.
+type Tree map[string]string
+
+// Options configures the comparison of trees.
+type Options struct {
+	// Ignore skips the entries it matches in the actual tree, like build
+	// artifacts or timestamps files.
+	Ignore *afero.FilterRules
+}
+
+type treeEntry struct {
+	path    string
+	dir     bool
+	mode    os.FileMode
+	hasMode bool
+	data    string
+}
+
+func parseKey(key, data string) (treeEntry, error) {
+	e := treeEntry{path: key, data: data}
+	if i := strings.LastIndexByte(key, ' '); i >= 0 {
+		if mode, err := strconv.ParseUint(key[i+1:], 8, 32); err == nil && i+1 < len(key) {
+			e.path, e.mode, e.hasMode = key[:i], os.FileMode(mode), true
+		}
+	}
+	if strings.HasSuffix(e.path, "/") {
+		e.dir = true
+		if data != "" {
+			return e, fmt.Errorf("aferotest: directory %q has contents", key)
+		}
+	}
+	e.path = strings.Trim(path.Clean("/"+e.path), "/")
+	if e.path == "" {
+		return e, fmt.Errorf("aferotest: invalid path %q", key)
+	}
+	return e, nil
+}
+
+func (tree Tree) entries() ([]treeEntry, error) {
+	var entries []treeEntry
+	for key, data := range tree {
+		e, err := parseKey(key, data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+// Build creates tree under root in fs.
+func Build(fs afero.Fs, root string, tree Tree) error {
+	entries, err := tree.entries()
+	if err != nil {
+		return err
+	}
+	// the modes of the directories are set once their contents exist, which
+	// could not be created in a read only directory
+	var dirs []treeEntry
+	for _, e := range entries {
+		name := filepath.Join(root, filepath.FromSlash(e.path))
+		if e.dir {
+			if e.hasMode {
+				dirs = append(dirs, e)
+			}
+			if err := fs.MkdirAll(name, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		mode := e.mode
+		if !e.hasMode {
+			mode = 0644
+		}
+		if err = fs.MkdirAll(filepath.Dir(name), 0755); err == nil {
+			err = afero.WriteFile(fs, name, []byte(e.data), mode)
+		}
+		// the mode is not subject to the umask
+		if err == nil && e.hasMode {
+			err = fs.Chmod(name, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := filepath.Join(root, filepath.FromSlash(dirs[i].path))
+		if err := fs.Chmod(name, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildTxtar creates the files of a txtar archive under root in fs.
+func BuildTxtar(fs afero.Fs, root string, archive []byte) error {
+	tree, err := ParseTxtar(archive)
+	if err != nil {
+		return err
+	}
+	return Build(fs, root, tree)
+}
+
+// NewFs returns a MemMapFs holding tree. It fails the test if tree is
+// invalid.
+func NewFs(t testing.TB, tree Tree) afero.Fs {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	if err := Build(fs, "/", tree); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// ReadTree returns the tree rooted at root in fs. Directories are listed
+// only if they are empty, and modes only if they differ from 0644 for files
+// and 0755 for directories. Symlinks are skipped.
+func ReadTree(fs afero.Fs, root string, opts *Options) (Tree, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	tree := make(Tree)
+	// the directories holding entries of the tree, which need not be listed
+	nonEmpty := make(map[string]bool)
+	listed := func(rel string) {
+		for dir := path.Dir(rel); !nonEmpty[dir]; dir = path.Dir(dir) {
+			nonEmpty[dir] = true
+			if dir == "." {
+				break
+			}
+		}
+	}
+	var dirs []string
+	err := afero.Walk(fs, root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if opts.Ignore.Match(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case fi.IsDir():
+			if fi.Mode().Perm() != 0755 {
+				tree[fmt.Sprintf("%s/ %o", rel, fi.Mode().Perm())] = ""
+				listed(rel)
+				nonEmpty[rel] = true
+			}
+			dirs = append(dirs, rel)
+			return nil
+		case !fi.Mode().IsRegular():
+			return nil
+		}
+		data, err := afero.ReadFile(fs, p)
+		if err != nil {
+			return err
+		}
+		key := rel
+		if fi.Mode().Perm() != 0644 {
+			key = fmt.Sprintf("%s %o", rel, fi.Mode().Perm())
+		}
+		tree[key] = string(data)
+		listed(rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// children first, as they make their parents non empty
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dir := dirs[i]; !nonEmpty[dir] {
+			tree[dir+"/"] = ""
+			listed(dir)
+		}
+	}
+	return tree, nil
+}
+
+// CheckTree compares the tree rooted at root in fs with want, and returns an
+// error describing their differences, or nil if they match. Contents are
+// compared exactly, modes only if want gives them.
+func CheckTree(fs afero.Fs, root string, want Tree, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	expected := afero.NewMemMapFs()
+	if err := Build(expected, "/", want); err != nil {
+		return err
+	}
+	d, err := afero.DiffTrees(expected, fs, &afero.DiffOptions{
+		RootB:       root,
+		Exclude:     opts.Ignore,
+		IgnoreModes: true,
+		Unified:     true,
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err := want.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.hasMode {
+			continue
+		}
+		fi, err := fs.Stat(filepath.Join(root, filepath.FromSlash(e.path)))
+		if err != nil || fi.Mode().Perm() == e.mode {
+			// missing entries are already reported
+			continue
+		}
+		efi, _ := expected.Stat("/" + e.path)
+		d.Entries = append(d.Entries, afero.DiffEntry{Path: e.path, Kind: afero.DiffModeChanged, A: efi, B: fi})
+	}
+	sort.SliceStable(d.Entries, func(i, j int) bool { return d.Entries[i].Path < d.Entries[j].Path })
+
+	if d.Empty() {
+		return nil
+	}
+	return fmt.Errorf("tree %s differs from the expected one (a: expected, b: actual):\n%s", root, d)
+}
+
+// AssertTree fails the test if the tree rooted at root in fs doesn't match
+// want, see CheckTree.
+func AssertTree(t testing.TB, fs afero.Fs, root string, want Tree, opts *Options) {
+	t.Helper()
+	if err := CheckTree(fs, root, want, opts); err != nil {
+		t.Error(err)
+	}
+}
+
+// AssertGolden fails the test if the tree rooted at root in fs doesn't
+// match the golden txtar archive at the OS path golden. With the
+// -aferotest.update flag, the golden archive is rewritten from fs instead,
+// keeping its comment. The files must end with a newline, which txtar can't
+// do without.
+func AssertGolden(t testing.TB, fs afero.Fs, root string, golden string, opts *Options) {
+	t.Helper()
+	if err := checkGolden(fs, root, golden, opts, *update); err != nil {
+		t.Error(err)
+	}
+}
+
+func checkGolden(fs afero.Fs, root, golden string, opts *Options, update bool) error {
+	data, err := ioutil.ReadFile(golden)
+	if err != nil && !(update && os.IsNotExist(err)) {
+		return err
+	}
+	comment, want, err := parseTxtar(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", golden, err)
+	}
+	if !update {
+		if err := CheckTree(fs, root, want, opts); err != nil {
+			return fmt.Errorf("%v\nrun the test with -aferotest.update to rewrite %s", err, golden)
+		}
+		return nil
+	}
+	tree, err := ReadTree(fs, root, opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(golden, FormatTxtar(comment, tree), 0644)
+}
@@ -0,0 +1,205 @@
+package aferotest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func buildProject(t *testing.T, fs afero.Fs) {
+	err := Build(fs, "/work", Tree{
+		"go.mod":         "module example.com/app\n",
+		"bin/run.sh 755": "#!/bin/sh\nexec ./app\n",
+		"build/":         "",
+		"private/ 700":   "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	buildProject(t, fs)
+	for name, mode := range map[string]os.FileMode{
+		"/work/go.mod":     0644,
+		"/work/bin":        os.ModeDir | 0755,
+		"/work/bin/run.sh": 0755,
+		"/work/build":      os.ModeDir | 0755,
+		"/work/private":    os.ModeDir | 0700,
+	} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("%s: mode %v, want %v", name, fi.Mode(), mode)
+		}
+	}
+
+	for _, bad := range []Tree{{"dir/": "data"}, {"/": ""}} {
+		if err := Build(fs, "/", bad); err == nil {
+			t.Errorf("%v: no error", bad)
+		}
+	}
+}
+
+func TestTxtar(t *testing.T) {
+	archive := "comment\n-- a --\nA\n-- d/b 600 --\n-- e/ --\n-- c --\nno newline"
+	comment, tree, err := parseTxtar([]byte(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Tree{"a": "A\n", "d/b 600": "", "e/": "", "c": "no newline"}
+	if comment != "comment\n" || !reflect.DeepEqual(tree, want) {
+		t.Errorf("got %q %v", comment, tree)
+	}
+	formatted := string(FormatTxtar(comment, tree))
+	if formatted != "comment\n-- a --\nA\n-- c --\nno newline\n-- d/b 600 --\n-- e/ --\n" {
+		t.Errorf("formatted:\n%s", formatted)
+	}
+
+	if _, err := ParseTxtar([]byte("-- a --\n-- a --\n")); err == nil {
+		t.Error("no error for a duplicate file")
+	}
+}
+
+func TestReadTree(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	buildProject(t, fs)
+	afero.WriteFile(fs, "/work/build/app", []byte("binary"), 0755)
+	fs.MkdirAll("/work/deep/empty", 0755)
+	fs.MkdirAll("/work/x/a/b", 0755)
+	fs.Chmod("/work/x/a/b", 0700)
+
+	tree, err := ReadTree(fs, "/work", &Options{Ignore: afero.MustCompileFilterRules("build/")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Tree{
+		"go.mod":         "module example.com/app\n",
+		"bin/run.sh 755": "#!/bin/sh\nexec ./app\n",
+		"deep/empty/":    "",
+		"private/ 700":   "",
+		"x/a/b/ 700":     "",
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Errorf("got %v\nwant %v", tree, want)
+	}
+}
+
+func TestCheckTree(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	buildProject(t, fs)
+	want := Tree{
+		"go.mod":         "module example.com/app\n",
+		"bin/run.sh 755": "#!/bin/sh\nexec ./app\n",
+		"build/":         "",
+		"private/":       "",
+	}
+	if err := CheckTree(fs, "/work", want, nil); err != nil {
+		t.Error(err)
+	}
+
+	afero.WriteFile(fs, "/work/go.mod", []byte("module example.com/other\n"), 0644)
+	afero.WriteFile(fs, "/work/build/app", []byte("binary"), 0755)
+	fs.Chmod("/work/bin/run.sh", 0644)
+	err := CheckTree(fs, "/work", want, nil)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, s := range []string{
+		"added           build/app",
+		"mode changed    bin/run.sh",
+		"-module example.com/app",
+		"+module example.com/other",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q not reported in:\n%v", s, err)
+		}
+	}
+
+	want["go.mod"] = "module example.com/other\n"
+	want["bin/run.sh"] = want["bin/run.sh 755"]
+	delete(want, "bin/run.sh 755")
+	AssertTree(t, fs, "/work", want, &Options{Ignore: afero.MustCompileFilterRules("build/app")})
+}
+
+func TestAssertGolden(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	buildProject(t, fs)
+	AssertGolden(t, fs, "/work", filepath.Join("testdata", "project.txtar"), nil)
+
+	dir, err := ioutil.TempDir("", "aferotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	golden := filepath.Join(dir, "golden.txtar")
+	if err := ioutil.WriteFile(golden, []byte("kept comment\n-- stale --\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGolden(fs, "/work", golden, nil, false); err == nil || !strings.Contains(err.Error(), "-aferotest.update") {
+		t.Errorf("stale golden file: %v", err)
+	}
+	if err := checkGolden(fs, "/work", golden, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(golden)
+	want, _ := ioutil.ReadFile(filepath.Join("testdata", "project.txtar"))
+	if got := strings.Replace(string(data), "kept comment", "The tree built by TestAssertGolden.", 1); got != string(want) {
+		t.Errorf("updated golden file:\n%s", data)
+	}
+	if err := checkGolden(fs, "/work", golden, nil, false); err != nil {
+		t.Error(err)
+	}
+}
+
+// permFs refuses, like an OsFs for a user other than root, to create entries
+// in the directories that aren't writable by their owner.
+type permFs struct {
+	afero.Fs
+}
+
+func (p permFs) check(name string) error {
+	fi, err := p.Fs.Stat(filepath.Dir(name))
+	if err == nil && fi.Mode().Perm()&0200 == 0 {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (p permFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if err := p.check(name); err != nil {
+		return nil, err
+	}
+	return p.Fs.OpenFile(name, flag, perm)
+}
+
+func (p permFs) MkdirAll(path string, perm os.FileMode) error {
+	if _, err := p.Fs.Stat(path); os.IsNotExist(err) {
+		if err := p.check(path); err != nil {
+			return err
+		}
+	}
+	return p.Fs.MkdirAll(path, perm)
+}
+
+func TestBuildReadOnlyDir(t *testing.T) {
+	fs := permFs{afero.NewMemMapFs()}
+	err := BuildTxtar(fs, "/work", []byte("-- ro/ 555 --\n-- ro/sub/f --\nf\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("/work/ro"); err != nil || fi.Mode().Perm() != 0555 {
+		t.Errorf("ro: %v, %v", fi, err)
+	}
+	if b, err := afero.ReadFile(fs, "/work/ro/sub/f"); err != nil || string(b) != "f\n" {
+		t.Errorf("ro/sub/f: %q, %v", b, err)
+	}
+}
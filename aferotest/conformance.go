@@ -0,0 +1,699 @@
+package aferotest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Skip selects the checks of Conformance that don't apply to an Fs.
+type Skip uint
+
+const (
+	// SkipSymlinks skips the symlinks checks, through afero.Linker,
+	// afero.LinkReader and afero.Lstater.
+	SkipSymlinks Skip = 1 << iota
+	// SkipChmod skips the checks of Chmod and of the modes of files.
+	SkipChmod
+	// SkipChtimes skips the checks of Chtimes.
+	SkipChtimes
+)
+
+// Factory returns the Fs checked by Conformance, and an existing empty
+// directory of it in which the checks run. The directory is removed with
+// RemoveAll after each check.
+type Factory func(t *testing.T) (fs afero.Fs, dir string)
+
+type conformanceCheck struct {
+	name string
+	skip Skip
+	fn   func(t *testing.T, fs afero.Fs, dir string)
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"Create", 0, checkCreate},
+	{"OpenMissing", 0, checkOpenMissing},
+	{"OpenFile", 0, checkOpenFile},
+	{"ReadWriteSeek", 0, checkReadWriteSeek},
+	{"Truncate", 0, checkTruncate},
+	{"Readdir", 0, checkReaddir},
+	{"Mkdir", 0, checkMkdir},
+	{"Remove", 0, checkRemove},
+	{"RemoveAll", 0, checkRemoveAll},
+	{"Rename", 0, checkRename},
+	{"RenameDir", 0, checkRenameDir},
+	{"Closed", 0, checkClosed},
+	{"Errors", 0, checkErrors},
+	{"Chmod", SkipChmod, checkChmod},
+	{"Chtimes", SkipChtimes, checkChtimes},
+	{"Symlink", SkipSymlinks, checkSymlink},
+}
+
+// Conformance checks that the Fs returned by newFs behaves like OsFs on
+// Unix, running each Fs and File method through its success and error
+// cases. The checks selected by skip are not run. The errors are checked
+// with os.IsExist and os.IsNotExist when OsFs returns such errors, and
+// only for being non nil otherwise. The errors of the Fs methods must be
+// an *os.PathError, or an *os.LinkError for Rename, naming the operation
+// and the path.
+func Conformance(t *testing.T, newFs Factory, skip Skip) {
+	for _, c := range conformanceChecks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if skip&c.skip != 0 {
+				t.Skip("skipped for this Fs")
+			}
+			fs, dir := newFs(t)
+			defer fs.RemoveAll(dir)
+			c.fn(t, fs, dir)
+		})
+	}
+}
+
+func writeFile(t *testing.T, fs afero.Fs, name, data string) {
+	t.Helper()
+	if err := afero.WriteFile(fs, name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mkdirAll(t *testing.T, fs afero.Fs, name string) {
+	t.Helper()
+	if err := fs.MkdirAll(name, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkContents fails the test if the file name doesn't hold want.
+func checkContents(t *testing.T, fs afero.Fs, name, want string) {
+	t.Helper()
+	data, err := afero.ReadFile(fs, name)
+	if err != nil {
+		t.Errorf("read %s: %v", name, err)
+	} else if string(data) != want {
+		t.Errorf("%s holds %q, want %q", name, data, want)
+	}
+}
+
+func checkExists(t *testing.T, fs afero.Fs, name string, dir bool) {
+	t.Helper()
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Errorf("stat %s: %v", name, err)
+	} else if fi.IsDir() != dir {
+		t.Errorf("%s: IsDir() = %v, want %v", name, fi.IsDir(), dir)
+	}
+}
+
+func checkNotExist(t *testing.T, fs afero.Fs, name string) {
+	t.Helper()
+	if _, err := fs.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("stat %s: got %v, want a not exist error", name, err)
+	}
+}
+
+func checkErr(t *testing.T, op string, err error, is func(error) bool) {
+	t.Helper()
+	switch {
+	case err == nil:
+		t.Errorf("%s: no error", op)
+	case is != nil && !is(err):
+		t.Errorf("%s: unexpected error %v", op, err)
+	}
+}
+
+func checkCreate(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != name {
+		t.Errorf("Name() = %q, want %q", f.Name(), name)
+	}
+	if n, err := f.Write([]byte("hello")); n != 5 || err != nil {
+		t.Errorf("write: %d, %v", n, err)
+	}
+	if n, err := f.WriteString(" world"); n != 6 || err != nil {
+		t.Errorf("WriteString: %d, %v", n, err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != 11 || fi.IsDir() || fi.Name() != "file" {
+		t.Errorf("File.Stat: %v, %v", fi, err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Errorf("sync: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	checkContents(t, fs, name, "hello world")
+	if fi, err := fs.Stat(name); err != nil || fi.Size() != 11 || fi.Name() != "file" || !fi.Mode().IsRegular() {
+		t.Errorf("stat: %v, %v", fi, err)
+	}
+
+	// Create truncates an existing file
+	f, err = fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkContents(t, fs, name, "")
+
+	_, err = fs.Create(filepath.Join(dir, "missing", "file"))
+	checkErr(t, "create in a missing directory", err, os.IsNotExist)
+	_, err = fs.Create(dir)
+	checkErr(t, "create a directory", err, nil)
+}
+
+func checkOpenMissing(t *testing.T, fs afero.Fs, dir string) {
+	_, err := fs.Open(filepath.Join(dir, "missing"))
+	checkErr(t, "open", err, os.IsNotExist)
+	_, err = fs.Open(filepath.Join(dir, "missing", "file"))
+	checkErr(t, "open in a missing directory", err, os.IsNotExist)
+	_, err = fs.Stat(filepath.Join(dir, "missing"))
+	checkErr(t, "stat", err, os.IsNotExist)
+	_, err = fs.OpenFile(filepath.Join(dir, "missing"), os.O_RDWR, 0644)
+	checkErr(t, "OpenFile without O_CREATE", err, os.IsNotExist)
+}
+
+func checkOpenFile(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("hello")
+	f.Close()
+	_, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	checkErr(t, "O_EXCL on an existing file", err, os.IsExist)
+
+	f, err = fs.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" world")
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Error("read from a write only file: no error")
+	}
+	f.Close()
+	checkContents(t, fs, name, "hello world")
+
+	f, err = fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("write to a read only file: no error")
+	}
+	f.Close()
+	checkContents(t, fs, name, "hello world")
+
+	f, err = fs.OpenFile(name, os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("bye")
+	f.Close()
+	checkContents(t, fs, name, "bye")
+
+	f, err = fs.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("B")
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "ye" {
+		t.Errorf("read after write: %q, %v", data, err)
+	}
+	f.Close()
+	checkContents(t, fs, name, "Bye")
+
+	_, err = fs.OpenFile(filepath.Join(dir, "missing", "file"), os.O_RDWR|os.O_CREATE, 0644)
+	checkErr(t, "O_CREATE in a missing directory", err, os.IsNotExist)
+}
+
+func checkReadWriteSeek(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("0123456789")
+
+	if off, err := f.Seek(2, io.SeekStart); off != 2 || err != nil {
+		t.Errorf("seek: %d, %v", off, err)
+	}
+	buf := make([]byte, 3)
+	if n, err := io.ReadFull(f, buf); n != 3 || err != nil || string(buf) != "234" {
+		t.Errorf("read: %q, %v", buf[:n], err)
+	}
+	if off, err := f.Seek(1, io.SeekCurrent); off != 6 || err != nil {
+		t.Errorf("seek from the current offset: %d, %v", off, err)
+	}
+	if off, err := f.Seek(-1, io.SeekEnd); off != 9 || err != nil {
+		t.Errorf("seek from the end: %d, %v", off, err)
+	}
+	if n, err := f.Read(buf); n != 1 || buf[0] != '9' {
+		t.Errorf("read the last byte: %q, %v", buf[:n], err)
+	}
+	if n, err := f.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("read at the end: %d, %v", n, err)
+	}
+
+	if n, err := f.WriteAt([]byte("ab"), 4); n != 2 || err != nil {
+		t.Errorf("WriteAt: %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, 3); n != 3 || err != nil || string(buf) != "3ab" {
+		t.Errorf("ReadAt: %q, %v", buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, 8); n != 2 || err != io.EOF {
+		t.Errorf("ReadAt across the end: %d, %v", n, err)
+	}
+
+	// writing past the end leaves a hole of zeros
+	f.Seek(12, io.SeekStart)
+	f.WriteString("x")
+	f.Close()
+	checkContents(t, fs, filepath.Join(dir, "file"), "0123ab6789\x00\x00x")
+}
+
+func checkTruncate(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("0123456789")
+	if err := f.Truncate(4); err != nil {
+		t.Errorf("truncate: %v", err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != 4 {
+		t.Errorf("size after truncate: %v, %v", fi, err)
+	}
+	if err := f.Truncate(6); err != nil {
+		t.Errorf("extend: %v", err)
+	}
+	f.Close()
+	checkContents(t, fs, name, "0123\x00\x00")
+}
+
+func checkReaddir(t *testing.T, fs afero.Fs, dir string) {
+	for _, name := range []string{"c", "a", "b"} {
+		writeFile(t, fs, filepath.Join(dir, name), name)
+	}
+	mkdirAll(t, fs, filepath.Join(dir, "d", "sub"))
+
+	f, err := fs.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		t.Errorf("File.Stat of a directory: %v, %v", fi, err)
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+		if fi.IsDir() != (fi.Name() == "d") {
+			t.Errorf("%s: IsDir() = %v", fi.Name(), fi.IsDir())
+		}
+		if !fi.IsDir() && fi.Size() != 1 {
+			t.Errorf("%s: size %d", fi.Name(), fi.Size())
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"a", "b", "c", "d"}; !equalStrings(names, want) {
+		t.Errorf("Readdir(-1): %v, want %v", names, want)
+	}
+
+	// by pages
+	f, err = fs.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = nil
+	for i := 0; ; i++ {
+		page, err := f.Readdirnames(3)
+		names = append(names, page...)
+		if err == io.EOF {
+			if len(page) != 0 {
+				t.Errorf("Readdirnames returned %d names with io.EOF", len(page))
+			}
+			break
+		}
+		if err != nil || i > 4 {
+			t.Fatalf("Readdirnames(3): %v", err)
+		}
+		if len(page) == 0 || len(page) > 3 {
+			t.Errorf("Readdirnames(3) returned %d names", len(page))
+		}
+	}
+	f.Close()
+	sort.Strings(names)
+	if want := []string{"a", "b", "c", "d"}; !equalStrings(names, want) {
+		t.Errorf("Readdirnames(3): %v, want %v", names, want)
+	}
+
+	f, err = fs.Open(filepath.Join(dir, "d", "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := f.Readdir(-1); len(entries) != 0 || err != nil {
+		t.Errorf("Readdir(-1) of an empty directory: %v, %v", entries, err)
+	}
+	f.Close()
+
+	f, err = fs.Open(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Readdir(-1)
+	checkErr(t, "Readdir of a file", err, nil)
+	f.Close()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func checkMkdir(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "d")
+	if err := fs.Mkdir(name, 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, fs, name, true)
+	checkErr(t, "mkdir an existing directory", fs.Mkdir(name, 0755), os.IsExist)
+	checkErr(t, "mkdir in a missing directory", fs.Mkdir(filepath.Join(dir, "missing", "d"), 0755), os.IsNotExist)
+
+	if err := fs.MkdirAll(filepath.Join(dir, "a", "b", "c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, fs, filepath.Join(dir, "a", "b"), true)
+	checkExists(t, fs, filepath.Join(dir, "a", "b", "c"), true)
+	if err := fs.MkdirAll(filepath.Join(dir, "a", "b"), 0755); err != nil {
+		t.Errorf("MkdirAll of an existing directory: %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	writeFile(t, fs, file, "")
+	checkErr(t, "mkdir over a file", fs.Mkdir(file, 0755), os.IsExist)
+	checkErr(t, "MkdirAll over a file", fs.MkdirAll(file, 0755), nil)
+	checkErr(t, "MkdirAll through a file", fs.MkdirAll(filepath.Join(file, "d"), 0755), nil)
+	checkExists(t, fs, file, false)
+}
+
+func checkRemove(t *testing.T, fs afero.Fs, dir string) {
+	file := filepath.Join(dir, "file")
+	writeFile(t, fs, file, "data")
+	if err := fs.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, fs, file)
+	checkErr(t, "remove a missing file", fs.Remove(file), os.IsNotExist)
+
+	sub := filepath.Join(dir, "d")
+	writeFile(t, fs, filepath.Join(dir, "d-file"), "a sibling with the same prefix")
+	mkdirAll(t, fs, sub)
+	writeFile(t, fs, filepath.Join(sub, "file"), "")
+	checkErr(t, "remove a non empty directory", fs.Remove(sub), nil)
+	checkExists(t, fs, filepath.Join(sub, "file"), false)
+
+	if err := fs.Remove(filepath.Join(sub, "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(sub); err != nil {
+		t.Errorf("remove an empty directory: %v", err)
+	}
+	checkNotExist(t, fs, sub)
+	checkExists(t, fs, filepath.Join(dir, "d-file"), false)
+}
+
+func checkRemoveAll(t *testing.T, fs afero.Fs, dir string) {
+	root := filepath.Join(dir, "root")
+	mkdirAll(t, fs, filepath.Join(root, "a", "b"))
+	mkdirAll(t, fs, filepath.Join(root, "empty"))
+	writeFile(t, fs, filepath.Join(root, "file"), "")
+	writeFile(t, fs, filepath.Join(root, "a", "b", "file"), "")
+	writeFile(t, fs, filepath.Join(dir, "root2"), "a sibling with the same prefix")
+
+	if err := fs.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, fs, root)
+	checkNotExist(t, fs, filepath.Join(root, "a", "b", "file"))
+	checkExists(t, fs, filepath.Join(dir, "root2"), false)
+
+	if err := fs.RemoveAll(root); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+	if err := fs.RemoveAll(filepath.Join(dir, "root2")); err != nil {
+		t.Errorf("RemoveAll of a file: %v", err)
+	}
+	checkNotExist(t, fs, filepath.Join(dir, "root2"))
+}
+
+func checkRename(t *testing.T, fs afero.Fs, dir string) {
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFile(t, fs, a, "a")
+	if err := fs.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, fs, a)
+	checkContents(t, fs, b, "a")
+
+	if err := fs.Rename(b, b); err != nil {
+		t.Errorf("rename to itself: %v", err)
+	}
+	checkContents(t, fs, b, "a")
+
+	// the target is replaced
+	writeFile(t, fs, a, "new")
+	if err := fs.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, fs, a)
+	checkContents(t, fs, b, "new")
+
+	checkErr(t, "rename a missing file", fs.Rename(a, filepath.Join(dir, "c")), os.IsNotExist)
+	checkErr(t, "rename to a missing directory", fs.Rename(b, filepath.Join(dir, "missing", "b")), os.IsNotExist)
+	checkContents(t, fs, b, "new")
+
+	d := filepath.Join(dir, "d")
+	mkdirAll(t, fs, d)
+	checkErr(t, "rename a file over a directory", fs.Rename(b, d), nil)
+	checkContents(t, fs, b, "new")
+	checkExists(t, fs, d, true)
+}
+
+func checkRenameDir(t *testing.T, fs afero.Fs, dir string) {
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	mkdirAll(t, fs, filepath.Join(src, "sub"))
+	writeFile(t, fs, filepath.Join(src, "file"), "file")
+	writeFile(t, fs, filepath.Join(src, "sub", "file"), "sub")
+	writeFile(t, fs, filepath.Join(dir, "src2"), "a sibling with the same prefix")
+
+	if err := fs.Rename(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, fs, src)
+	checkNotExist(t, fs, filepath.Join(src, "sub", "file"))
+	checkContents(t, fs, filepath.Join(dst, "file"), "file")
+	checkContents(t, fs, filepath.Join(dst, "sub", "file"), "sub")
+	checkContents(t, fs, filepath.Join(dir, "src2"), "a sibling with the same prefix")
+
+	f, err := fs.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	sort.Strings(names)
+	if want := []string{"file", "sub"}; err != nil || !equalStrings(names, want) {
+		t.Errorf("entries of the renamed directory: %v, %v", names, err)
+	}
+
+	checkErr(t, "rename a directory into itself", fs.Rename(dst, filepath.Join(dst, "sub", "dst")), nil)
+	checkErr(t, "rename a directory over a file", fs.Rename(dst, filepath.Join(dir, "src2")), nil)
+	checkContents(t, fs, filepath.Join(dir, "src2"), "a sibling with the same prefix")
+	checkContents(t, fs, filepath.Join(dst, "sub", "file"), "sub")
+}
+
+func checkClosed(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("data")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("write to a closed file: no error")
+	}
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Error("read from a closed file: no error")
+	}
+	checkContents(t, fs, filepath.Join(dir, "file"), "data")
+}
+
+func checkChmod(t *testing.T, fs afero.Fs, dir string) {
+	file, sub := filepath.Join(dir, "file"), filepath.Join(dir, "d")
+	writeFile(t, fs, file, "")
+	mkdirAll(t, fs, sub)
+	for name, mode := range map[string]os.FileMode{file: 0600, sub: 0700} {
+		if err := fs.Chmod(name, mode); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("%s: mode %v, want %v", name, fi.Mode(), mode)
+		}
+		if fi.IsDir() != (name == sub) {
+			t.Errorf("%s: chmod changed the type to %v", name, fi.Mode())
+		}
+	}
+	checkErr(t, "chmod a missing file", fs.Chmod(filepath.Join(dir, "missing"), 0644), os.IsNotExist)
+
+	f, err := fs.OpenFile(filepath.Join(dir, "new"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if fi, err := fs.Stat(filepath.Join(dir, "new")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("mode of a file created 0600: %v, %v", fi, err)
+	}
+}
+
+func checkChtimes(t *testing.T, fs afero.Fs, dir string) {
+	file := filepath.Join(dir, "file")
+	writeFile(t, fs, file, "")
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := fs.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(file); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("ModTime after chtimes: %v, %v", fi, err)
+	}
+	checkErr(t, "chtimes a missing file", fs.Chtimes(filepath.Join(dir, "missing"), mtime, mtime), os.IsNotExist)
+}
+
+func checkSymlink(t *testing.T, fs afero.Fs, dir string) {
+	linker, ok1 := fs.(afero.Linker)
+	reader, ok2 := fs.(afero.LinkReader)
+	lstater, ok3 := fs.(afero.Lstater)
+	if !ok1 || !ok2 || !ok3 {
+		t.Fatalf("%T doesn't implement Linker, LinkReader and Lstater", fs)
+	}
+	writeFile(t, fs, filepath.Join(dir, "file"), "data")
+	link := filepath.Join(dir, "link")
+	if err := linker.SymlinkIfPossible("file", link); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, lstat, err := lstater.LstatIfPossible(link)
+	if err != nil || !lstat || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat: %v, %v, %v", fi, lstat, err)
+	}
+	if target, err := reader.ReadlinkIfPossible(link); err != nil || target != "file" {
+		t.Errorf("readlink: %q, %v", target, err)
+	}
+	if fi, err := fs.Stat(link); err != nil || fi.Mode()&os.ModeSymlink != 0 || fi.Size() != 4 {
+		t.Errorf("stat follows the link: %v, %v", fi, err)
+	}
+	checkContents(t, fs, link, "data")
+	if fi, _, err := lstater.LstatIfPossible(filepath.Join(dir, "file")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("lstat of a file: %v, %v", fi, err)
+	}
+
+	checkErr(t, "symlink over an existing file", linker.SymlinkIfPossible("file", link), os.IsExist)
+	_, err = reader.ReadlinkIfPossible(filepath.Join(dir, "file"))
+	checkErr(t, "readlink of a file", err, nil)
+
+	dangling := filepath.Join(dir, "dangling")
+	if err := linker.SymlinkIfPossible("missing", dangling); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := lstater.LstatIfPossible(dangling); err != nil {
+		t.Errorf("lstat of a dangling link: %v", err)
+	}
+	checkNotExist(t, fs, dangling)
+
+	if err := fs.Remove(link); err != nil {
+		t.Errorf("remove a link: %v", err)
+	}
+	checkContents(t, fs, filepath.Join(dir, "file"), "data")
+}
+
+// checkPathError fails the test if err isn't an *os.PathError for path
+// wrapping target.
+func checkPathError(t *testing.T, op string, err error, path string, target error) {
+	t.Helper()
+	var pathErr *os.PathError
+	switch {
+	case err == nil:
+		t.Errorf("%s: no error", op)
+	case !errors.As(err, &pathErr):
+		t.Errorf("%s: %T is not an *os.PathError: %v", op, err, err)
+	case pathErr.Op == "" || filepath.Base(pathErr.Path) != filepath.Base(path):
+		t.Errorf("%s: the operation or the path is missing in %v", op, err)
+	case target != nil && !errors.Is(err, target):
+		t.Errorf("%s: %v is not %v", op, err, target)
+	}
+}
+
+func checkErrors(t *testing.T, fs afero.Fs, dir string) {
+	missing := filepath.Join(dir, "missing")
+	file := filepath.Join(dir, "file")
+	sub := filepath.Join(dir, "d")
+	writeFile(t, fs, file, "")
+	mkdirAll(t, fs, sub)
+	writeFile(t, fs, filepath.Join(sub, "file"), "")
+
+	_, err := fs.Open(missing)
+	checkPathError(t, "open", err, missing, os.ErrNotExist)
+	_, err = fs.OpenFile(missing, os.O_RDWR, 0644)
+	checkPathError(t, "OpenFile", err, missing, os.ErrNotExist)
+	_, err = fs.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	checkPathError(t, "OpenFile with O_EXCL", err, file, os.ErrExist)
+	_, err = fs.Create(filepath.Join(missing, "file"))
+	checkPathError(t, "create", err, filepath.Join(missing, "file"), os.ErrNotExist)
+	_, err = fs.Stat(missing)
+	checkPathError(t, "stat", err, missing, os.ErrNotExist)
+	checkPathError(t, "mkdir", fs.Mkdir(sub, 0755), sub, os.ErrExist)
+	checkPathError(t, "remove", fs.Remove(missing), missing, os.ErrNotExist)
+	checkPathError(t, "remove a non empty directory", fs.Remove(sub), sub, nil)
+	checkPathError(t, "chmod", fs.Chmod(missing, 0644), missing, os.ErrNotExist)
+	checkPathError(t, "chtimes", fs.Chtimes(missing, time.Now(), time.Now()), missing, os.ErrNotExist)
+	_, err = fs.Open(filepath.Join(file, "child"))
+	checkPathError(t, "open under a file", err, filepath.Join(file, "child"), nil)
+	if !afero.IsNotDir(err) {
+		t.Errorf("open under a file: %v is not ErrNotDir", err)
+	}
+
+	var linkErr *os.LinkError
+	err = fs.Rename(missing, filepath.Join(dir, "other"))
+	if !errors.As(err, &linkErr) || linkErr.Op == "" || !os.IsNotExist(err) {
+		t.Errorf("rename: %T %v is not an *os.LinkError for a missing file", err, err)
+	}
+}
@@ -0,0 +1,51 @@
+package aferotest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func newMemMapFs(t *testing.T) (afero.Fs, string) {
+	fs := afero.NewMemMapFs()
+	mkdirAll(t, fs, "/test")
+	return fs, "/test"
+}
+
+func TestConformanceMemMapFs(t *testing.T) {
+	Conformance(t, newMemMapFs, SkipSymlinks)
+}
+
+func TestConformanceOsFs(t *testing.T) {
+	Conformance(t, func(t *testing.T) (afero.Fs, string) {
+		dir, err := ioutil.TempDir("", "aferotest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return afero.NewOsFs(), dir
+	}, 0)
+}
+
+func TestConformanceBasePathFs(t *testing.T) {
+	Conformance(t, func(t *testing.T) (afero.Fs, string) {
+		base, dir := newMemMapFs(t)
+		return afero.NewBasePathFs(base, dir), "/"
+	}, SkipSymlinks)
+}
+
+func TestConformanceCopyOnWriteFs(t *testing.T) {
+	Conformance(t, func(t *testing.T) (afero.Fs, string) {
+		base, dir := newMemMapFs(t)
+		return afero.NewCopyOnWriteFs(base, afero.NewMemMapFs()), dir
+	}, SkipSymlinks)
+}
+
+func TestConformanceCaseInsensitiveFs(t *testing.T) {
+	Conformance(t, func(t *testing.T) (afero.Fs, string) {
+		base, dir := newMemMapFs(t)
+		return afero.NewCaseInsensitiveFs(base, afero.CaseInsensitiveFsOptions{}), dir
+	}, SkipSymlinks)
+}
@@ -0,0 +1,92 @@
+package aferotest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseTxtar parses a txtar archive: an optional comment followed by files,
+// each introduced by a "-- name --" marker line. The names are Tree keys.
+func ParseTxtar(data []byte) (Tree, error) {
+	_, tree, err := parseTxtar(data)
+	return tree, err
+}
+
+// parseTxtar returns the comment and the files of a txtar archive.
+func parseTxtar(data []byte) (string, Tree, error) {
+	tree := make(Tree)
+	var (
+		comment strings.Builder
+		name    string
+		file    *strings.Builder
+	)
+	flush := func() error {
+		if file == nil {
+			return nil
+		}
+		if _, ok := tree[name]; ok {
+			return fmt.Errorf("txtar: duplicate file %q", name)
+		}
+		tree[name] = file.String()
+		return nil
+	}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		line := data[:i]
+		data = data[i:]
+		if marker, ok := txtarMarker(line); ok {
+			if err := flush(); err != nil {
+				return "", nil, err
+			}
+			name, file = marker, &strings.Builder{}
+			continue
+		}
+		if file == nil {
+			comment.Write(line)
+		} else {
+			file.Write(line)
+		}
+	}
+	if err := flush(); err != nil {
+		return "", nil, err
+	}
+	return comment.String(), tree, nil
+}
+
+func txtarMarker(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") || len(s) < 7 {
+		return "", false
+	}
+	return strings.TrimSpace(s[3 : len(s)-3]), true
+}
+
+// FormatTxtar returns the txtar archive of tree, sorted by name, preceded by
+// comment. As txtar can't represent them, a newline is added to the files
+// that don't end with one.
+func FormatTxtar(comment string, tree Tree) []byte {
+	var b bytes.Buffer
+	b.WriteString(comment)
+	if comment != "" && !strings.HasSuffix(comment, "\n") {
+		b.WriteByte('\n')
+	}
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "-- %s --\n", key)
+		data := tree[key]
+		b.WriteString(data)
+		if data != "" && !strings.HasSuffix(data, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
@@ -1,60 +1,179 @@
 package afero
 
 import (
+	"errors"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 )
 
 var _ Lstater = (*BasePathFs)(nil)
 
+// ErrEscapesBasePath is returned by a confined BasePathFs for names that
+// resolve, through symlinks, to a path outside of the base path.
+var ErrEscapesBasePath = errors.New("path escapes from base path")
+
+// maxBasePathLinks is the number of symlinks a confined BasePathFs follows
+// while resolving a single name, like the limit of the Linux kernel.
+const maxBasePathLinks = 40
+
 // The BasePathFs restricts all operations to a given path within an Fs.
 // The given file name to the operations on this Fs will be prepended with
 // the base path before calling the base Fs.
 // Any file name (after filepath.Clean()) outside this base path will be
 // treated as non existing file.
 //
+// Symlinks are not checked by default: a link inside the base path may
+// point anywhere on the base Fs. See NewConfinedBasePathFs.
+//
+// The names follow the path style of the source Fs, as reported by
+// Capabilities, so a BasePathFs over a MemMapFs emulating Windows paths
+// joins them like on Windows.
+//
 // Note that it does not clean the error messages on return, so you may
 // reveal the real path on errors.
 type BasePathFs struct {
-	source Fs
-	path   string
+	source   Fs
+	path     string
+	confined bool
+	style    PathStyle
 }
 
 type BasePathFile struct {
 	File
-	path string
+	path  string
+	style PathStyle
 }
 
 func (f *BasePathFile) Name() string {
 	sourcename := f.File.Name()
-	return strings.TrimPrefix(sourcename, filepath.Clean(f.path))
+	return strings.TrimPrefix(sourcename, f.style.clean(f.path))
 }
 
 func NewBasePathFs(source Fs, path string) Fs {
-	return &BasePathFs{source: source, path: path}
+	return &BasePathFs{source: source, path: path, style: pathStyleOf(source)}
+}
+
+// NewConfinedBasePathFs returns a BasePathFs that also refuses to follow
+// symlinks out of the base path, like openat2 with RESOLVE_BENEATH does.
+// Names are resolved one component at a time with LstatIfPossible and
+// ReadlinkIfPossible on the source Fs, a name leading outside of the base
+// path fails with ErrEscapesBasePath. Links to absolute paths are allowed
+// as long as they point inside the base path. Operations acting on a symlink
+// itself, like Lstat, Readlink, Remove or Rename, don't follow the last
+// component of the name.
+//
+// The check is made before the call to the source Fs, it does not protect
+// against symlinks being swapped concurrently by another process.
+func NewConfinedBasePathFs(source Fs, path string) Fs {
+	return &BasePathFs{source: source, path: path, confined: true, style: pathStyleOf(source)}
 }
 
 // on a file outside the base path it returns the given file name and an error,
 // else the given file with the base path prepended
 func (b *BasePathFs) RealPath(name string) (path string, err error) {
-	if err := validateBasePathName(name); err != nil {
+	return b.realPath(name, true)
+}
+
+// realPath is RealPath, resolving the symlinks of confined filesystems. The
+// last component of name is only resolved if follow is set.
+func (b *BasePathFs) realPath(name string, follow bool) (string, error) {
+	path, err := b.lexicalPath(name)
+	if err != nil || !b.confined {
+		return path, err
+	}
+	resolved, err := b.resolve(path, follow)
+	if err != nil {
 		return name, err
 	}
+	return resolved, nil
+}
 
-	bpath := filepath.Clean(b.path)
-	path = filepath.Clean(filepath.Join(bpath, name))
-	if !strings.HasPrefix(path, bpath) {
+// lexicalPath prepends the base path to name, without looking at the
+// source Fs.
+func (b *BasePathFs) lexicalPath(name string) (path string, err error) {
+	if err := b.style.validateBasePathName(name); err != nil {
+		return name, err
+	}
+
+	bpath := b.style.clean(b.path)
+	path = b.style.join(bpath, name)
+	if !b.style.inBasePath(bpath, path) {
 		return name, os.ErrNotExist
 	}
 
 	return path, nil
 }
 
-func validateBasePathName(name string) error {
-	if runtime.GOOS != "windows" {
+// inBasePath reports whether the clean path is bpath or below it. The
+// comparison is made on whole path components, so /srv/database is not
+// in /srv/data.
+func (s PathStyle) inBasePath(bpath, path string) bool {
+	if !strings.HasPrefix(path, bpath) {
+		return false
+	}
+	if len(path) == len(bpath) || s.isSeparator(bpath[len(bpath)-1]) {
+		return true
+	}
+	return s.isSeparator(path[len(bpath)])
+}
+
+// resolve follows the symlinks in path, which must be clean and within the
+// base path, and returns the path it leads to.
+func (b *BasePathFs) resolve(path string, follow bool) (string, error) {
+	bpath := b.style.clean(b.path)
+	reader, canReadlink := b.source.(LinkReader)
+	rest := b.style.splitNames(path[len(bpath):])
+	cur := bpath
+	links := 0
+	for len(rest) > 0 {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if cur == bpath {
+				return "", ErrEscapesBasePath
+			}
+			cur = b.style.dir(cur)
+			continue
+		}
+		next := b.style.join(cur, c)
+		if len(rest) == 0 && !follow {
+			cur = next
+			break
+		}
+		fi, err := lstatIfPossible(b.source, next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 || !canReadlink {
+			// missing entries are left to the source Fs to report
+			cur = next
+			continue
+		}
+		if links++; links > maxBasePathLinks {
+			return "", syscall.ELOOP
+		}
+		target, err := reader.ReadlinkIfPossible(next)
+		if err != nil {
+			return "", err
+		}
+		if b.style.isAbs(target) {
+			target = b.style.clean(target)
+			if !b.style.inBasePath(bpath, target) {
+				return "", ErrEscapesBasePath
+			}
+			target = target[len(bpath):]
+			cur = bpath
+		}
+		rest = append(b.style.splitNames(target), rest...)
+	}
+	return cur, nil
+}
+
+func (s PathStyle) validateBasePathName(name string) error {
+	if runtime.GOOS != "windows" && s != WindowsPaths {
 		// Not much to do here;
 		// the virtual file paths all look absolute on *nix.
 		return nil
@@ -62,7 +181,7 @@ func validateBasePathName(name string) error {
 
 	// On Windows a common mistake would be to provide an absolute OS path
 	// We could strip out the base part, but that would not be very portable.
-	if filepath.IsAbs(name) {
+	if s.isAbs(name) {
 		return os.ErrNotExist
 	}
 
@@ -87,6 +206,19 @@ func (b *BasePathFs) Name() string {
 	return "BasePathFs"
 }
 
+func (b *BasePathFs) Capabilities() Features {
+	return wrapperFeatures(b, b.source)
+}
+
+func (b *BasePathFs) Unwrap() []Fs {
+	return []Fs{b.source}
+}
+
+func (b *BasePathFs) mapPath(name string) (Fs, string, bool) {
+	path, err := b.RealPath(name)
+	return b.source, path, err == nil
+}
+
 func (b *BasePathFs) Stat(name string) (fi os.FileInfo, err error) {
 	if name, err = b.RealPath(name); err != nil {
 		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
@@ -95,24 +227,24 @@ func (b *BasePathFs) Stat(name string) (fi os.FileInfo, err error) {
 }
 
 func (b *BasePathFs) Rename(oldname, newname string) (err error) {
-	if oldname, err = b.RealPath(oldname); err != nil {
+	if oldname, err = b.realPath(oldname, false); err != nil {
 		return &os.PathError{Op: "rename", Path: oldname, Err: err}
 	}
-	if newname, err = b.RealPath(newname); err != nil {
+	if newname, err = b.realPath(newname, false); err != nil {
 		return &os.PathError{Op: "rename", Path: newname, Err: err}
 	}
 	return b.source.Rename(oldname, newname)
 }
 
 func (b *BasePathFs) RemoveAll(name string) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, false); err != nil {
 		return &os.PathError{Op: "remove_all", Path: name, Err: err}
 	}
 	return b.source.RemoveAll(name)
 }
 
 func (b *BasePathFs) Remove(name string) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, false); err != nil {
 		return &os.PathError{Op: "remove", Path: name, Err: err}
 	}
 	return b.source.Remove(name)
@@ -126,7 +258,7 @@ func (b *BasePathFs) OpenFile(name string, flag int, mode os.FileMode) (f File,
 	if err != nil {
 		return nil, err
 	}
-	return &BasePathFile{sourcef, b.path}, nil
+	return &BasePathFile{sourcef, b.path, b.style}, nil
 }
 
 func (b *BasePathFs) Open(name string) (f File, err error) {
@@ -137,11 +269,11 @@ func (b *BasePathFs) Open(name string) (f File, err error) {
 	if err != nil {
 		return nil, err
 	}
-	return &BasePathFile{File: sourcef, path: b.path}, nil
+	return &BasePathFile{File: sourcef, path: b.path, style: b.style}, nil
 }
 
 func (b *BasePathFs) Mkdir(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, false); err != nil {
 		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 	return b.source.Mkdir(name, mode)
@@ -162,11 +294,11 @@ func (b *BasePathFs) Create(name string) (f File, err error) {
 	if err != nil {
 		return nil, err
 	}
-	return &BasePathFile{File: sourcef, path: b.path}, nil
+	return &BasePathFile{File: sourcef, path: b.path, style: b.style}, nil
 }
 
 func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
-	name, err := b.RealPath(name)
+	name, err := b.realPath(name, false)
 	if err != nil {
 		return nil, false, &os.PathError{Op: "lstat", Path: name, Err: err}
 	}
@@ -178,11 +310,11 @@ func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
 }
 
 func (b *BasePathFs) SymlinkIfPossible(oldname, newname string) error {
-	oldname, err := b.RealPath(oldname)
+	oldname, err := b.lexicalPath(oldname)
 	if err != nil {
 		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
 	}
-	newname, err = b.RealPath(newname)
+	newname, err = b.realPath(newname, false)
 	if err != nil {
 		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
 	}
@@ -193,7 +325,7 @@ func (b *BasePathFs) SymlinkIfPossible(oldname, newname string) error {
 }
 
 func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
-	name, err := b.RealPath(name)
+	name, err := b.realPath(name, false)
 	if err != nil {
 		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
 	}
@@ -1,9 +1,11 @@
 package afero
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
+	"syscall"
 	"testing"
 )
 
@@ -188,3 +190,147 @@ func TestBasePathTempFile(t *testing.T) {
 		t.Fatalf("TempFile realpath leaked: expected %s, got %s", expected, actual)
 	}
 }
+
+func TestBasePathPrefixBoundary(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/srv/data", 0777)
+	fs.MkdirAll("/srv/database", 0777)
+	WriteFile(fs, "/srv/database/secret", []byte("secret"), 0644)
+
+	for _, bp := range []Fs{NewBasePathFs(fs, "/srv/data"), NewConfinedBasePathFs(fs, "/srv/data")} {
+		for _, name := range []string{"../database/secret", "/../database/secret", "sub/../../database/secret"} {
+			if _, err := bp.Open(name); err == nil {
+				t.Errorf("opened %s outside of the base path", name)
+			}
+		}
+	}
+	if _, err := NewBasePathFs(fs, "/").Stat("/srv/database/secret"); err != nil {
+		t.Errorf("root base path: %v", err)
+	}
+}
+
+func TestBasePathWindowsPaths(t *testing.T) {
+	fs := NewMemMapFsWithOptions(MemMapFsOptions{PathStyle: WindowsPaths})
+	fs.MkdirAll(`C:\srv\data`, 0777)
+	fs.MkdirAll(`C:\srv\database`, 0777)
+	WriteFile(fs, `C:\srv\database\secret`, []byte("secret"), 0644)
+
+	bp := NewBasePathFs(fs, `c:/srv/data`)
+	if err := bp.MkdirAll(`sub\dir`, 0777); err != nil {
+		t.Fatal(err)
+	}
+	f, err := bp.Create(`\sub/dir\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != `\sub\dir\file` {
+		t.Errorf("Name: %q", f.Name())
+	}
+	f.Close()
+	if _, err := fs.Stat(`C:\srv\data\sub\dir\file`); err != nil {
+		t.Errorf("the file is not in the base path: %v", err)
+	}
+	if got := FullBaseFsPath(bp.(*BasePathFs), `sub\dir`); got != `C:\srv\data\sub\dir` {
+		t.Errorf("FullBaseFsPath: %q", got)
+	}
+
+	for _, name := range []string{`..\database\secret`, `sub\..\..\database\secret`, `C:\srv\database\secret`} {
+		if _, err := bp.Open(name); !os.IsNotExist(err) {
+			t.Errorf("opened %s outside of the base path: %v", name, err)
+		}
+	}
+	if _, err := bp.Create(`sub\CON`); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Create of a reserved name: %v", err)
+	}
+}
+
+func TestConfinedBasePathSymlinkEscapes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need special privileges on Windows")
+	}
+	osFs := NewOsFs()
+	tmp, err := TempDir(osFs, "", "afero-confined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(tmp)
+
+	base := filepath.Join(tmp, "data")
+	outside := filepath.Join(tmp, "outside")
+	osFs.MkdirAll(filepath.Join(base, "sub", "deep"), 0777)
+	osFs.MkdirAll(outside, 0777)
+	WriteFile(osFs, filepath.Join(base, "sub", "file"), []byte("inside"), 0644)
+	WriteFile(osFs, filepath.Join(outside, "file"), []byte("outside"), 0644)
+
+	links := map[string]string{
+		"rel-esc":  "../outside",
+		"abs-esc":  outside,
+		"prefix":   base + "base",
+		"rel-ok":   "sub",
+		"abs-ok":   filepath.Join(base, "sub"),
+		"chain1":   "chain2",
+		"chain2":   "sub/deep/../../../outside",
+		"loop1":    "loop2",
+		"loop2":    "loop1",
+		"sub/up":   "../..",
+		"sub/back": "../sub/file",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(base, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bp := NewConfinedBasePathFs(osFs, base)
+
+	for _, name := range []string{
+		"rel-esc/file",
+		"abs-esc/file",
+		"prefix",
+		"chain1/file",
+		"sub/up/outside/file",
+		"rel-ok/up/outside/file",
+	} {
+		if _, err := ReadFile(bp, name); !errors.Is(err, ErrEscapesBasePath) {
+			t.Errorf("reading %s: expected ErrEscapesBasePath, got %v", name, err)
+		}
+	}
+	if _, err := bp.Stat("loop1"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("expected ELOOP for a symlink loop, got %v", err)
+	}
+	if _, err := bp.Create("rel-esc/new"); err == nil {
+		t.Error("created a file through an escaping symlink")
+	}
+	if _, err := osFs.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Error("file was created outside of the base path")
+	}
+	if err := bp.MkdirAll("abs-esc/dir", 0777); err == nil {
+		t.Error("created a directory through an escaping symlink")
+	}
+
+	for _, name := range []string{"rel-ok/file", "abs-ok/file", "sub/back"} {
+		if data, err := ReadFile(bp, name); err != nil || string(data) != "inside" {
+			t.Errorf("reading %s: got %q, %v", name, data, err)
+		}
+	}
+
+	// the escaping links themselves can be inspected and removed
+	fi, _, err := bp.(Lstater).LstatIfPossible("rel-esc")
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of an escaping link: %v, %v", fi, err)
+	}
+	if target, err := bp.(LinkReader).ReadlinkIfPossible("abs-esc"); err != nil || target != outside {
+		t.Errorf("Readlink of an escaping link: %q, %v", target, err)
+	}
+	if err := bp.Remove("rel-esc"); err != nil {
+		t.Error(err)
+	}
+	if _, err := osFs.Stat(filepath.Join(outside, "file")); err != nil {
+		t.Error("removing the link removed its target:", err)
+	}
+
+	// the default BasePathFs doesn't look at symlinks
+	if _, err := ReadFile(NewBasePathFs(osFs, base), "abs-esc/file"); err != nil {
+		t.Errorf("unconfined BasePathFs: %v", err)
+	}
+}
@@ -267,6 +267,24 @@ func (u *CacheOnReadFs) Name() string {
 	return "CacheOnReadFs"
 }
 
+// Capabilities returns the features of both the base and the layer, as
+// the files are modified in both. There are no symlinks.
+func (u *CacheOnReadFs) Capabilities() Features {
+	f := Capabilities(u.base).intersect(Capabilities(u.layer))
+	f.Lstat, f.Symlinks = false, false
+	return f
+}
+
+// Unwrap returns the base and the layer.
+func (u *CacheOnReadFs) Unwrap() []Fs {
+	return []Fs{u.base, u.layer}
+}
+
+// mapPath maps name to the base, the layer only holding copies.
+func (u *CacheOnReadFs) mapPath(name string) (Fs, string, bool) {
+	return u.base, name, true
+}
+
 func (u *CacheOnReadFs) MkdirAll(name string, perm os.FileMode) error {
 	err := u.base.MkdirAll(name, perm)
 	if err != nil {
@@ -0,0 +1,103 @@
+package afero
+
+// Features describes what a filesystem supports, see Capabilities.
+type Features struct {
+	// Lstat is set if LstatIfPossible calls a real Lstat, not following
+	// symlinks.
+	Lstat bool
+
+	// Symlinks is set if the filesystem has symbolic links:
+	// ReadlinkIfPossible reads them and, unless ReadOnly is set,
+	// SymlinkIfPossible creates them.
+	Symlinks bool
+
+	// Chmod is set if Chmod changes the permission bits reported by Stat.
+	Chmod bool
+
+	// Chtimes is set if Chtimes changes the modification time reported by
+	// Stat.
+	Chtimes bool
+
+	// AtomicRename is set if Rename replaces an existing file atomically:
+	// the new name always refers to either the old or the new file.
+	AtomicRename bool
+
+	// ReadOnly is set if the operations modifying the filesystem fail.
+	ReadOnly bool
+
+	// CaseInsensitive is set if names differing only in case may refer to
+	// the same file.
+	CaseInsensitive bool
+
+	// MaxNameLength is the maximum length in bytes of a path component, 0
+	// if there is no limit or it is unknown.
+	MaxNameLength int
+
+	// PathStyle is the style of the names of the files.
+	PathStyle PathStyle
+}
+
+// CapabilityReporter is an optional interface in Afero. It is implemented
+// by all the filesystems of afero, the wrappers computing their features
+// from the filesystems they wrap.
+type CapabilityReporter interface {
+	Capabilities() Features
+}
+
+// Capabilities returns the features of fs. For a filesystem not
+// implementing CapabilityReporter, they are guessed from the optional
+// interfaces it implements, and the others are unset.
+func Capabilities(fs Fs) Features {
+	if r, ok := fs.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	_, lstater := fs.(Lstater)
+	_, symlinker := fs.(Symlinker)
+	return Features{Lstat: lstater, Symlinks: symlinker}
+}
+
+// wrapperFeatures returns the features of source as seen through the
+// wrapper passing its calls through: without Lstat and Symlinks if the
+// wrapper doesn't implement the interfaces they need.
+func wrapperFeatures(wrapper, source Fs) Features {
+	f := Capabilities(source)
+	if _, ok := wrapper.(Lstater); !ok {
+		f.Lstat = false
+	}
+	if _, ok := wrapper.(Symlinker); !ok {
+		f.Symlinks = false
+	}
+	return f
+}
+
+// intersect returns the features of a filesystem made of the filesystems
+// with features f and g, whose operations may involve either of them.
+func (f Features) intersect(g Features) Features {
+	return Features{
+		Lstat:           f.Lstat && g.Lstat,
+		Symlinks:        f.Symlinks && g.Symlinks,
+		Chmod:           f.Chmod && g.Chmod,
+		Chtimes:         f.Chtimes && g.Chtimes,
+		AtomicRename:    f.AtomicRename && g.AtomicRename,
+		ReadOnly:        f.ReadOnly || g.ReadOnly,
+		CaseInsensitive: f.CaseInsensitive || g.CaseInsensitive,
+		MaxNameLength:   minNameLength(f.MaxNameLength, g.MaxNameLength),
+		PathStyle:       commonPathStyle(f.PathStyle, g.PathStyle),
+	}
+}
+
+// commonPathStyle returns the path style of a filesystem passing the same
+// names to filesystems of styles a and b: the one that is not HostPaths.
+func commonPathStyle(a, b PathStyle) PathStyle {
+	if a == HostPaths {
+		return b
+	}
+	return a
+}
+
+func minNameLength(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
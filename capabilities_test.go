@@ -0,0 +1,76 @@
+package afero
+
+import (
+	"reflect"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	osFeatures := Capabilities(NewOsFs())
+	memFeatures := Features{Chmod: true, Chtimes: true, AtomicRename: true}
+	readOnlyOs := osFeatures
+	readOnlyOs.Chmod, readOnlyOs.Chtimes, readOnlyOs.AtomicRename, readOnlyOs.ReadOnly = false, false, false, true
+	copyOnWriteOs := osFeatures
+	copyOnWriteOs.AtomicRename = false
+
+	mount := NewMountFs(NewMemMapFs())
+	mount.Mount("/os", NewOsFs())
+	readOnlyMount := NewMountFs(nil)
+	readOnlyMount.Mount("/a", NewReadOnlyFs(NewMemMapFs()))
+
+	for _, tt := range []struct {
+		name string
+		fs   Fs
+		want Features
+	}{
+		{"MemMapFs", NewMemMapFs(), memFeatures},
+		{"ReadOnlyFs", NewReadOnlyFs(NewOsFs()), readOnlyOs},
+		{"BasePathFs(CopyOnWriteFs)", NewBasePathFs(NewCopyOnWriteFs(NewReadOnlyFs(NewOsFs()), NewMemMapFs()), "/"),
+			Features{Chmod: true, Chtimes: true, CaseInsensitive: osFeatures.CaseInsensitive}},
+		{"CopyOnWriteFs over OsFs", NewCopyOnWriteFs(NewReadOnlyFs(NewOsFs()), NewOsFs()), copyOnWriteOs},
+		{"RegexpFs", NewRegexpFs(NewOsFs(), regexp.MustCompile(`.*`)),
+			Features{Chmod: osFeatures.Chmod, Chtimes: true, AtomicRename: true, CaseInsensitive: osFeatures.CaseInsensitive, MaxNameLength: 255}},
+		{"InstrumentedFs", NewInstrumentedFs(NewOsFs(), nil), osFeatures},
+		{"CacheOnReadFs", NewCacheOnReadFs(NewOsFs(), NewMemMapFs(), 0),
+			Features{Chmod: osFeatures.Chmod, Chtimes: true, AtomicRename: true, CaseInsensitive: osFeatures.CaseInsensitive, MaxNameLength: 255}},
+		{"MountFs", mount,
+			Features{Chmod: osFeatures.Chmod, Chtimes: true, AtomicRename: true, CaseInsensitive: osFeatures.CaseInsensitive, MaxNameLength: 255}},
+		{"read only MountFs", readOnlyMount, Features{ReadOnly: true}},
+		{"empty MountFs", NewMountFs(nil), Features{}},
+		{"SpillFs", NewSpillFs(0, ""), memFeatures},
+		{"unknown", struct{ Fs }{NewOsFs()}, Features{}},
+	} {
+		if got := Capabilities(tt.fs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOsFsCapabilities(t *testing.T) {
+	f := Capabilities(NewOsFs())
+	if !f.Lstat || !f.Symlinks || f.ReadOnly || f.MaxNameLength != 255 {
+		t.Errorf("got %+v", f)
+	}
+	if f.CaseInsensitive != (runtime.GOOS == "windows" || runtime.GOOS == "darwin") {
+		t.Errorf("CaseInsensitive %v on %s", f.CaseInsensitive, runtime.GOOS)
+	}
+}
+
+func TestEncryptedFsCapabilities(t *testing.T) {
+	keys := &StaticKeys{Current: "k", Keys: map[string][]byte{"k": make([]byte, 32)}}
+	plain := Capabilities(NewEncryptedFs(NewOsFs(), EncryptedFsOptions{Keys: keys}))
+	if plain.MaxNameLength != 255 {
+		t.Errorf("plain names: MaxNameLength %d, want 255", plain.MaxNameLength)
+	}
+
+	fs := NewEncryptedFs(NewOsFs(), EncryptedFsOptions{Keys: keys, NameKey: make([]byte, 32)})
+	f := Capabilities(fs)
+	if f.CaseInsensitive || f.MaxNameLength <= 0 || f.MaxNameLength >= 255 {
+		t.Fatalf("encrypted names: got %+v", f)
+	}
+	if n := len(fs.(*EncryptedFs).encryptName(string(make([]byte, f.MaxNameLength)))); n > 255 {
+		t.Errorf("a name of MaxNameLength bytes is encrypted to %d bytes", n)
+	}
+}
@@ -0,0 +1,575 @@
+package afero
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	casBlobs = "/blobs"
+	casTree  = "/tree"
+	casTmp   = "/tmp"
+)
+
+// The CasFs is a content-addressable Fs: each distinct file contents is
+// stored once in the backing Fs, as a blob named after its SHA-256 digest,
+// and the files of the tree are small records referring to their blob. Blobs
+// are reference counted and removed with the last file referring to them.
+//
+// The backing Fs holds three directories: blobs, with a reference count file
+// next to each blob, tree, mirroring the directories of the CasFs and holding
+// the records, which keep the mode and times of the files, and tmp, for the
+// files being written. It must not be shared with another CasFs.
+//
+// A File opened for writing is a copy of the contents in tmp, stored as a
+// blob on Sync and Close. Symlinks are not supported.
+type CasFs struct {
+	backing Fs
+	mu      sync.RWMutex
+}
+
+// NewCasFs returns a CasFs storing its files in backing.
+func NewCasFs(backing Fs) *CasFs {
+	for _, dir := range []string{casBlobs, casTree, casTmp} {
+		// failures show up on use
+		backing.MkdirAll(dir, 0777)
+	}
+	return &CasFs{backing: backing}
+}
+
+func (c *CasFs) treePath(name string) string {
+	return filepath.Join(casTree, filepath.Clean("/"+name))
+}
+
+func (c *CasFs) blobPath(digest string) string {
+	return filepath.Join(casBlobs, digest[:2], digest)
+}
+
+// casRecord is the contents of a record of the tree, the empty record
+// describes an empty file.
+type casRecord struct {
+	digest string
+	size   int64
+}
+
+func (r casRecord) String() string {
+	if r.digest == "" {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%s %d\n", r.digest, r.size)
+}
+
+func parseCasRecord(s string) (casRecord, error) {
+	if s == "" {
+		return casRecord{}, nil
+	}
+	var r casRecord
+	if _, err := fmt.Sscanf(s, "sha256:%64s %d\n", &r.digest, &r.size); err != nil || len(r.digest) != 64 {
+		return casRecord{}, fmt.Errorf("invalid record %q", s)
+	}
+	return r, nil
+}
+
+// readRecord reads the record of the file name, c.mu must be held.
+func (c *CasFs) readRecord(name string) (casRecord, error) {
+	b, err := ReadFile(c.backing, c.treePath(name))
+	if err != nil {
+		return casRecord{}, err
+	}
+	r, err := parseCasRecord(string(b))
+	if err != nil {
+		return casRecord{}, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return r, nil
+}
+
+// refs adds delta to the reference count of the blob digest, the blob is
+// removed when no file refers to it anymore. c.mu must be held.
+func (c *CasFs) refs(digest string, delta int) error {
+	if digest == "" {
+		return nil
+	}
+	refName := c.blobPath(digest) + ".refs"
+	n := 0
+	b, err := ReadFile(c.backing, refName)
+	switch {
+	case err == nil:
+		// a count that can't be read must not release the blob
+		if n, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return &os.PathError{Op: "read", Path: refName, Err: err}
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	n += delta
+	if n <= 0 {
+		if err := c.backing.Remove(c.blobPath(digest)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := c.backing.Remove(refName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return WriteFile(c.backing, refName, []byte(strconv.Itoa(n)+"\n"), 0666)
+}
+
+// Digest returns the hex encoded SHA-256 digest of the contents of the file
+// name, without reading them.
+func (c *CasFs) Digest(name string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if fi, err := c.backing.Stat(c.treePath(name)); err != nil {
+		return "", casPathError(err, name)
+	} else if fi.IsDir() {
+		return "", &os.PathError{Op: "digest", Path: name, Err: syscall.EISDIR}
+	}
+	r, err := c.readRecord(name)
+	if err != nil {
+		return "", err
+	}
+	if r.digest == "" {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return r.digest, nil
+}
+
+// HashIfPossible returns the SHA-256 digest of the file name without reading
+// it, other digests are not known.
+func (c *CasFs) HashIfPossible(name string, algo ChecksumAlgo) ([]byte, bool, error) {
+	if algo != ChecksumSHA256 {
+		return nil, false, nil
+	}
+	digest, err := c.Digest(name)
+	if err != nil {
+		return nil, false, err
+	}
+	sum, err := hex.DecodeString(digest)
+	return sum, err == nil, err
+}
+
+// commit stores the contents of the temporary file tmp as a blob and points
+// the record of name to it. The temporary file is moved, or copied if keep is
+// set.
+func (c *CasFs) commit(name, tmp string, keep bool) error {
+	sum, err := Checksum(c.backing, tmp, ChecksumSHA256)
+	if err != nil {
+		return err
+	}
+	fi, err := c.backing.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	r := casRecord{size: fi.Size()}
+	if r.size > 0 {
+		r.digest = hex.EncodeToString(sum)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.digest != "" {
+		blob := c.blobPath(r.digest)
+		if _, err := c.backing.Stat(blob); os.IsNotExist(err) {
+			if err := c.backing.MkdirAll(filepath.Dir(blob), 0777); err != nil {
+				return err
+			}
+			if keep {
+				err = c.copyFile(tmp, blob)
+			} else {
+				err = c.backing.Rename(tmp, blob)
+			}
+			if err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		if err := c.refs(r.digest, 1); err != nil {
+			return err
+		}
+	}
+	old, err := c.readRecord(name)
+	if err != nil {
+		old = casRecord{}
+	}
+	if err := c.writeRecord(name, r); err != nil {
+		// the blob isn't referenced by name after all
+		c.refs(r.digest, -1)
+		return err
+	}
+	return c.refs(old.digest, -1)
+}
+
+// writeRecord replaces the record of the existing file name by r, c.mu must
+// be held.
+func (c *CasFs) writeRecord(name string, r casRecord) error {
+	f, err := c.backing.OpenFile(c.treePath(name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(r.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *CasFs) copyFile(src, dst string) error {
+	in, err := c.backing.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := c.backing.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// casFileInfo reports the name and size of a file, given the info of its
+// record.
+type casFileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (i casFileInfo) Name() string { return i.name }
+func (i casFileInfo) Size() int64  { return i.size }
+
+func (c *CasFs) fileInfo(name string, fi os.FileInfo) (os.FileInfo, error) {
+	if fi.IsDir() {
+		return casFileInfo{FileInfo: fi, name: filepath.Base(name), size: fi.Size()}, nil
+	}
+	c.mu.RLock()
+	r, err := c.readRecord(name)
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return casFileInfo{FileInfo: fi, name: filepath.Base(name), size: r.size}, nil
+}
+
+func (c *CasFs) Name() string {
+	return "CasFs"
+}
+
+func (c *CasFs) Capabilities() Features {
+	return wrapperFeatures(c, c.backing)
+}
+
+func (c *CasFs) Unwrap() []Fs {
+	return []Fs{c.backing}
+}
+
+func (c *CasFs) Create(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (c *CasFs) Open(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *CasFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	treeName := c.treePath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		rec, err := c.backing.OpenFile(treeName, flag, perm)
+		if err != nil {
+			return nil, casPathError(err, name)
+		}
+		fi, err := rec.Stat()
+		if err != nil {
+			rec.Close()
+			return nil, err
+		}
+		if fi.IsDir() {
+			return &CasFile{File: rec, fs: c, name: name}, nil
+		}
+		c.mu.RLock()
+		r, err := c.readRecord(name)
+		if err == nil && r.digest != "" {
+			var blob File
+			if blob, err = c.backing.Open(c.blobPath(r.digest)); err == nil {
+				rec.Close()
+				rec = blob
+			}
+		}
+		c.mu.RUnlock()
+		if err != nil {
+			rec.Close()
+			return nil, err
+		}
+		// an empty record reads as an empty file
+		return &CasFile{File: rec, fs: c, name: name}, nil
+	}
+
+	// the record is created, or checked, with the flags of the call
+	rec, err := c.backing.OpenFile(treeName, flag&^(os.O_TRUNC|os.O_APPEND), perm)
+	if err != nil {
+		return nil, casPathError(err, name)
+	}
+	rec.Close()
+	tmp, err := TempFile(c.backing, casTmp, "")
+	if err != nil {
+		return nil, err
+	}
+	tmpName := tmp.Name()
+	err = func() error {
+		defer tmp.Close()
+		if flag&os.O_TRUNC != 0 {
+			return nil
+		}
+		c.mu.RLock()
+		r, err := c.readRecord(name)
+		c.mu.RUnlock()
+		if err != nil || r.digest == "" {
+			return err
+		}
+		blob, err := c.backing.Open(c.blobPath(r.digest))
+		if err != nil {
+			return err
+		}
+		defer blob.Close()
+		_, err = io.Copy(tmp, blob)
+		return err
+	}()
+	var f File
+	if err == nil {
+		f, err = c.backing.OpenFile(tmpName, flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND), 0)
+	}
+	if err != nil {
+		c.backing.Remove(tmpName)
+		return nil, err
+	}
+	// a truncated file is stored even if it isn't written to
+	dirty := flag&os.O_TRUNC != 0
+	return &CasFile{File: f, fs: c, name: name, tmp: tmpName, dirty: dirty}, nil
+}
+
+// casPathError replaces the name of the backing Fs by name in err.
+func casPathError(err error, name string) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return &os.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+func (c *CasFs) Mkdir(name string, perm os.FileMode) error {
+	return casPathError(c.backing.Mkdir(c.treePath(name), perm), name)
+}
+
+func (c *CasFs) MkdirAll(path string, perm os.FileMode) error {
+	return casPathError(c.backing.MkdirAll(c.treePath(path), perm), path)
+}
+
+func (c *CasFs) Remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	treeName := c.treePath(name)
+	fi, err := c.backing.Stat(treeName)
+	if err != nil {
+		return casPathError(err, name)
+	}
+	var r casRecord
+	if !fi.IsDir() {
+		if r, err = c.readRecord(name); err != nil {
+			return err
+		}
+	}
+	if err := c.backing.Remove(treeName); err != nil {
+		return casPathError(err, name)
+	}
+	return c.refs(r.digest, -1)
+}
+
+func (c *CasFs) RemoveAll(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	treeName := c.treePath(path)
+	if treeName == casTree {
+		return &os.PathError{Op: "removeall", Path: path, Err: syscall.EBUSY}
+	}
+	var digests []string
+	err := Walk(c.backing, treeName, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		r, err := c.readRecord(strings.TrimPrefix(p, filepath.FromSlash(casTree)))
+		if err == nil {
+			digests = append(digests, r.digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.backing.RemoveAll(treeName); err != nil {
+		return casPathError(err, path)
+	}
+	for _, d := range digests {
+		if err := c.refs(d, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CasFs) Rename(oldname, newname string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	oldPath, newPath := c.treePath(oldname), c.treePath(newname)
+	if oldPath == newPath {
+		// renaming a file onto itself replaces nothing
+		if _, err := c.backing.Stat(oldPath); err != nil {
+			if pe, ok := err.(*os.PathError); ok {
+				err = pe.Err
+			}
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+		return nil
+	}
+	// the other file replaced by the rename, if any, loses its reference
+	var replaced casRecord
+	if fi, err := c.backing.Stat(newPath); err == nil && !fi.IsDir() {
+		replaced, _ = c.readRecord(newname)
+	}
+	if err := c.backing.Rename(oldPath, newPath); err != nil {
+		if le, ok := err.(*os.LinkError); ok {
+			return &os.LinkError{Op: le.Op, Old: oldname, New: newname, Err: le.Err}
+		}
+		return err
+	}
+	return c.refs(replaced.digest, -1)
+}
+
+func (c *CasFs) Stat(name string) (os.FileInfo, error) {
+	fi, err := c.backing.Stat(c.treePath(name))
+	if err != nil {
+		return nil, casPathError(err, name)
+	}
+	return c.fileInfo(name, fi)
+}
+
+func (c *CasFs) Chmod(name string, mode os.FileMode) error {
+	return casPathError(c.backing.Chmod(c.treePath(name), mode), name)
+}
+
+func (c *CasFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return casPathError(c.backing.Chtimes(c.treePath(name), atime, mtime), name)
+}
+
+// CasFile is a File of a CasFs: the blob of a file opened read only, a
+// temporary copy of a file opened for writing, or a directory of the tree.
+type CasFile struct {
+	File
+	fs   *CasFs
+	name string
+
+	// tmp is the name of the temporary copy
+	tmp   string
+	mu    sync.Mutex
+	dirty bool
+}
+
+func (f *CasFile) Name() string {
+	return f.name
+}
+
+func (f *CasFile) Stat() (os.FileInfo, error) {
+	fi, err := f.fs.backing.Stat(f.fs.treePath(f.name))
+	if err != nil {
+		return nil, casPathError(err, f.name)
+	}
+	if f.tmp == "" {
+		return f.fs.fileInfo(f.name, fi)
+	}
+	tfi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return casFileInfo{FileInfo: fi, name: filepath.Base(f.name), size: tfi.Size()}, nil
+}
+
+func (f *CasFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	for i, fi := range fis {
+		if cfi, ferr := f.fs.fileInfo(filepath.Join(f.name, fi.Name()), fi); ferr == nil {
+			fis[i] = cfi
+		}
+	}
+	return fis, err
+}
+
+func (f *CasFile) Write(p []byte) (int, error) {
+	f.setDirty()
+	return f.File.Write(p)
+}
+
+func (f *CasFile) WriteAt(p []byte, off int64) (int, error) {
+	f.setDirty()
+	return f.File.WriteAt(p, off)
+}
+
+func (f *CasFile) WriteString(s string) (int, error) {
+	f.setDirty()
+	return f.File.WriteString(s)
+}
+
+func (f *CasFile) Truncate(size int64) error {
+	f.setDirty()
+	return f.File.Truncate(size)
+}
+
+func (f *CasFile) setDirty() {
+	f.mu.Lock()
+	f.dirty = f.tmp != ""
+	f.mu.Unlock()
+}
+
+func (f *CasFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+	if !f.dirty {
+		return nil
+	}
+	if err := f.fs.commit(f.name, f.tmp, true); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+func (f *CasFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.File.Close()
+	if f.tmp == "" {
+		return err
+	}
+	if err == nil && f.dirty {
+		err = f.fs.commit(f.name, f.tmp, false)
+	}
+	f.dirty = false
+	if rerr := f.fs.backing.Remove(f.tmp); err == nil && rerr != nil && !os.IsNotExist(rerr) {
+		err = rerr
+	}
+	return err
+}
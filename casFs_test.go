@@ -0,0 +1,213 @@
+package afero
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// casBlobCount returns the number of blobs stored by a CasFs.
+func casBlobCount(t *testing.T, backing Fs) int {
+	n := 0
+	Walk(backing, casBlobs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() && !strings.HasSuffix(path, ".refs") {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestCasFsDeduplication(t *testing.T) {
+	backing := NewMemMapFs()
+	fs := NewCasFs(backing)
+	fs.MkdirAll("/a/b", 0755)
+	for _, name := range []string{"/a/one", "/a/b/two", "/three"} {
+		if err := WriteFile(fs, name, []byte("same contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	WriteFile(fs, "/other", []byte("other contents"), 0644)
+	if n := casBlobCount(t, backing); n != 2 {
+		t.Errorf("%d blobs stored, want 2", n)
+	}
+
+	for _, name := range []string{"/a/one", "/a/b/two", "/three"} {
+		got, err := ReadFile(fs, name)
+		if err != nil || string(got) != "same contents" {
+			t.Errorf("%s: %q, %v", name, got, err)
+		}
+	}
+	fi, err := fs.Stat("/a/b/two")
+	if err != nil || fi.Size() != 13 || fi.Name() != "two" || fi.Mode().Perm() != 0644 {
+		t.Errorf("Stat: %v, %v", fi, err)
+	}
+	fis, _ := ReadDir(fs, "/a")
+	if len(fis) != 2 || fis[0].Name() != "b" || !fis[0].IsDir() || fis[1].Size() != 13 {
+		t.Errorf("Readdir: %v", fis)
+	}
+
+	sum := sha256.Sum256([]byte("same contents"))
+	if d, err := fs.Digest("/three"); err != nil || d != hex.EncodeToString(sum[:]) {
+		t.Errorf("Digest: %s, %v", d, err)
+	}
+	if _, err := fs.Digest("/a"); err == nil {
+		t.Error("Digest of a directory")
+	}
+}
+
+func TestCasFsGarbageCollection(t *testing.T) {
+	backing := NewMemMapFs()
+	fs := NewCasFs(backing)
+	fs.MkdirAll("/dir/sub", 0755)
+	WriteFile(fs, "/dir/a", []byte("shared"), 0644)
+	WriteFile(fs, "/dir/sub/b", []byte("shared"), 0644)
+	WriteFile(fs, "/c", []byte("shared"), 0644)
+	WriteFile(fs, "/d", []byte("single"), 0644)
+
+	if err := fs.Remove("/d"); err != nil {
+		t.Fatal(err)
+	}
+	if n := casBlobCount(t, backing); n != 1 {
+		t.Errorf("%d blobs after Remove, want 1", n)
+	}
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(fs, "/c"); err != nil || string(got) != "shared" {
+		t.Errorf("remaining file: %q, %v", got, err)
+	}
+	// overwriting drops the last reference
+	WriteFile(fs, "/c", []byte("new"), 0644)
+	if n := casBlobCount(t, backing); n != 1 {
+		t.Errorf("%d blobs after overwrite, want 1", n)
+	}
+	WriteFile(fs, "/e", []byte("replaced"), 0644)
+	if err := fs.Rename("/c", "/e"); err != nil {
+		t.Fatal(err)
+	}
+	if n := casBlobCount(t, backing); n != 1 {
+		t.Errorf("%d blobs after rename, want 1", n)
+	}
+	if got, _ := ReadFile(fs, "/e"); string(got) != "new" {
+		t.Errorf("renamed file: %q", got)
+	}
+}
+
+func TestCasFsRenameToItself(t *testing.T) {
+	backing := NewMemMapFs()
+	fs := NewCasFs(backing)
+	WriteFile(fs, "/a", []byte("data"), 0644)
+
+	for _, name := range []string{"/a", "a", "/x/../a"} {
+		if err := fs.Rename("/a", name); err != nil {
+			t.Errorf("Rename(/a, %s): %v", name, err)
+		}
+	}
+	if got, err := ReadFile(fs, "/a"); err != nil || string(got) != "data" {
+		t.Errorf("file renamed to itself: %q, %v", got, err)
+	}
+	if n := casBlobCount(t, backing); n != 1 {
+		t.Errorf("%d blobs after renaming to itself, want 1", n)
+	}
+	if err := fs.Rename("/missing", "/missing"); !os.IsNotExist(err) {
+		t.Errorf("Rename of a missing file to itself: %v", err)
+	}
+}
+
+func TestCasFsWrite(t *testing.T) {
+	backing := NewMemMapFs()
+	fs := NewCasFs(backing)
+	WriteFile(fs, "/f", []byte("hello"), 0600)
+
+	f, err := fs.OpenFile("/f", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" world")
+	if fi, _ := f.Stat(); fi.Size() != 11 {
+		t.Errorf("size %d while writing", fi.Size())
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ReadFile(fs, "/f"); string(got) != "hello world" {
+		t.Errorf("after Sync: %q", got)
+	}
+	f.WriteString("!")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ReadFile(fs, "/f"); string(got) != "hello world!" {
+		t.Errorf("after Close: %q", got)
+	}
+	if n := casBlobCount(t, backing); n != 1 {
+		t.Errorf("%d blobs, want 1", n)
+	}
+	if names, _ := readDirNames(backing, casTmp); len(names) != 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+
+	if _, err := fs.OpenFile("/f", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Errorf("O_EXCL: %v", err)
+	}
+	if _, err := fs.Open("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Open: %v", err)
+	}
+
+	f, _ = fs.Create("/f")
+	f.Close()
+	if fi, err := fs.Stat("/f"); err != nil || fi.Size() != 0 {
+		t.Errorf("truncated file: %v, %v", fi, err)
+	}
+	if n := casBlobCount(t, backing); n != 0 {
+		t.Errorf("%d blobs, want 0", n)
+	}
+	names, _ := readDirNames(fs, "/")
+	sort.Strings(names)
+	if len(names) != 1 || names[0] != "f" {
+		t.Errorf("root entries: %v", names)
+	}
+}
+
+func TestCasFsRemovedWhileWritten(t *testing.T) {
+	backing := NewMemMapFs()
+	fs := NewCasFs(backing)
+	f, err := fs.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("data")
+	if err := fs.Remove("/f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("committed a removed file")
+	}
+	if n := casBlobCount(t, backing); n != 0 {
+		t.Errorf("%d blobs leaked, want 0", n)
+	}
+}
+
+func TestCasFsCorruptRefs(t *testing.T) {
+	backing := NewMemMapFs()
+	fs := NewCasFs(backing)
+	WriteFile(fs, "/a", []byte("data"), 0644)
+	WriteFile(fs, "/b", []byte("data"), 0644)
+	digest, _ := fs.Digest("/a")
+	WriteFile(backing, filepath.Join(casBlobs, digest[:2], digest)+".refs", []byte("2x"), 0666)
+
+	if err := fs.Remove("/a"); err == nil {
+		t.Error("removed a file with a corrupt reference count")
+	}
+	if n := casBlobCount(t, backing); n != 1 {
+		t.Errorf("%d blobs, want 1", n)
+	}
+}
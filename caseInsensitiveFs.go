@@ -0,0 +1,262 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CaseInsensitiveFsOptions configures a CaseInsensitiveFs.
+type CaseInsensitiveFsOptions struct {
+	// CaseSensitive makes the names only normalization insensitive, like
+	// on APFS.
+	CaseSensitive bool
+
+	// Normalize converts the names of the new entries to Form, like HFS+
+	// converts them to NFD. Otherwise they are created as given.
+	Normalize bool
+	Form      norm.Form
+}
+
+// The CaseInsensitiveFs emulates the filesystems of macOS and Windows on
+// top of any Fs: names differing only in case, or in their Unicode
+// normalization, refer to the same entry, which keeps the spelling it was
+// created with.
+//
+// Opening foo.txt, including with O_CREATE, opens Foo.txt if it exists, like
+// macOS and Windows do, so that writing foo.txt overwrites it. Creating an
+// entry for real, with Mkdir, SymlinkIfPossible or OpenFile with O_EXCL,
+// fails with ErrFileExists if its name matches an existing one, so that the
+// code expecting them to be different files fails on Linux as well. MkdirAll
+// reuses the existing directories whatever their spelling.
+type CaseInsensitiveFs struct {
+	source Fs
+	opts   CaseInsensitiveFsOptions
+	// mu makes the check for a colliding name and the creation atomic
+	mu sync.Mutex
+}
+
+func NewCaseInsensitiveFs(source Fs, opts CaseInsensitiveFsOptions) Fs {
+	return &CaseInsensitiveFs{source: source, opts: opts}
+}
+
+// key returns the form of name compared to find an entry.
+func (c *CaseInsensitiveFs) key(name string) string {
+	name = norm.NFC.String(name)
+	if c.opts.CaseSensitive {
+		return name
+	}
+	return cases.Fold().String(name)
+}
+
+// newName returns the name a new entry named name is created with.
+func (c *CaseInsensitiveFs) newName(name string) string {
+	if c.opts.Normalize {
+		return c.opts.Form.String(name)
+	}
+	return name
+}
+
+// resolve returns the name in the source of the entry name, and whether it
+// exists. The components of name missing in the source are kept as they
+// will be created.
+func (c *CaseInsensitiveFs) resolve(name string) (string, bool) {
+	clean := filepath.Clean(name)
+	vol := filepath.VolumeName(clean)
+	real, rest := vol, clean[len(vol):]
+	if strings.HasPrefix(rest, string(filepath.Separator)) {
+		real, rest = real+string(filepath.Separator), rest[1:]
+	}
+	if rest == "" || rest == "." {
+		return clean, true
+	}
+	exists := true
+	for _, part := range strings.Split(rest, string(filepath.Separator)) {
+		if exists {
+			if part, exists = c.lookup(real, part); exists {
+				real = filepath.Join(real, part)
+				continue
+			}
+		}
+		real = filepath.Join(real, c.newName(part))
+	}
+	return real, exists
+}
+
+// lookup returns the name of the entry of the directory dir matching name,
+// the exact one if it exists.
+func (c *CaseInsensitiveFs) lookup(dir, name string) (string, bool) {
+	if name == ".." {
+		return name, true
+	}
+	if _, err := lstatIfPossible(c.source, filepath.Join(dir, name)); err == nil {
+		return name, true
+	}
+	if dir == "" {
+		dir = "."
+	}
+	names, err := readDirNames(c.source, dir)
+	if err != nil {
+		return name, false
+	}
+	key := c.key(name)
+	for _, n := range names {
+		if c.key(n) == key {
+			return n, true
+		}
+	}
+	return name, false
+}
+
+// resolveNew returns the name in the source of the entry name being
+// created, and whether it exists. It fails if it exists spelled
+// differently.
+func (c *CaseInsensitiveFs) resolveNew(op, name string) (string, bool, error) {
+	real, exists := c.resolve(name)
+	if exists && filepath.Base(real) != c.newName(filepath.Base(filepath.Clean(name))) {
+		return "", true, &os.PathError{Op: op, Path: name, Err: ErrFileExists}
+	}
+	return real, exists, nil
+}
+
+func (c *CaseInsensitiveFs) Name() string {
+	return "CaseInsensitiveFs"
+}
+
+func (c *CaseInsensitiveFs) Capabilities() Features {
+	f := wrapperFeatures(c, c.source)
+	f.CaseInsensitive = f.CaseInsensitive || !c.opts.CaseSensitive
+	return f
+}
+
+func (c *CaseInsensitiveFs) Unwrap() []Fs {
+	return []Fs{c.source}
+}
+
+func (c *CaseInsensitiveFs) mapPath(name string) (Fs, string, bool) {
+	real, _ := c.resolve(name)
+	return c.source, real, true
+}
+
+func (c *CaseInsensitiveFs) Create(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (c *CaseInsensitiveFs) Mkdir(name string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	real, _, err := c.resolveNew("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return c.source.Mkdir(real, perm)
+}
+
+func (c *CaseInsensitiveFs) MkdirAll(path string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	real, _ := c.resolve(path)
+	return c.source.MkdirAll(real, perm)
+}
+
+func (c *CaseInsensitiveFs) Open(name string) (File, error) {
+	real, _ := c.resolve(name)
+	return c.source.Open(real)
+}
+
+func (c *CaseInsensitiveFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_CREATE == 0 {
+		real, _ := c.resolve(name)
+		return c.source.OpenFile(real, flag, perm)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if flag&os.O_EXCL == 0 {
+		// the existing entry is opened whatever its spelling
+		real, _ := c.resolve(name)
+		return c.source.OpenFile(real, flag, perm)
+	}
+	real, _, err := c.resolveNew("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return c.source.OpenFile(real, flag, perm)
+}
+
+func (c *CaseInsensitiveFs) Remove(name string) error {
+	real, _ := c.resolve(name)
+	return c.source.Remove(real)
+}
+
+func (c *CaseInsensitiveFs) RemoveAll(path string) error {
+	real, _ := c.resolve(path)
+	return c.source.RemoveAll(real)
+}
+
+// Rename renames oldname, which may only change the case of its name. It
+// fails if newname exists spelled differently, unless it is oldname.
+func (c *CaseInsensitiveFs) Rename(oldname, newname string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	oldReal, _ := c.resolve(oldname)
+	newReal, exists := c.resolve(newname)
+	newBase := c.newName(filepath.Base(filepath.Clean(newname)))
+	if exists && filepath.Base(newReal) != newBase {
+		if newReal != oldReal {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileExists}
+		}
+		// only the spelling changes
+		newReal = filepath.Join(filepath.Dir(newReal), newBase)
+	}
+	return c.source.Rename(oldReal, newReal)
+}
+
+func (c *CaseInsensitiveFs) Stat(name string) (os.FileInfo, error) {
+	real, _ := c.resolve(name)
+	return c.source.Stat(real)
+}
+
+func (c *CaseInsensitiveFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	real, _ := c.resolve(name)
+	if lstater, ok := c.source.(Lstater); ok {
+		return lstater.LstatIfPossible(real)
+	}
+	fi, err := c.source.Stat(real)
+	return fi, false, err
+}
+
+func (c *CaseInsensitiveFs) SymlinkIfPossible(oldname, newname string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	real, _, err := c.resolveNew("symlink", newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	if linker, ok := c.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, real)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (c *CaseInsensitiveFs) ReadlinkIfPossible(name string) (string, error) {
+	real, _ := c.resolve(name)
+	if reader, ok := c.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(real)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+func (c *CaseInsensitiveFs) Chmod(name string, mode os.FileMode) error {
+	real, _ := c.resolve(name)
+	return c.source.Chmod(real, mode)
+}
+
+func (c *CaseInsensitiveFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	real, _ := c.resolve(name)
+	return c.source.Chtimes(real, atime, mtime)
+}
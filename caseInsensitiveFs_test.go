@@ -0,0 +1,116 @@
+package afero
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestCaseInsensitiveFs(t *testing.T) {
+	mem := NewMemMapFs()
+	fs := NewCaseInsensitiveFs(mem, CaseInsensitiveFsOptions{})
+
+	if err := fs.MkdirAll("/Docs/Reports", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/docs/REPORTS/Q1.txt", []byte("q1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the spelling of the creation is kept
+	if _, err := mem.Stat("/Docs/Reports/Q1.txt"); err != nil {
+		t.Errorf("the file is not created as spelled: %v", err)
+	}
+
+	data, err := ReadFile(fs, "/DOCS/reports/q1.TXT")
+	if err != nil || string(data) != "q1" {
+		t.Errorf("ReadFile: %q, %v", data, err)
+	}
+	if fi, err := fs.Stat("/docs/reports/q1.txt"); err != nil || fi.Name() != "Q1.txt" {
+		t.Errorf("Stat: %v, %v", fi, err)
+	}
+
+	// writing a colliding name overwrites the existing file
+	if err := WriteFile(fs, "/docs/reports/q1.txt", []byte("Q1"), 0644); err != nil {
+		t.Errorf("WriteFile of a colliding file: %v", err)
+	}
+	if data, _ := ReadFile(mem, "/Docs/Reports/Q1.txt"); string(data) != "Q1" {
+		t.Errorf("the existing file is not overwritten: %q", data)
+	}
+	if names, _ := ReadDir(mem, "/Docs/Reports"); len(names) != 1 {
+		t.Errorf("a colliding file is created: %v", names)
+	}
+	// creating it for real fails
+	if _, err := fs.OpenFile("/docs/reports/q1.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Errorf("OpenFile with O_EXCL of a colliding file: %v", err)
+	}
+	if _, err := fs.OpenFile("/Docs/Reports/Q1.txt", os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		t.Errorf("OpenFile of the file as spelled: %v", err)
+	}
+	if err := fs.Mkdir("/docs", 0755); !os.IsExist(err) {
+		t.Errorf("Mkdir of a colliding directory: %v", err)
+	}
+	if err := fs.MkdirAll("/docs/reports/2020", 0755); err != nil {
+		t.Errorf("MkdirAll: %v", err)
+	}
+	if _, err := mem.Stat("/Docs/Reports/2020"); err != nil {
+		t.Errorf("MkdirAll doesn't reuse the existing directories: %v", err)
+	}
+
+	// renames
+	WriteFile(fs, "/other.txt", []byte("other"), 0644)
+	if err := fs.Rename("/other.txt", "/DOCS/reports/q1.txt"); !os.IsExist(err) {
+		t.Errorf("Rename over a colliding file: %v", err)
+	}
+	if err := fs.Rename("/docs/reports/q1.txt", "/docs/reports/q1.txt"); err != nil {
+		t.Errorf("Rename to the same name: %v", err)
+	}
+	if err := fs.Rename("/docs/reports/q1.txt", "/docs/reports/Q1-final.TXT"); err != nil {
+		t.Errorf("Rename: %v", err)
+	}
+	if err := fs.Rename("/docs/reports/q1-final.txt", "/docs/reports/q1-final.txt"); err != nil {
+		t.Errorf("Rename changing the case: %v", err)
+	}
+	names, _ := readDirNames(mem, "/Docs/Reports")
+	if len(names) != 2 || names[0] != "2020" || names[1] != "q1-final.txt" {
+		t.Errorf("got %v, want [2020 q1-final.txt]", names)
+	}
+
+	if err := fs.RemoveAll("/DOCS"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat("/Docs"); !os.IsNotExist(err) {
+		t.Errorf("RemoveAll: %v", err)
+	}
+	if !Capabilities(fs).CaseInsensitive {
+		t.Error("not reported as case insensitive")
+	}
+}
+
+func TestCaseInsensitiveFsNormalization(t *testing.T) {
+	nfc, nfd := norm.NFC.String("café"), norm.NFD.String("café")
+
+	mem := NewMemMapFs()
+	fs := NewCaseInsensitiveFs(mem, CaseInsensitiveFsOptions{CaseSensitive: true})
+	WriteFile(fs, "/"+nfc, []byte("nfc"), 0644)
+	if data, err := ReadFile(fs, "/"+nfd); err != nil || string(data) != "nfc" {
+		t.Errorf("ReadFile of the NFD name: %q, %v", data, err)
+	}
+	if _, err := fs.OpenFile("/"+nfd, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Errorf("OpenFile with O_EXCL of the NFD name: %v", err)
+	}
+	if _, err := fs.Create("/CAFÉ"); err != nil {
+		t.Errorf("Create of a name differing in case: %v", err)
+	}
+
+	// HFS+ stores the names in NFD
+	mem = NewMemMapFs()
+	fs = NewCaseInsensitiveFs(mem, CaseInsensitiveFsOptions{Normalize: true, Form: norm.NFD})
+	WriteFile(fs, "/"+nfc, []byte("nfc"), 0644)
+	if _, err := mem.Stat("/" + nfd); err != nil {
+		t.Errorf("the name is not stored in NFD: %v", err)
+	}
+	if _, err := fs.OpenFile("/"+nfc, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		t.Errorf("OpenFile of the NFC name: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package afero
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChecksumAlgo names a hash function of Checksum.
+type ChecksumAlgo string
+
+const (
+	ChecksumSHA256 ChecksumAlgo = "sha256"
+	ChecksumSHA512 ChecksumAlgo = "sha512"
+	ChecksumSHA1   ChecksumAlgo = "sha1"
+	ChecksumMD5    ChecksumAlgo = "md5"
+	// ChecksumCRC32 is the IEEE CRC-32 of zip files, big endian.
+	ChecksumCRC32 ChecksumAlgo = "crc32"
+)
+
+// ErrUnknownChecksum is returned for an unsupported ChecksumAlgo.
+var ErrUnknownChecksum = errors.New("unknown checksum algorithm")
+
+func (a ChecksumAlgo) new() (hash.Hash, error) {
+	switch a {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	}
+	return nil, ErrUnknownChecksum
+}
+
+// Hasher is an optional interface of the filesystems knowing the digests of
+// their files without reading them, like a zip archive or a content
+// addressable store.
+type Hasher interface {
+	// HashIfPossible returns the digest of the contents of the file name,
+	// and whether it was known. If it wasn't, the caller computes it.
+	HashIfPossible(name string, algo ChecksumAlgo) ([]byte, bool, error)
+}
+
+func (a Afero) Checksum(path string, algo ChecksumAlgo) ([]byte, error) {
+	return Checksum(a.Fs, path, algo)
+}
+
+// Checksum returns the digest of the contents of the file path.
+func Checksum(fs Fs, path string, algo ChecksumAlgo) ([]byte, error) {
+	return (*ChecksumCache)(nil).Checksum(fs, path, algo)
+}
+
+func (a Afero) ChecksumTree(path string, algo ChecksumAlgo) ([]byte, error) {
+	return ChecksumTree(a.Fs, path, algo)
+}
+
+// ChecksumTree returns a Merkle style digest of the tree rooted at path, see
+// ChecksumCache.ChecksumTree.
+func ChecksumTree(fs Fs, path string, algo ChecksumAlgo) ([]byte, error) {
+	return (*ChecksumCache)(nil).ChecksumTree(fs, path, algo)
+}
+
+// ChecksumCache remembers the digests of files by path, size and
+// modification time, and doesn't hash a file again until one of them
+// changes. It is safe for concurrent use, and should be used with a single
+// Fs. The nil *ChecksumCache caches nothing.
+type ChecksumCache struct {
+	mu      sync.Mutex
+	digests map[checksumKey][]byte
+}
+
+type checksumKey struct {
+	path    string
+	algo    ChecksumAlgo
+	size    int64
+	modTime time.Time
+}
+
+func NewChecksumCache() *ChecksumCache {
+	return &ChecksumCache{digests: make(map[checksumKey][]byte)}
+}
+
+// Forget drops the cached digests.
+func (c *ChecksumCache) Forget() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.digests = make(map[checksumKey][]byte)
+	c.mu.Unlock()
+}
+
+// Checksum returns the digest of the contents of the file path, from the
+// cache if the file is unchanged, from the Fs if it implements Hasher, or by
+// reading the file.
+func (c *ChecksumCache) Checksum(fs Fs, path string, algo ChecksumAlgo) ([]byte, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "checksum", Path: path, Err: ErrIsDir}
+	}
+	return c.checksum(fs, path, fi, algo)
+}
+
+func (c *ChecksumCache) checksum(fs Fs, path string, fi os.FileInfo, algo ChecksumAlgo) ([]byte, error) {
+	key := checksumKey{path: filepath.Clean(path), algo: algo, size: fi.Size(), modTime: fi.ModTime()}
+	if c != nil {
+		c.mu.Lock()
+		sum, ok := c.digests[key]
+		c.mu.Unlock()
+		if ok {
+			return sum, nil
+		}
+	}
+	sum, err := hashFile(fs, path, algo)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.mu.Lock()
+		c.digests[key] = sum
+		c.mu.Unlock()
+	}
+	return sum, nil
+}
+
+func hashFile(fs Fs, path string, algo ChecksumAlgo) ([]byte, error) {
+	if hasher, ok := fs.(Hasher); ok {
+		sum, ok, err := hasher.HashIfPossible(path, algo)
+		if err != nil || ok {
+			return sum, err
+		}
+	}
+	h, err := algo.new()
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// ChecksumTree returns a Merkle style digest of the tree rooted at path: the
+// digest of a directory covers the names, types and digests of its entries,
+// sorted by name. The digest of a symlink is that of its target path, the
+// digest of a regular file that of its contents. The other files, like FIFOs
+// and devices, are left out. Modes and times are not covered. The files are
+// hashed in parallel.
+func (c *ChecksumCache) ChecksumTree(fs Fs, path string, algo ChecksumAlgo) ([]byte, error) {
+	if _, err := algo.new(); err != nil {
+		return nil, err
+	}
+	fi, err := lstatIfPossible(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	t := &checksumTree{fs: fs, cache: c, algo: algo, sem: make(chan struct{}, runtime.GOMAXPROCS(0))}
+	return t.digest(path, fi)
+}
+
+type checksumTree struct {
+	fs    Fs
+	cache *ChecksumCache
+	algo  ChecksumAlgo
+	// sem bounds the number of files hashed at once, the directories are
+	// walked by the goroutine of ChecksumTree
+	sem chan struct{}
+}
+
+// digested reports whether fi is covered by the digest of its directory.
+func digested(fi os.FileInfo) bool {
+	return fi.IsDir() || fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0
+}
+
+func (t *checksumTree) digest(path string, fi os.FileInfo) ([]byte, error) {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		reader, ok := t.fs.(LinkReader)
+		if !ok {
+			return nil, &os.PathError{Op: "readlink", Path: path, Err: ErrNoReadlink}
+		}
+		target, err := reader.ReadlinkIfPossible(path)
+		if err != nil {
+			return nil, err
+		}
+		h, _ := t.algo.new()
+		h.Write([]byte(target))
+		return h.Sum(nil), nil
+	case fi.Mode().IsRegular():
+		return t.cache.checksum(t.fs, path, fi, t.algo)
+	case !fi.IsDir():
+		return nil, &os.PathError{Op: "checksum", Path: path, Err: ErrInvalid}
+	}
+
+	all, err := ReadDir(t.fs, path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []os.FileInfo
+	for _, entry := range all {
+		if digested(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Sort(byName(entries))
+	sums := make([][]byte, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		name := filepath.Join(path, entry.Name())
+		if !entry.Mode().IsRegular() {
+			sums[i], errs[i] = t.digest(name, entry)
+			continue
+		}
+		t.sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, entry os.FileInfo) {
+			defer func() {
+				<-t.sem
+				wg.Done()
+			}()
+			sums[i], errs[i] = t.digest(name, entry)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	h, _ := t.algo.new()
+	for i, entry := range entries {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		kind := "f"
+		switch {
+		case entry.Mode()&os.ModeSymlink != 0:
+			kind = "l"
+		case entry.IsDir():
+			kind = "d"
+		}
+		h.Write([]byte(kind + " " + entry.Name() + "\x00"))
+		h.Write(sums[i])
+	}
+	return h.Sum(nil), nil
+}
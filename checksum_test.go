@@ -0,0 +1,193 @@
+package afero
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero/mem"
+)
+
+// openCountingFs counts the files opened.
+type openCountingFs struct {
+	Fs
+	opens int32
+}
+
+func (fs *openCountingFs) Open(name string) (File, error) {
+	atomic.AddInt32(&fs.opens, 1)
+	return fs.Fs.Open(name)
+}
+
+func TestChecksum(t *testing.T) {
+	fs := NewMemMapFs()
+	WriteFile(fs, "/a", []byte("contents"), 0644)
+	fs.Mkdir("/dir", 0755)
+
+	want := sha256.Sum256([]byte("contents"))
+	if sum, err := Checksum(fs, "/a", ChecksumSHA256); err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("Checksum: %x, %v", sum, err)
+	}
+	if _, err := Checksum(fs, "/a", "blake3"); err != ErrUnknownChecksum {
+		t.Errorf("unknown algorithm: %v", err)
+	}
+	if _, err := Checksum(fs, "/dir", ChecksumMD5); err == nil {
+		t.Error("Checksum of a directory")
+	}
+	if _, err := Checksum(fs, "/missing", ChecksumMD5); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestChecksumCache(t *testing.T) {
+	fs := &openCountingFs{Fs: NewMemMapFs()}
+	WriteFile(fs, "/a", []byte("one"), 0644)
+	cache := NewChecksumCache()
+
+	first, _ := cache.Checksum(fs, "/a", ChecksumSHA1)
+	second, _ := cache.Checksum(fs, "/a", ChecksumSHA1)
+	if fs.opens != 1 || !bytes.Equal(first, second) {
+		t.Errorf("%d opens for an unchanged file", fs.opens)
+	}
+	cache.Checksum(fs, "/a", ChecksumMD5)
+	if fs.opens != 2 {
+		t.Errorf("%d opens, another algorithm is cached apart", fs.opens)
+	}
+
+	WriteFile(fs, "/a", []byte("two"), 0644)
+	fs.Chtimes("/a", time.Now(), time.Now().Add(time.Minute))
+	third, _ := cache.Checksum(fs, "/a", ChecksumSHA1)
+	if fs.opens != 3 || bytes.Equal(first, third) {
+		t.Errorf("%d opens, a changed file is hashed again", fs.opens)
+	}
+
+	cache.Forget()
+	cache.Checksum(fs, "/a", ChecksumSHA1)
+	if fs.opens != 4 {
+		t.Errorf("%d opens after Forget", fs.opens)
+	}
+}
+
+var checksumFiles = map[string]string{
+	"root/a":          "a",
+	"root/b":          "b",
+	"root/sub/c":      "c",
+	"root/sub/d":      "",
+	"root/sub/empty/": "",
+	"outside/file":    "not in the tree",
+}
+
+func TestChecksumTree(t *testing.T) {
+	a, b := NewMemMapFs(), NewMemMapFs()
+	writeTree(t, a, "/", checksumFiles)
+	writeTree(t, b, "/", checksumFiles)
+	b.MkdirAll("/outside/other", 0755)
+	b.Chmod("/root/a", 0600)
+
+	sumA, err := ChecksumTree(a, "/root", ChecksumSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sumB, _ := ChecksumTree(b, "/root", ChecksumSHA256); !bytes.Equal(sumA, sumB) {
+		t.Error("same trees have different digests")
+	}
+
+	// a CasFs knows the digests of its files
+	cas := NewCasFs(NewMemMapFs())
+	writeTree(t, cas, "/", checksumFiles)
+	if sumCas, err := ChecksumTree(cas, "/root", ChecksumSHA256); err != nil || !bytes.Equal(sumA, sumCas) {
+		t.Errorf("CasFs tree: %x, %v", sumCas, err)
+	}
+
+	changes := map[string]func(fs Fs){
+		"contents": func(fs Fs) { WriteFile(fs, "/root/sub/c", []byte("C"), 0644) },
+		"rename":   func(fs Fs) { fs.Rename("/root/sub/c", "/root/sub/e") },
+		"empty":    func(fs Fs) { fs.Remove("/root/sub/empty") },
+		"move":     func(fs Fs) { fs.Rename("/root/b", "/root/sub/b") },
+	}
+	for name, change := range changes {
+		fs := NewMemMapFs()
+		writeTree(t, fs, "/", checksumFiles)
+		change(fs)
+		if sum, _ := ChecksumTree(fs, "/root", ChecksumSHA256); bytes.Equal(sum, sumA) {
+			t.Errorf("%s: digest unchanged", name)
+		}
+	}
+
+	file, _ := ChecksumTree(a, "/root/a", ChecksumSHA256)
+	if want, _ := Checksum(a, "/root/a", ChecksumSHA256); !bytes.Equal(file, want) {
+		t.Error("the tree digest of a file differs from its checksum")
+	}
+}
+
+func TestChecksumTreeCache(t *testing.T) {
+	fs := &openCountingFs{Fs: NewMemMapFs()}
+	writeTree(t, fs, "/", checksumFiles)
+	cache := NewChecksumCache()
+	first, _ := cache.ChecksumTree(fs, "/root", ChecksumSHA256)
+	opens := fs.opens
+	second, _ := cache.ChecksumTree(fs, "/root", ChecksumSHA256)
+	if !bytes.Equal(first, second) {
+		t.Error("digest changed")
+	}
+	// only the directories are read again
+	if n := fs.opens - opens; n != 3 {
+		t.Errorf("%d files opened for an unchanged tree", n)
+	}
+}
+
+func TestChecksumTreeSkipsSpecialFiles(t *testing.T) {
+	fs := &MemMapFs{}
+	writeTree(t, fs, "/", checksumFiles)
+	want, _ := ChecksumTree(fs, "/root", ChecksumSHA256)
+
+	WriteFile(fs, "/root/fifo", nil, 0644)
+	fifo, _ := fs.open("/root/fifo")
+	mem.SetMode(fifo, os.ModeNamedPipe|0644)
+	if sum, err := ChecksumTree(fs, "/root", ChecksumSHA256); err != nil || !bytes.Equal(sum, want) {
+		t.Errorf("the FIFO changed the digest: %x, %v", sum, err)
+	}
+	if _, err := ChecksumTree(fs, "/root/fifo", ChecksumSHA256); err == nil {
+		t.Error("digested a FIFO")
+	}
+}
+
+// goroutineCountingFs records the most goroutines running while a file is
+// opened.
+type goroutineCountingFs struct {
+	Fs
+	max int32
+}
+
+func (fs *goroutineCountingFs) Open(name string) (File, error) {
+	n := int32(runtime.NumGoroutine())
+	for {
+		max := atomic.LoadInt32(&fs.max)
+		if n <= max || atomic.CompareAndSwapInt32(&fs.max, max, n) {
+			break
+		}
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestChecksumTreeGoroutines(t *testing.T) {
+	fs := &goroutineCountingFs{Fs: NewMemMapFs()}
+	files := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("d%d/e%d/f", i, i)] = "f"
+	}
+	writeTree(t, fs, "/", files)
+
+	before := runtime.NumGoroutine()
+	if _, err := ChecksumTree(fs, "/", ChecksumSHA256); err != nil {
+		t.Fatal(err)
+	}
+	if n := int(fs.max) - before; n > runtime.GOMAXPROCS(0)+1 {
+		t.Errorf("%d goroutines for %d files", n, len(files))
+	}
+}
@@ -0,0 +1,706 @@
+package afero
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrCorruptCompressed is returned for the files of a CompressedFs whose
+// frame index is inconsistent or whose frames can't be decompressed.
+var ErrCorruptCompressed = errors.New("corrupt compressed file")
+
+// Codec is a compression format of a CompressedFs. Codecs other than gzip,
+// like zstd, can be plugged in by wrapping their package.
+type Codec interface {
+	// Name identifies the codec in the files it compressed, at most 255
+	// bytes.
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec is the gzip Codec, Level 0 means gzip.DefaultCompression.
+type GzipCodec struct {
+	Level int
+}
+
+func (GzipCodec) Name() string { return "gzip" }
+
+func (c GzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// DefaultCompressedFrameSize is the number of uncompressed bytes per frame
+// of the files written by a CompressedFs.
+const DefaultCompressedFrameSize = 256 * 1024
+
+// CompressedFsOptions configures a CompressedFs.
+type CompressedFsOptions struct {
+	// Codec compresses the files written, nil means GzipCodec.
+	Codec Codec
+
+	// Decoders are the codecs, besides Codec, of the files that can be read.
+	Decoders []Codec
+
+	// FrameSize is the number of uncompressed bytes per frame, 0 means
+	// DefaultCompressedFrameSize. Smaller frames make random reads cheaper
+	// and compress less.
+	FrameSize int
+
+	// Passthrough reports whether the file name should be written as is,
+	// nil means AlreadyCompressed.
+	Passthrough func(name string) bool
+}
+
+var compressedExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".lz4": true, ".br": true, ".zip": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".mp3": true, ".mp4": true, ".mkv": true, ".mov": true, ".ogg": true,
+}
+
+// AlreadyCompressed reports whether the extension of name is that of a
+// compressed archive, image, audio or video format.
+func AlreadyCompressed(name string) bool {
+	return compressedExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// The CompressedFs compresses the contents of the files of the source Fs on
+// write and decompresses them on read. A file is stored as a sequence of
+// independently compressed frames followed by an index of the frames, so
+// that Seek and ReadAt only decompress the frames involved. Stat and Readdir
+// report the uncompressed sizes.
+//
+// Files whose name is matched by the Passthrough rule are written as is, and
+// files without an index, written by other means, are read as is.
+//
+// A File opened for writing keeps in memory the uncompressed contents from
+// the first frame it changes on, and writes these frames back compressed on
+// Sync and Close. The frames before are kept as they are, so that appending
+// to a large file only rewrites its last frames.
+type CompressedFs struct {
+	source      Fs
+	codec       Codec
+	decoders    []Codec
+	frameSize   int64
+	passthrough func(name string) bool
+}
+
+func NewCompressedFs(source Fs, opts CompressedFsOptions) Fs {
+	c := &CompressedFs{
+		source:      source,
+		codec:       opts.Codec,
+		decoders:    opts.Decoders,
+		frameSize:   int64(opts.FrameSize),
+		passthrough: opts.Passthrough,
+	}
+	if c.codec == nil {
+		c.codec = GzipCodec{}
+	}
+	if c.frameSize <= 0 {
+		c.frameSize = DefaultCompressedFrameSize
+	}
+	if c.passthrough == nil {
+		c.passthrough = AlreadyCompressed
+	}
+	return c
+}
+
+func (c *CompressedFs) decoder(name string) Codec {
+	if c.codec.Name() == name {
+		return c.codec
+	}
+	for _, d := range c.decoders {
+		if d.Name() == name {
+			return d
+		}
+	}
+	return nil
+}
+
+const (
+	compressedMagic = "AFEROCZ\x01"
+	// codec name length, frame size, frame count, size, magic
+	compressedTrailerSize = 1 + 4 + 4 + 8 + len(compressedMagic)
+)
+
+// compressedIndex locates the frames of a compressed file.
+type compressedIndex struct {
+	codec     Codec
+	frameSize int64
+	size      int64
+	// offsets[i] is the start of frame i, the last one is the end of the
+	// frames
+	offsets []int64
+}
+
+// readCompressedIndex returns the index of f, of the given size, or nil if f
+// is not compressed.
+func (c *CompressedFs) readCompressedIndex(f File, fileSize int64) (*compressedIndex, error) {
+	if fileSize < int64(compressedTrailerSize) {
+		return nil, nil
+	}
+	t := make([]byte, compressedTrailerSize)
+	if _, err := f.ReadAt(t, fileSize-int64(len(t))); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(t[len(t)-len(compressedMagic):]) != compressedMagic {
+		return nil, nil
+	}
+	nameLen := int64(t[0])
+	x := &compressedIndex{
+		frameSize: int64(binary.BigEndian.Uint32(t[1:])),
+		size:      int64(binary.BigEndian.Uint64(t[9:])),
+	}
+	count := int64(binary.BigEndian.Uint32(t[5:]))
+	indexSize := 4*count + nameLen + int64(len(t))
+	if x.frameSize == 0 || x.size < 0 || indexSize > fileSize ||
+		count != (x.size+x.frameSize-1)/x.frameSize {
+		return nil, ErrCorruptCompressed
+	}
+	b := make([]byte, indexSize-int64(len(t)))
+	if _, err := f.ReadAt(b, fileSize-indexSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	codecName := string(b[4*count:])
+	if x.codec = c.decoder(codecName); x.codec == nil {
+		return nil, errors.New("unknown codec " + codecName)
+	}
+	x.offsets = make([]int64, count+1)
+	for i := int64(0); i < count; i++ {
+		x.offsets[i+1] = x.offsets[i] + int64(binary.BigEndian.Uint32(b[4*i:]))
+	}
+	if x.offsets[count] != fileSize-indexSize {
+		return nil, ErrCorruptCompressed
+	}
+	return x, nil
+}
+
+// frame returns the decompressed frame i of f.
+func (x *compressedIndex) frame(f File, i int64) ([]byte, error) {
+	r, err := x.codec.NewReader(io.NewSectionReader(f, x.offsets[i], x.offsets[i+1]-x.offsets[i]))
+	if err != nil {
+		return nil, ErrCorruptCompressed
+	}
+	defer r.Close()
+	l := x.size - i*x.frameSize
+	if l > x.frameSize {
+		l = x.frameSize
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, ErrCorruptCompressed
+	}
+	return b, nil
+}
+
+// compress writes data, the contents of f from frame start on, as compressed
+// frames following the first start frames of x, then the index of all the
+// frames, and returns that index. x is nil when no frame is kept.
+func (c *CompressedFs) compress(f File, x *compressedIndex, start int64, data []byte) (*compressedIndex, error) {
+	nx := &compressedIndex{codec: c.codec, frameSize: c.frameSize, offsets: []int64{0}}
+	if x != nil {
+		nx.offsets = append(nx.offsets[:0], x.offsets[:start+1]...)
+	}
+	nx.size = start*nx.frameSize + int64(len(data))
+	var buf bytes.Buffer
+	off := nx.offsets[start]
+	for pos := int64(0); pos < int64(len(data)); pos += c.frameSize {
+		end := pos + c.frameSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		buf.Reset()
+		w, err := c.codec.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data[pos:end]); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if _, err := f.WriteAt(buf.Bytes(), off); err != nil {
+			return nil, err
+		}
+		off += int64(buf.Len())
+		nx.offsets = append(nx.offsets, off)
+	}
+	count := len(nx.offsets) - 1
+	index := make([]byte, 4*count)
+	for i := 0; i < count; i++ {
+		binary.BigEndian.PutUint32(index[4*i:], uint32(nx.offsets[i+1]-nx.offsets[i]))
+	}
+	name := c.codec.Name()
+	t := make([]byte, compressedTrailerSize)
+	t[0] = byte(len(name))
+	binary.BigEndian.PutUint32(t[1:], uint32(c.frameSize))
+	binary.BigEndian.PutUint32(t[5:], uint32(count))
+	binary.BigEndian.PutUint64(t[9:], uint64(nx.size))
+	copy(t[17:], compressedMagic)
+	index = append(append(index, name...), t...)
+	if _, err := f.WriteAt(index, off); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(off + int64(len(index))); err != nil {
+		return nil, err
+	}
+	return nx, nil
+}
+
+// compressedFileInfo reports the uncompressed size of a file.
+type compressedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i compressedFileInfo) Size() int64 { return i.size }
+
+// fileInfo converts fi, the info of the source file name, to the
+// uncompressed size.
+func (c *CompressedFs) fileInfo(name string, fi os.FileInfo) (os.FileInfo, error) {
+	if !fi.Mode().IsRegular() {
+		return fi, nil
+	}
+	f, err := c.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	x, err := c.readCompressedIndex(f, fi.Size())
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if x == nil {
+		return fi, nil
+	}
+	return compressedFileInfo{FileInfo: fi, size: x.size}, nil
+}
+
+func (c *CompressedFs) Name() string {
+	return "CompressedFs"
+}
+
+func (c *CompressedFs) Capabilities() Features {
+	return wrapperFeatures(c, c.source)
+}
+
+func (c *CompressedFs) Unwrap() []Fs {
+	return []Fs{c.source}
+}
+
+func (c *CompressedFs) Create(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (c *CompressedFs) Open(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *CompressedFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if write && c.passthrough(name) {
+		return c.source.OpenFile(name, flag, perm)
+	}
+	realFlag := flag
+	if write {
+		// the contents are read back to be rewritten
+		realFlag = flag&^(os.O_WRONLY|os.O_APPEND) | os.O_RDWR
+	}
+	f, err := c.source.OpenFile(name, realFlag, perm)
+	if err != nil {
+		return nil, err
+	}
+	cf := &CompressedFile{fs: c, file: f, name: name, flag: flag, write: write}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		return cf, nil
+	}
+	if cf.index, err = c.readCompressedIndex(f, fi.Size()); err == nil && write {
+		err = cf.openWrite(fi.Size())
+	}
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return cf, nil
+}
+
+func (c *CompressedFs) Mkdir(name string, perm os.FileMode) error {
+	return c.source.Mkdir(name, perm)
+}
+
+func (c *CompressedFs) MkdirAll(path string, perm os.FileMode) error {
+	return c.source.MkdirAll(path, perm)
+}
+
+func (c *CompressedFs) Remove(name string) error {
+	return c.source.Remove(name)
+}
+
+func (c *CompressedFs) RemoveAll(path string) error {
+	return c.source.RemoveAll(path)
+}
+
+func (c *CompressedFs) Rename(oldname, newname string) error {
+	return c.source.Rename(oldname, newname)
+}
+
+func (c *CompressedFs) Stat(name string) (os.FileInfo, error) {
+	fi, err := c.source.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.fileInfo(name, fi)
+}
+
+func (c *CompressedFs) Chmod(name string, mode os.FileMode) error {
+	return c.source.Chmod(name, mode)
+}
+
+func (c *CompressedFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.source.Chtimes(name, atime, mtime)
+}
+
+// CompressedFile is a File of a CompressedFs. A File decompresses the frames
+// it reads, one at a time, a File opened for writing also holds the
+// uncompressed contents from the first frame it changed on.
+type CompressedFile struct {
+	fs    *CompressedFs
+	file  File
+	name  string
+	flag  int
+	write bool
+
+	mu  sync.Mutex
+	off int64
+
+	// index of the compressed file, nil for a file read as is or whose
+	// contents are all in data
+	index  *compressedIndex
+	cached int64
+	frame  []byte
+
+	// data holds the contents of a File opened for writing from frame start
+	// of index on
+	start int64
+	data  []byte
+	dirty bool
+}
+
+// openWrite loads the contents of the file of the given size that are
+// rewritten: its last partial frame, or all of it when its frames can't be
+// kept.
+func (f *CompressedFile) openWrite(size int64) error {
+	x := f.index
+	switch {
+	case f.flag&os.O_TRUNC != 0:
+		f.index = nil
+	case x == nil:
+		data := make([]byte, size)
+		n, err := f.file.ReadAt(data, 0)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		f.data = data[:n]
+	case x.codec.Name() != f.fs.codec.Name() || x.frameSize != f.fs.frameSize:
+		// recompressed as a whole
+		f.start = int64(len(x.offsets) - 1)
+		if err := f.load(0); err != nil {
+			return err
+		}
+		f.index = nil
+	default:
+		f.start = int64(len(x.offsets) - 1)
+		return f.load(x.size / x.frameSize)
+	}
+	return nil
+}
+
+// dataOff is the offset of data in the contents.
+func (f *CompressedFile) dataOff() int64 {
+	return f.start * f.fs.frameSize
+}
+
+// load moves the frames from i on to data, to be rewritten.
+func (f *CompressedFile) load(i int64) error {
+	if i >= f.start {
+		return nil
+	}
+	var head []byte
+	for j := i; j < f.start; j++ {
+		b, err := f.index.frame(f.file, j)
+		if err != nil {
+			return err
+		}
+		head = append(head, b...)
+	}
+	f.data = append(head, f.data...)
+	f.start = i
+	return nil
+}
+
+// readFrames reads p from the compressed frames at off, up to end.
+func (f *CompressedFile) readFrames(p []byte, off, end int64) (int, error) {
+	if rest := end - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	x := f.index
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i := pos / x.frameSize
+		if f.frame == nil || f.cached != i {
+			b, err := x.frame(f.file, i)
+			if err != nil {
+				return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+			}
+			f.frame, f.cached = b, i
+		}
+		n += copy(p[n:], f.frame[pos-i*x.frameSize:])
+	}
+	return n, nil
+}
+
+func (f *CompressedFile) size() (int64, error) {
+	switch {
+	case f.write:
+		return f.dataOff() + int64(len(f.data)), nil
+	case f.index != nil:
+		return f.index.size, nil
+	}
+	fi, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (f *CompressedFile) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+	switch {
+	case f.flag&os.O_WRONLY != 0:
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	case f.write:
+		start := f.dataOff()
+		if off >= start+int64(len(f.data)) {
+			return 0, io.EOF
+		}
+		n := 0
+		if off < start {
+			var err error
+			if n, err = f.readFrames(p, off, start); err != nil {
+				return n, err
+			}
+		}
+		if n < len(p) {
+			n += copy(p[n:], f.data[off+int64(n)-start:])
+		}
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	case f.index == nil:
+		return f.file.ReadAt(p, off)
+	}
+	if off >= f.index.size {
+		return 0, io.EOF
+	}
+	n, err := f.readFrames(p, off, f.index.size)
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *CompressedFile) writeAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: errors.New("negative offset")}
+	}
+	if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if err := f.load(off / f.fs.frameSize); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	off -= f.dataOff()
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	f.dirty = true
+	return copy(f.data[off:], p), nil
+}
+
+// flush writes the frames in data back compressed, only the last partial
+// frame stays in memory.
+func (f *CompressedFile) flush() error {
+	if !f.dirty {
+		return nil
+	}
+	x, err := f.fs.compress(f.file, f.index, f.start, f.data)
+	if err != nil {
+		return err
+	}
+	full := x.size / x.frameSize
+	f.data = append([]byte(nil), f.data[(full-f.start)*x.frameSize:]...)
+	f.index, f.start, f.frame = x, full, nil
+	f.dirty = false
+	return nil
+}
+
+func (f *CompressedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.flush()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *CompressedFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.off)
+	f.off += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *CompressedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *CompressedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *CompressedFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		f.off, _ = f.size()
+	}
+	n, err := f.writeAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *CompressedFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+func (f *CompressedFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *CompressedFile) Name() string {
+	return f.name
+}
+
+func (f *CompressedFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.file.Readdir(count)
+	for i, fi := range fis {
+		if cfi, serr := f.fs.fileInfo(filepath.Join(f.name, fi.Name()), fi); serr == nil {
+			fis[i] = cfi
+		}
+	}
+	return fis, err
+}
+
+func (f *CompressedFile) Readdirnames(n int) ([]string, error) {
+	return f.file.Readdirnames(n)
+}
+
+func (f *CompressedFile) Stat() (os.FileInfo, error) {
+	fi, err := f.file.Stat()
+	if err != nil || fi.IsDir() {
+		return fi, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	size, err := f.size()
+	if err != nil {
+		return nil, err
+	}
+	return compressedFileInfo{FileInfo: fi, size: size}, nil
+}
+
+func (f *CompressedFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *CompressedFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: ErrInvalid}
+	}
+	if !f.write {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EBADF}
+	}
+	if err := f.load(size / f.fs.frameSize); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+	size -= f.dataOff()
+	if size > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+	f.data = f.data[:size]
+	f.dirty = true
+	return nil
+}
@@ -0,0 +1,250 @@
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func compressibleData(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, `{"line": %d, "level": "info", "msg": "request served"}`+"\n", i)
+	}
+	return b.Bytes()[:n]
+}
+
+func TestCompressedFsRoundTrip(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewCompressedFs(base, CompressedFsOptions{FrameSize: 1024})
+	data := compressibleData(10000)
+	if err := WriteFile(fs, "/app.log", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadFile(fs, "/app.log")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadFile: %d bytes, %v", len(got), err)
+	}
+	raw, _ := base.Stat("/app.log")
+	if raw.Size() >= int64(len(data))/2 {
+		t.Errorf("stored %d bytes for %d", raw.Size(), len(data))
+	}
+	if fi, err := fs.Stat("/app.log"); err != nil || fi.Size() != int64(len(data)) {
+		t.Errorf("Stat: %v, %v", fi, err)
+	}
+	fis, err := ReadDir(fs, "/")
+	if err != nil || len(fis) != 1 || fis[0].Size() != int64(len(data)) {
+		t.Errorf("Readdir: %v, %v", fis, err)
+	}
+}
+
+func TestCompressedFsRandomRead(t *testing.T) {
+	fs := NewCompressedFs(NewMemMapFs(), CompressedFsOptions{FrameSize: 100})
+	data := compressibleData(1050)
+	WriteFile(fs, "/f.json", data, 0644)
+
+	f, err := fs.Open("/f.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tt := range []struct{ off, n int }{{0, 10}, {95, 10}, {250, 300}, {1040, 10}, {1000, 100}, {2000, 1}} {
+		p := make([]byte, tt.n)
+		n, err := f.ReadAt(p, int64(tt.off))
+		want := 0
+		if tt.off < len(data) {
+			want = copy(make([]byte, tt.n), data[tt.off:])
+		}
+		if n != want || (n < tt.n) != (err == io.EOF) {
+			t.Errorf("ReadAt(%d, %d) = %d, %v", tt.n, tt.off, n, err)
+		} else if !bytes.Equal(p[:n], data[tt.off:tt.off+n]) {
+			t.Errorf("ReadAt(%d, %d) returned wrong data", tt.n, tt.off)
+		}
+	}
+	if _, err := f.Seek(-50, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := ioutil.ReadAll(f)
+	if !bytes.Equal(rest, data[len(data)-50:]) {
+		t.Errorf("read %q after seeking", rest)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("wrote to a read only handle")
+	}
+}
+
+func TestCompressedFsWrite(t *testing.T) {
+	fs := NewCompressedFs(NewMemMapFs(), CompressedFsOptions{FrameSize: 16})
+	WriteFile(fs, "/log", []byte("0123456789abcdefghij"), 0644)
+
+	f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("klm")
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Error("read from a write only handle")
+	}
+	if fi, _ := f.Stat(); fi.Size() != 23 {
+		t.Errorf("size %d before Close", fi.Size())
+	}
+	f.Close()
+
+	f, _ = fs.OpenFile("/log", os.O_RDWR, 0)
+	f.WriteAt([]byte("XY"), 15)
+	f.Truncate(19)
+	f.Close()
+
+	if got, _ := ReadFile(fs, "/log"); string(got) != "0123456789abcdeXYhi" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCompressedFsPartialRewrite(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewCompressedFs(base, CompressedFsOptions{FrameSize: 100})
+	data := compressibleData(1050)
+	WriteFile(fs, "/app.log", data, 0644)
+	before, _ := ReadFile(base, "/app.log")
+	raw, _ := base.Open("/app.log")
+	x, err := fs.(*CompressedFs).readCompressedIndex(raw, int64(len(before)))
+	raw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.OpenFile("/app.log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the last partial frame is loaded
+	if n := len(f.(*CompressedFile).data); n != 50 {
+		t.Errorf("%d bytes loaded to append", n)
+	}
+	f.WriteString("appended\n")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, "appended\n"...)
+	after, _ := ReadFile(base, "/app.log")
+	if kept := x.offsets[10]; !bytes.Equal(after[:kept], before[:kept]) {
+		t.Error("the unchanged frames were rewritten")
+	}
+
+	f, _ = fs.OpenFile("/app.log", os.O_RDWR, 0)
+	f.WriteAt([]byte("XY"), 499)
+	copy(data[499:], "XY")
+	// the frames kept and those in memory read alike
+	got := make([]byte, 20)
+	if n, err := f.ReadAt(got, 390); err != nil || !bytes.Equal(got[:n], data[390:410]) {
+		t.Errorf("ReadAt of a kept frame: %q, %v", got[:n], err)
+	}
+	if n, err := f.ReadAt(got, 490); err != nil || !bytes.Equal(got[:n], data[490:510]) {
+		t.Errorf("ReadAt across the rewritten frames: %q, %v", got[:n], err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	f.Truncate(250)
+	data = data[:250]
+	f.Close()
+	if got, _ := ReadFile(fs, "/app.log"); !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+
+	// the frames of another size are all rewritten
+	other := NewCompressedFs(base, CompressedFsOptions{FrameSize: 64})
+	f, _ = other.OpenFile("/app.log", os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString("z")
+	f.Close()
+	data = append(data, 'z')
+	if got, _ := ReadFile(fs, "/app.log"); !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestCompressedFsPassthrough(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewCompressedFs(base, CompressedFsOptions{})
+	WriteFile(fs, "/photo.JPG", []byte("jpeg data"), 0644)
+	if raw, _ := ReadFile(base, "/photo.JPG"); string(raw) != "jpeg data" {
+		t.Errorf("passthrough file stored as %q", raw)
+	}
+
+	WriteFile(base, "/legacy.txt", []byte("written before"), 0644)
+	if got, err := ReadFile(fs, "/legacy.txt"); err != nil || string(got) != "written before" {
+		t.Errorf("uncompressed file: %q, %v", got, err)
+	}
+	fi, _ := fs.Stat("/legacy.txt")
+	if fi.Size() != 14 {
+		t.Errorf("uncompressed file size %d", fi.Size())
+	}
+
+	// the format is kept across renames
+	WriteFile(fs, "/a.txt", []byte("compressed"), 0644)
+	fs.Rename("/a.txt", "/a.txt.gz")
+	if got, err := ReadFile(fs, "/a.txt.gz"); err != nil || string(got) != "compressed" {
+		t.Errorf("renamed file: %q, %v", got, err)
+	}
+}
+
+// upperCodec stores its input upper cased, standing for another format.
+type upperCodec struct{}
+
+func (upperCodec) Name() string { return "upper" }
+
+func (upperCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return upperWriter{w}, nil
+}
+
+func (upperCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	b, err := ioutil.ReadAll(r)
+	return ioutil.NopCloser(bytes.NewReader(bytes.ToLower(b))), err
+}
+
+type upperWriter struct{ io.Writer }
+
+func (w upperWriter) Write(p []byte) (int, error) { return w.Writer.Write(bytes.ToUpper(p)) }
+func (w upperWriter) Close() error                { return nil }
+
+func TestCompressedFsCodecs(t *testing.T) {
+	base := NewMemMapFs()
+	upper := NewCompressedFs(base, CompressedFsOptions{Codec: upperCodec{}})
+	WriteFile(upper, "/a", []byte("hello"), 0644)
+	if raw, _ := ReadFile(base, "/a"); !bytes.HasPrefix(raw, []byte("HELLO")) {
+		t.Errorf("stored %q", raw)
+	}
+
+	gz := NewCompressedFs(base, CompressedFsOptions{})
+	if _, err := ReadFile(gz, "/a"); err == nil {
+		t.Error("read a file of an unknown codec")
+	}
+	gz = NewCompressedFs(base, CompressedFsOptions{Decoders: []Codec{upperCodec{}}})
+	if got, err := ReadFile(gz, "/a"); err != nil || string(got) != "hello" {
+		t.Errorf("ReadFile: %q, %v", got, err)
+	}
+}
+
+func TestCompressedFsCorrupt(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewCompressedFs(base, CompressedFsOptions{})
+	WriteFile(fs, "/a", compressibleData(1000), 0644)
+	raw, _ := ReadFile(base, "/a")
+
+	broken := append([]byte(nil), raw...)
+	broken[20] ^= 0xff
+	WriteFile(base, "/frame", broken, 0644)
+	if _, err := ReadFile(fs, "/frame"); !errors.Is(err, ErrCorruptCompressed) {
+		t.Errorf("broken frame: %v", err)
+	}
+
+	WriteFile(base, "/index", append([]byte("x"), raw...), 0644)
+	if _, err := fs.Open("/index"); !errors.Is(err, ErrCorruptCompressed) {
+		t.Errorf("broken index: %v", err)
+	}
+}
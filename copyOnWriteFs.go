@@ -1,7 +1,6 @@
 package afero
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -138,13 +137,7 @@ func (u *CopyOnWriteFs) ReadlinkIfPossible(name string) (string, error) {
 }
 
 func (u *CopyOnWriteFs) isNotExist(err error) bool {
-	if e, ok := err.(*os.PathError); ok {
-		err = e.Err
-	}
-	if err == os.ErrNotExist || err == syscall.ENOENT || err == syscall.ENOTDIR {
-		return true
-	}
-	return false
+	return os.IsNotExist(err) || IsNotDir(err)
 }
 
 // Renaming files present only in the base layer is not permitted
@@ -154,7 +147,7 @@ func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
 		return err
 	}
 	if b {
-		return syscall.EPERM
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
 	}
 	return u.layer.Rename(oldname, newname)
 }
@@ -164,30 +157,21 @@ func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
 // will be removed.
 func (u *CopyOnWriteFs) Remove(name string) error {
 	err := u.layer.Remove(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
+	if os.IsNotExist(err) {
+		if _, berr := u.base.Stat(name); berr == nil {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
 		}
-		return syscall.ENOENT
-	default:
-		return err
 	}
+	return err
 }
 
 func (u *CopyOnWriteFs) RemoveAll(name string) error {
-	err := u.layer.RemoveAll(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
+	if _, err := u.layer.Stat(name); os.IsNotExist(err) {
+		if _, berr := u.base.Stat(name); berr == nil {
+			return &os.PathError{Op: "removeall", Path: name, Err: syscall.EPERM}
 		}
-		return syscall.ENOENT
-	default:
-		return err
 	}
+	return u.layer.RemoveAll(name)
 }
 
 func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
@@ -276,9 +260,16 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 	bfile, bErr := u.base.Open(name)
 	lfile, lErr := u.layer.Open(name)
 
-	// If either have errors at this point something is very wrong. Return nil and the errors
+	// If either have errors at this point something is very wrong. Return nil and the first error
 	if bErr != nil || lErr != nil {
-		return nil, fmt.Errorf("BaseErr: %v\nOverlayErr: %v", bErr, lErr)
+		if bErr == nil {
+			bfile.Close()
+			return nil, lErr
+		}
+		if lErr == nil {
+			lfile.Close()
+		}
+		return nil, bErr
 	}
 
 	return &UnionFile{Base: bfile, Layer: lfile}, nil
@@ -287,7 +278,7 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 func (u *CopyOnWriteFs) Mkdir(name string, perm os.FileMode) error {
 	inBase, _ := u.isBaseFile(name)
 	if inBase {
-		return ErrFileExists
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
 
 	// ensure parent exists and is a directory
@@ -315,6 +306,33 @@ func (u *CopyOnWriteFs) Name() string {
 	return "CopyOnWriteFs"
 }
 
+// Capabilities returns the features of the layer, which gets all the
+// modifications, limited by the base for reading. Rename is not atomic: it
+// fails for the files only in the base.
+func (u *CopyOnWriteFs) Capabilities() Features {
+	base, f := Capabilities(u.base), Capabilities(u.layer)
+	f.Lstat = f.Lstat && base.Lstat
+	f.AtomicRename = false
+	f.CaseInsensitive = f.CaseInsensitive || base.CaseInsensitive
+	return f
+}
+
+// Unwrap returns the base and the layer.
+func (u *CopyOnWriteFs) Unwrap() []Fs {
+	return []Fs{u.base, u.layer}
+}
+
+// mapPath maps name to the base if only it has the file, to the layer
+// otherwise, which is where it is created.
+func (u *CopyOnWriteFs) mapPath(name string) (Fs, string, bool) {
+	if _, err := lstatIfPossible(u.layer, name); err != nil {
+		if _, err := lstatIfPossible(u.base, name); err == nil {
+			return u.base, name, true
+		}
+	}
+	return u.layer, name, true
+}
+
 func (u *CopyOnWriteFs) MkdirAll(name string, perm os.FileMode) error {
 	dir, err := IsDir(u.base, name)
 	if err != nil {
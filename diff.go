@@ -0,0 +1,474 @@
+package afero
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DiffKind is the kind of a difference found by DiffTrees.
+type DiffKind int
+
+const (
+	// DiffAdded is an entry of the second tree missing from the first.
+	DiffAdded DiffKind = iota + 1
+	// DiffRemoved is an entry of the first tree missing from the second.
+	DiffRemoved
+	// DiffTypeChanged is an entry whose type, file, directory, symlink...
+	// differs.
+	DiffTypeChanged
+	// DiffModeChanged is an entry whose permission bits differ.
+	DiffModeChanged
+	// DiffContentChanged is a file whose contents, or a symlink whose
+	// target, differ.
+	DiffContentChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffTypeChanged:
+		return "type changed"
+	case DiffModeChanged:
+		return "mode changed"
+	case DiffContentChanged:
+		return "content changed"
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// DiffOptions configures DiffTrees.
+type DiffOptions struct {
+	// RootA and RootB are the roots of the trees compared, "" means the
+	// root of the Fs.
+	RootA, RootB string
+
+	// Exclude skips the entries it matches, in both trees. The rules are
+	// matched against the slash separated path relative to the roots.
+	Exclude *FilterRules
+
+	// IgnoreModes doesn't report permission changes, which differ between
+	// filesystems honouring the umask and the others.
+	IgnoreModes bool
+
+	// Unified adds a unified diff of the changed text files to the
+	// DiffContentChanged entries, with Context lines of context, 0 means 3.
+	Unified bool
+	Context int
+}
+
+// DiffEntry is a difference between the trees compared by DiffTrees.
+type DiffEntry struct {
+	// Path is the slash separated path of the entry relative to the roots.
+	Path string
+	Kind DiffKind
+	// A and B describe the entry in each tree, nil if it is missing.
+	A, B os.FileInfo
+	// Unified is the unified diff of a text file whose contents changed,
+	// with DiffOptions.Unified.
+	Unified string
+}
+
+func (e DiffEntry) String() string {
+	s := fmt.Sprintf("%-15s %s", e.Kind, e.Path)
+	switch e.Kind {
+	case DiffTypeChanged:
+		s += fmt.Sprintf(" (%s -> %s)", fileType(e.A), fileType(e.B))
+	case DiffModeChanged:
+		s += fmt.Sprintf(" (%v -> %v)", e.A.Mode().Perm(), e.B.Mode().Perm())
+	}
+	return s
+}
+
+// TreeDiff lists the differences between two trees, sorted by path. The
+// entries below an added, removed or type changed directory are not listed.
+type TreeDiff struct {
+	Entries []DiffEntry
+}
+
+// Empty reports whether the trees are the same.
+func (d *TreeDiff) Empty() bool {
+	return len(d.Entries) == 0
+}
+
+// String returns a report of the differences, one per line, followed by the
+// unified diffs if any. It is suitable as a test failure message.
+func (d *TreeDiff) String() string {
+	var b strings.Builder
+	for _, e := range d.Entries {
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	for _, e := range d.Entries {
+		b.WriteString(e.Unified)
+	}
+	return b.String()
+}
+
+func fileType(fi os.FileInfo) string {
+	switch m := fi.Mode(); {
+	case m.IsRegular():
+		return "file"
+	case m.IsDir():
+		return "directory"
+	case m&os.ModeSymlink != 0:
+		return "symlink"
+	}
+	return "special file"
+}
+
+// DiffTrees compares the trees rooted at opts.RootA in a and opts.RootB in b
+// and returns their differences, b being the newer tree: entries only in b
+// are added. Files are compared by contents, symlinks by target, times are
+// ignored. opts may be nil.
+func DiffTrees(a, b Fs, opts *DiffOptions) (*TreeDiff, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	rootA, rootB := diffRoot(opts.RootA), diffRoot(opts.RootB)
+	treeA, err := diffCollect(a, rootA, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := diffCollect(b, rootB, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	var rels []string
+	for rel := range treeA {
+		rels = append(rels, rel)
+	}
+	for rel := range treeB {
+		if _, ok := treeA[rel]; !ok {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+
+	d := &TreeDiff{}
+	// the directories whose contents are not compared
+	pruned := make(map[string]bool)
+	for _, rel := range rels {
+		if diffPruned(pruned, rel) {
+			continue
+		}
+		fa, fb := treeA[rel], treeB[rel]
+		e := DiffEntry{Path: rel, A: fa, B: fb}
+		switch {
+		case fa == nil:
+			e.Kind = DiffAdded
+		case fb == nil:
+			e.Kind = DiffRemoved
+		case fileType(fa) != fileType(fb):
+			e.Kind = DiffTypeChanged
+		}
+		if e.Kind != 0 {
+			d.Entries = append(d.Entries, e)
+			pruned[rel] = true
+			continue
+		}
+
+		nameA := filepath.Join(rootA, filepath.FromSlash(rel))
+		nameB := filepath.Join(rootB, filepath.FromSlash(rel))
+		same, err := diffSameContents(a, nameA, fa, b, nameB, fb)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			e.Kind = DiffContentChanged
+			if opts.Unified && fa.Mode().IsRegular() {
+				if e.Unified, err = unifiedFileDiff(a, nameA, b, nameB, rel, opts.Context); err != nil {
+					return nil, err
+				}
+			}
+			d.Entries = append(d.Entries, e)
+		}
+		if !opts.IgnoreModes && fa.Mode().Perm() != fb.Mode().Perm() && fa.Mode()&os.ModeSymlink == 0 {
+			d.Entries = append(d.Entries, DiffEntry{Path: rel, Kind: DiffModeChanged, A: fa, B: fb})
+		}
+	}
+	return d, nil
+}
+
+func diffRoot(root string) string {
+	if root == "" {
+		return string(filepath.Separator)
+	}
+	return filepath.Clean(root)
+}
+
+func diffPruned(pruned map[string]bool, rel string) bool {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if pruned[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// diffCollect returns the entries under root by slash separated relative
+// path.
+func diffCollect(fs Fs, root string, exclude *FilterRules) (map[string]os.FileInfo, error) {
+	tree := make(map[string]os.FileInfo)
+	err := Walk(fs, root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if exclude.Match(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		tree[rel] = fi
+		return nil
+	})
+	return tree, err
+}
+
+func diffSameContents(a Fs, nameA string, fa os.FileInfo, b Fs, nameB string, fb os.FileInfo) (bool, error) {
+	switch {
+	case fa.Mode()&os.ModeSymlink != 0:
+		ta, err := readlinkIfPossible(a, nameA)
+		if err != nil {
+			return false, err
+		}
+		tb, err := readlinkIfPossible(b, nameB)
+		return ta == tb, err
+	case !fa.Mode().IsRegular():
+		return true, nil
+	case fa.Size() != fb.Size():
+		return false, nil
+	}
+	fileA, err := a.Open(nameA)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := b.Open(nameB)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(fileA, bufA)
+		nb, errB := io.ReadFull(fileB, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		switch {
+		case errA != nil && !endA:
+			return false, errA
+		case errB != nil && !endB:
+			return false, errB
+		case endA || endB:
+			return endA == endB, nil
+		}
+	}
+}
+
+func readlinkIfPossible(fs Fs, name string) (string, error) {
+	if reader, ok := fs.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// unifiedFileDiff returns the unified diff of two text files, or "" if one
+// of them is binary.
+func unifiedFileDiff(a Fs, nameA string, b Fs, nameB string, rel string, context int) (string, error) {
+	dataA, err := ReadFile(a, nameA)
+	if err != nil {
+		return "", err
+	}
+	dataB, err := ReadFile(b, nameB)
+	if err != nil {
+		return "", err
+	}
+	if isBinary(dataA) || isBinary(dataB) {
+		return "", nil
+	}
+	if context <= 0 {
+		context = 3
+	}
+	return unifiedDiff("a/"+rel, "b/"+rel, splitLines(dataA), splitLines(dataB), context), nil
+}
+
+func isBinary(data []byte) bool {
+	if len(data) > binaryPeekSize {
+		data = data[:binaryPeekSize]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// splitLines splits data after each newline.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i:]
+	}
+	return lines
+}
+
+// lineOp is an operation of a line diff: ' ' keeps line a[i], which is also
+// b[j], '-' removes line a[i] and '+' inserts line b[j].
+type lineOp struct {
+	kind byte
+	i, j int
+}
+
+// diffLines returns the shortest edit script from a to b, using Myers'
+// algorithm.
+func diffLines(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v[off-d : off+d+1] before the step d, all the
+	// backtracking reads: O(D²) memory rather than O((n+m)·D)
+	var trace [][]int
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []lineOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, lineOp{' ', x, y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, lineOp{'+', x, y})
+		} else {
+			x--
+			ops = append(ops, lineOp{'-', x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		ops = append(ops, lineOp{' ', x, y})
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return ops
+}
+
+// unifiedDiff formats the differences between a and b in the unified
+// format, with context lines around the changes.
+func unifiedDiff(nameA, nameB string, a, b []string, context int) string {
+	ops := diffLines(a, b)
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// a hunk extends to the last change followed by less than
+		// 2*context unchanged lines
+		end, same := start, 0
+		for i := start; i < len(ops) && same <= 2*context; i++ {
+			if ops[i].kind == ' ' {
+				same++
+			} else {
+				same, end = 0, i+1
+			}
+		}
+		first, last := start-context, end+context
+		if first < 0 {
+			first = 0
+		}
+		if last > len(ops) {
+			last = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		var countA, countB int
+		for _, op := range ops[first:last] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(ops[first].i, countA), hunkRange(ops[first].j, countB))
+		for _, op := range ops[first:last] {
+			var line string
+			if op.kind == '+' {
+				line = b[op.j]
+			} else {
+				line = a[op.i]
+			}
+			out.WriteByte(op.kind)
+			out.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = last
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
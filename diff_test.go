@@ -0,0 +1,187 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var diffFiles = map[string]string{
+	"README":          "line 1\nline 2\nline 3\n",
+	"docs/guide.md":   "# Guide\n",
+	"src/main.go":     "package main\n",
+	"src/old/a.go":    "package old\n",
+	"src/old/b.go":    "package old\n",
+	"cache/entry":     "cached",
+	"run.sh":          "#!/bin/sh\n",
+	"same-size.txt":   "abc",
+	"becomes-dir.txt": "file",
+}
+
+func TestDiffTrees(t *testing.T) {
+	a, b := NewMemMapFs(), NewMemMapFs()
+	writeTree(t, a, "/golden", diffFiles)
+	writeTree(t, b, "/out", diffFiles)
+	opts := &DiffOptions{RootA: "/golden", RootB: "/out"}
+
+	d, err := DiffTrees(a, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Empty() {
+		t.Fatalf("same trees differ:\n%s", d)
+	}
+
+	WriteFile(b, "/out/README", []byte("line 1\nline two\nline 3\n"), 0644)
+	WriteFile(b, "/out/same-size.txt", []byte("abd"), 0644)
+	WriteFile(b, "/out/docs/new.md", []byte("new"), 0644)
+	b.RemoveAll("/out/src/old")
+	b.Chmod("/out/run.sh", 0755)
+	b.Remove("/out/becomes-dir.txt")
+	b.MkdirAll("/out/becomes-dir.txt/sub", 0755)
+	WriteFile(b, "/out/cache/entry", []byte("changed"), 0644)
+	opts.Exclude = MustCompileFilterRules("cache/")
+
+	d, err = DiffTrees(a, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type change struct {
+		path string
+		kind DiffKind
+	}
+	var got []change
+	for _, e := range d.Entries {
+		got = append(got, change{e.Path, e.Kind})
+	}
+	want := []change{
+		{"README", DiffContentChanged},
+		{"becomes-dir.txt", DiffTypeChanged},
+		{"docs/new.md", DiffAdded},
+		{"run.sh", DiffModeChanged},
+		{"same-size.txt", DiffContentChanged},
+		{"src/old", DiffRemoved},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	report := `content changed README
+type changed    becomes-dir.txt (file -> directory)
+added           docs/new.md
+mode changed    run.sh (-rw-r--r-- -> -rwxr-xr-x)
+content changed same-size.txt
+removed         src/old
+`
+	if d.String() != report {
+		t.Errorf("report:\n%s\nwant:\n%s", d, report)
+	}
+
+	opts.IgnoreModes = true
+	d, _ = DiffTrees(a, b, opts)
+	for _, e := range d.Entries {
+		if e.Kind == DiffModeChanged {
+			t.Errorf("mode change reported with IgnoreModes: %v", e)
+		}
+	}
+}
+
+func TestDiffTreesUnified(t *testing.T) {
+	a, b := NewMemMapFs(), NewMemMapFs()
+	a.Mkdir("/x", 0755)
+	b.Mkdir("/x", 0755)
+	var lines string
+	for _, l := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m"} {
+		lines += l + "\n"
+	}
+	WriteFile(a, "/x/text", []byte(lines), 0644)
+	WriteFile(b, "/x/text", []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nm\nn"), 0644)
+	WriteFile(a, "/x/bin", []byte("\x00\x01"), 0644)
+	WriteFile(b, "/x/bin", []byte("\x00\x02"), 0644)
+
+	d, err := DiffTrees(a, b, &DiffOptions{RootA: "/x", RootB: "/x", Unified: true, Context: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Entries) != 2 || d.Entries[0].Unified != "" {
+		t.Fatalf("entries: %v", d.Entries)
+	}
+	want := `--- a/text
++++ b/text
+@@ -1,4 +1,4 @@
+ a
+-b
++B
+ c
+ d
+@@ -10,4 +10,4 @@
+ j
+ k
+-l
+ m
++n
+\ No newline at end of file
+`
+	if got := d.Entries[1].Unified; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abcabba", "cbabac", 5},
+		{"abcdefgh", "xbcdyfgz", 6},
+		{"abcdefghij", "jihgfedcba", 18},
+	} {
+		a, b := strings.Split(test.a, ""), strings.Split(test.b, "")
+		ops := diffLines(a, b)
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, a[op.i])
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, b[op.j])
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(gotA, "") != test.a || strings.Join(gotB, "") != test.b || edits != test.edits {
+			t.Errorf("diffLines(%q, %q): %d edits rebuilding %q and %q", test.a, test.b, edits, gotA, gotB)
+		}
+	}
+}
+
+func TestDiffTreesSymlinks(t *testing.T) {
+	dir, err := TempDir(NewOsFs(), "", "afero-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	osFs := NewOsFs()
+	for _, root := range []string{"a", "b"} {
+		osFs.Mkdir(filepath.Join(dir, root), 0755)
+	}
+	if err := osFs.(Linker).SymlinkIfPossible("target1", filepath.Join(dir, "a", "link")); err != nil {
+		t.Skip(err)
+	}
+	osFs.(Linker).SymlinkIfPossible("target2", filepath.Join(dir, "b", "link"))
+
+	d, err := DiffTrees(osFs, osFs, &DiffOptions{RootA: filepath.Join(dir, "a"), RootB: filepath.Join(dir, "b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Entries) != 1 || d.Entries[0].Kind != DiffContentChanged {
+		t.Errorf("entries: %v", d.Entries)
+	}
+}
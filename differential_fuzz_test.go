@@ -0,0 +1,18 @@
+//go:build go1.18
+// +build go1.18
+
+package afero
+
+import "testing"
+
+// FuzzDifferentialMemMapFs runs the sequences of operations decoded from
+// the fuzzer input on a MemMapFs and on an OsFs, see differential_test.go.
+// The failures print a minimal sequence to add to differentialRegressions.
+func FuzzDifferentialMemMapFs(f *testing.F) {
+	for seed := int64(0); seed < 16; seed++ {
+		f.Add(randomOpsData(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkDifferential(t, decodeOps(data))
+	})
+}
@@ -0,0 +1,431 @@
+package afero
+
+import (
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// The differential test runs sequences of operations on a MemMapFs and on an
+// OsFs, and compares their results and the trees they leave. The sequences
+// are decoded from bytes, generated from fixed seeds by
+// TestDifferentialMemMapFs and by the fuzzer in differential_fuzz_test.go.
+
+type fsOpKind uint8
+
+const (
+	opCreate fsOpKind = iota
+	opOpenFile
+	opClose
+	opWrite
+	opWriteAt
+	opRead
+	opReadAt
+	opSeek
+	opTruncate
+	opFstat
+	opReadDir
+	opStat
+	opMkdir
+	opMkdirAll
+	opRemove
+	opRemoveAll
+	opRename
+	opChmod
+	numFsOps
+)
+
+var fsOpNames = [...]string{
+	"Create", "OpenFile", "Close", "Write", "WriteAt", "Read", "ReadAt", "Seek",
+	"Truncate", "Fstat", "ReadDir", "Stat", "Mkdir", "MkdirAll",
+	"Remove", "RemoveAll", "Rename", "Chmod",
+}
+
+var (
+	diffPaths = []string{"a", "b", "a/a", "a/b", "b/a", "a/a/a"}
+	diffFlags = []int{
+		os.O_RDONLY,
+		os.O_RDWR,
+		os.O_WRONLY | os.O_CREATE,
+		os.O_RDWR | os.O_CREATE,
+		os.O_RDWR | os.O_CREATE | os.O_EXCL,
+		os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+		os.O_RDWR | os.O_TRUNC,
+	}
+	diffFlagNames = []string{
+		"O_RDONLY", "O_RDWR", "O_WRONLY|O_CREATE", "O_RDWR|O_CREATE",
+		"O_RDWR|O_CREATE|O_EXCL", "O_WRONLY|O_CREATE|O_APPEND", "O_RDWR|O_TRUNC",
+	}
+	// modes unchanged by the usual umasks
+	diffModes = []os.FileMode{0644, 0600, 0755, 0700}
+)
+
+// fsOp is an operation on the paths of diffPaths and on two file slots.
+type fsOp struct {
+	kind fsOpKind
+	path string
+	// to is the target of Rename
+	to string
+	// h is the file slot of the file operations
+	h int
+	// n is an offset, or a length
+	n int64
+	// arg indexes diffFlags or diffModes, or is the whence of Seek
+	arg int
+}
+
+func (op fsOp) String() string {
+	name := fsOpNames[op.kind]
+	switch op.kind {
+	case opCreate:
+		return fmt.Sprintf("f%d = %s(%s)", op.h, name, op.path)
+	case opOpenFile:
+		return fmt.Sprintf("f%d = %s(%s, %s)", op.h, name, op.path, diffFlagNames[op.arg])
+	case opClose, opFstat:
+		return fmt.Sprintf("f%d.%s()", op.h, name)
+	case opWrite, opRead, opTruncate:
+		return fmt.Sprintf("f%d.%s(%d)", op.h, name, op.n)
+	case opWriteAt, opReadAt:
+		return fmt.Sprintf("f%d.%s(off %d)", op.h, name, op.n)
+	case opSeek:
+		return fmt.Sprintf("f%d.%s(%d, %d)", op.h, name, op.n, op.arg)
+	case opMkdir, opMkdirAll, opChmod:
+		return fmt.Sprintf("%s(%s, %o)", name, op.path, diffModes[op.arg])
+	case opRename:
+		return fmt.Sprintf("%s(%s, %s)", name, op.path, op.to)
+	}
+	return fmt.Sprintf("%s(%s)", name, op.path)
+}
+
+// GoString returns op as a literal of a []fsOp.
+func (op fsOp) GoString() string {
+	return fmt.Sprintf("{kind: op%s, path: %q, to: %q, h: %d, n: %d, arg: %d}, // %v",
+		fsOpNames[op.kind], op.path, op.to, op.h, op.n, op.arg, op)
+}
+
+// decodeOps decodes a sequence of operations, 4 bytes each.
+func decodeOps(data []byte) []fsOp {
+	var ops []fsOp
+	for ; len(data) >= 4 && len(ops) < 64; data = data[4:] {
+		op := fsOp{
+			kind: fsOpKind(data[0] % byte(numFsOps)),
+			path: diffPaths[int(data[1]>>1)%len(diffPaths)],
+			h:    int(data[1] & 1),
+			to:   diffPaths[int(data[2])%len(diffPaths)],
+			n:    int64(data[2] % 24),
+		}
+		switch op.kind {
+		case opOpenFile:
+			op.arg = int(data[3]) % len(diffFlags)
+		case opMkdir, opMkdirAll, opChmod:
+			op.arg = int(data[3]) % len(diffModes)
+		case opSeek:
+			op.n -= 4
+			op.arg = int(data[3]) % 3
+		case opWrite, opRead:
+			op.n %= 8
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func randomOpsData(seed int64) []byte {
+	r := mrand.New(mrand.NewSource(seed))
+	data := make([]byte, 4*(8+r.Intn(32)))
+	r.Read(data)
+	return data
+}
+
+// errClass reduces err to what all the filesystems agree on.
+func errClass(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		// MemMapFs reports the reads past the end with
+		// io.ErrUnexpectedEOF, see TestMemFsUnexpectedEOF
+		return "EOF"
+	case os.IsNotExist(err):
+		return "not exist"
+	case os.IsExist(err):
+		return "exist"
+	}
+	return "error"
+}
+
+func fileInfoString(fi os.FileInfo) string {
+	// MemMapFs has no umask, Create makes 0666 files
+	perm := fi.Mode().Perm() &^ 022
+	if fi.IsDir() {
+		return fmt.Sprintf("dir %v", perm)
+	}
+	return fmt.Sprintf("file %v size %d", perm, fi.Size())
+}
+
+// run runs op on the tree rooted at root in fs, with the files of files,
+// and returns its results.
+func (op fsOp) run(fs Fs, root string, files *[2]File) string {
+	name := filepath.Join(root, filepath.FromSlash(op.path))
+	f := files[op.h]
+	switch op.kind {
+	case opCreate, opOpenFile:
+		var err error
+		if f != nil {
+			f.Close()
+		}
+		if op.kind == opCreate {
+			f, err = fs.Create(name)
+		} else {
+			f, err = fs.OpenFile(name, diffFlags[op.arg], 0644)
+		}
+		if err != nil {
+			f = nil
+		}
+		files[op.h] = f
+		return errClass(err)
+	case opStat:
+		fi, err := fs.Stat(name)
+		if err != nil {
+			return errClass(err)
+		}
+		return fileInfoString(fi)
+	case opReadDir:
+		// listing a directory through an older handle may miss its new
+		// entries, it is read afresh
+		entries, err := ReadDir(fs, name)
+		var names []string
+		for _, fi := range entries {
+			names = append(names, fi.Name())
+		}
+		return fmt.Sprintf("%v %s", names, errClass(err))
+	case opMkdir:
+		return errClass(fs.Mkdir(name, diffModes[op.arg]))
+	case opMkdirAll:
+		return errClass(fs.MkdirAll(name, diffModes[op.arg]))
+	case opRemove:
+		return errClass(fs.Remove(name))
+	case opRemoveAll:
+		return errClass(fs.RemoveAll(name))
+	case opRename:
+		return errClass(fs.Rename(name, filepath.Join(root, filepath.FromSlash(op.to))))
+	case opChmod:
+		return errClass(fs.Chmod(name, diffModes[op.arg]))
+	}
+
+	if f == nil {
+		return "no file"
+	}
+	switch op.kind {
+	case opClose:
+		files[op.h] = nil
+		return errClass(f.Close())
+	case opWrite:
+		n, err := f.Write([]byte(strings.Repeat("w", int(op.n))))
+		return fmt.Sprintf("%d %s", n, errClass(err))
+	case opWriteAt:
+		n, err := f.WriteAt([]byte("xy"), op.n)
+		return fmt.Sprintf("%d %s", n, errClass(err))
+	case opRead:
+		b := make([]byte, op.n)
+		n, err := f.Read(b)
+		return fmt.Sprintf("%q %s", b[:n], errClass(err))
+	case opReadAt:
+		b := make([]byte, 4)
+		n, err := f.ReadAt(b, op.n)
+		return fmt.Sprintf("%q %s", b[:n], errClass(err))
+	case opSeek:
+		if fi, err := f.Stat(); err == nil && fi.IsDir() {
+			// seeking in a directory depends on the filesystem
+			return "directory"
+		}
+		off, err := f.Seek(op.n, op.arg)
+		if err != nil {
+			return errClass(err)
+		}
+		return fmt.Sprint(off)
+	case opTruncate:
+		return errClass(f.Truncate(op.n))
+	case opFstat:
+		fi, err := f.Stat()
+		if err != nil {
+			return errClass(err)
+		}
+		return fileInfoString(fi)
+	}
+	panic("unknown operation")
+}
+
+// dumpTree describes the tree rooted at root in fs, a line per entry.
+func dumpTree(fs Fs, root string) string {
+	var b strings.Builder
+	err := Walk(fs, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." {
+			return nil
+		}
+		fmt.Fprintf(&b, "%s: %s", filepath.ToSlash(rel), fileInfoString(fi))
+		if !fi.IsDir() {
+			data, err := ReadFile(fs, path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, " %q", data)
+		}
+		b.WriteByte('\n')
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(&b, "walk: %v\n", err)
+	}
+	return b.String()
+}
+
+// runOps runs ops on a new tree of fs, and returns the results of each
+// operation followed by a dump of the final tree.
+func runOps(t testing.TB, fs Fs, root string, ops []fsOp) []string {
+	var files [2]File
+	results := make([]string, 0, len(ops)+1)
+	for _, op := range ops {
+		results = append(results, op.run(fs, root, &files))
+	}
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return append(results, dumpTree(fs, root))
+}
+
+// divergence runs ops on a MemMapFs and on an OsFs, and describes the first
+// difference of their results, or returns "" if there is none.
+func divergence(t testing.TB, ops []fsOp) string {
+	dir, err := TempDir(NewOsFs(), "", "afero-differential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := runOps(t, NewOsFs(), dir, ops)
+
+	mem := NewMemMapFs()
+	mem.MkdirAll(dir, 0755)
+	got := runOps(t, mem, dir, ops)
+
+	for i, op := range ops {
+		if got[i] != want[i] {
+			return fmt.Sprintf("%v: MemMapFs %s, OsFs %s", op, got[i], want[i])
+		}
+	}
+	if got[len(ops)] != want[len(ops)] {
+		return fmt.Sprintf("the final trees differ\nMemMapFs:\n%sOsFs:\n%s", got[len(ops)], want[len(ops)])
+	}
+	return ""
+}
+
+// minimizeOps removes from ops the operations that aren't needed for
+// diverges to hold.
+func minimizeOps(ops []fsOp, diverges func([]fsOp) bool) []fsOp {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(ops); i++ {
+			candidate := append(append([]fsOp(nil), ops[:i]...), ops[i+1:]...)
+			if diverges(candidate) {
+				ops, changed = candidate, true
+				i--
+			}
+		}
+	}
+	return ops
+}
+
+// checkDifferential fails the test if ops diverge, with a minimal sequence
+// of operations reproducing the divergence.
+func checkDifferential(t testing.TB, ops []fsOp) {
+	if runtime.GOOS == "windows" {
+		t.Skip("MemMapFs follows the semantics of Unix")
+	}
+	d := divergence(t, ops)
+	if d == "" {
+		return
+	}
+	ops = minimizeOps(ops, func(ops []fsOp) bool { return divergence(t, ops) != "" })
+	var literal strings.Builder
+	for _, op := range ops {
+		fmt.Fprintf(&literal, "\t%#v\n", op)
+	}
+	t.Errorf("%s\nminimal sequence, %s, to add to differentialRegressions:\n{\n%s},",
+		d, divergence(t, ops), literal.String())
+}
+
+// differentialRegressions are the sequences which diverged.
+var differentialRegressions = [][]fsOp{
+	{
+		{kind: opRename, path: "a", to: "a", h: 1, n: 6, arg: 0}, // Rename(a, a)
+	},
+	{
+		{kind: opCreate, path: "b", to: "a/b", h: 1, n: 3, arg: 0},       // f1 = Create(b)
+		{kind: opRemoveAll, path: "b/a", to: "a/a", h: 0, n: 14, arg: 0}, // RemoveAll(b/a)
+	},
+	{
+		{kind: opOpenFile, path: "a", to: "a/a", h: 1, n: 2, arg: 4}, // f1 = OpenFile(a, O_RDWR|O_CREATE|O_EXCL)
+		{kind: opRename, path: "b", to: "a/b", h: 0, n: 21, arg: 0},  // Rename(b, a/b)
+	},
+	{
+		{kind: opOpenFile, path: "a", to: "b", h: 0, n: 2, arg: 2}, // f0 = OpenFile(a, O_WRONLY|O_CREATE)
+		{kind: opRead, path: "a", to: "b", h: 0, n: 4, arg: 0},     // f0.Read(4)
+	},
+	{
+		{kind: opOpenFile, path: "a", to: "b", h: 0, n: 2, arg: 5}, // f0 = OpenFile(a, O_WRONLY|O_CREATE|O_APPEND)
+		{kind: opWriteAt, path: "a", to: "b", h: 0, n: 16, arg: 0}, // f0.WriteAt(off 16)
+	},
+	{
+		{kind: opMkdirAll, path: "a/a/a", to: "b", h: 1, n: 7, arg: 1}, // MkdirAll(a/a/a, 600)
+		{kind: opOpenFile, path: "a", to: "a/a", h: 1, n: 2, arg: 0},   // f1 = OpenFile(a, O_RDONLY)
+		{kind: opReadAt, path: "b", to: "a/b", h: 1, n: 3, arg: 0},     // f1.ReadAt(off 3)
+	},
+	{
+		{kind: opOpenFile, path: "a", to: "b", h: 1, n: 19, arg: 5}, // f1 = OpenFile(a, O_WRONLY|O_CREATE|O_APPEND)
+		{kind: opRead, path: "a/a", to: "a", h: 1, n: 0, arg: 0},    // f1.Read(0)
+	},
+	{
+		{kind: opMkdirAll, path: "a/a", to: "a/b", h: 1, n: 3, arg: 0},   // MkdirAll(a/a, 644)
+		{kind: opOpenFile, path: "a/b", to: "a/a/a", h: 0, n: 5, arg: 4}, // f0 = OpenFile(a/b, O_RDWR|O_CREATE|O_EXCL)
+		{kind: opSeek, path: "a", to: "b/a", h: 0, n: 18, arg: 1},        // f0.Seek(18, 1)
+		{kind: opWrite, path: "a/b", to: "a", h: 0, n: 0, arg: 0},        // f0.Write(0)
+	},
+	{
+		{kind: opCreate, path: "b", to: "a", h: 0, n: 6, arg: 0},      // f0 = Create(b)
+		{kind: opOpenFile, path: "b", to: "a", h: 1, n: 6, arg: 1},    // f1 = OpenFile(b, O_RDWR)
+		{kind: opWrite, path: "b", to: "b", h: 1, n: 5, arg: 0},       // f1.Write(5)
+		{kind: opOpenFile, path: "b", to: "a/b", h: 1, n: 21, arg: 5}, // f1 = OpenFile(b, O_WRONLY|O_CREATE|O_APPEND)
+		{kind: opSeek, path: "a", to: "b/a", h: 1, n: 0, arg: 1},      // f1.Seek(0, 1)
+	},
+	{
+		{kind: opMkdirAll, path: "a", to: "a/a/a", h: 1, n: 23, arg: 2},  // MkdirAll(a, 755)
+		{kind: opOpenFile, path: "a/a", to: "b/a", h: 0, n: 22, arg: 5},  // f0 = OpenFile(a/a, O_WRONLY|O_CREATE|O_APPEND)
+		{kind: opTruncate, path: "a/a/a", to: "a/a", h: 0, n: 8, arg: 0}, // f0.Truncate(8)
+		{kind: opWrite, path: "a/b", to: "a", h: 0, n: 0, arg: 0},        // f0.Write(0)
+		{kind: opSeek, path: "b/a", to: "b/a", h: 0, n: 12, arg: 1},      // f0.Seek(12, 1)
+	},
+}
+
+func TestDifferentialMemMapFs(t *testing.T) {
+	for _, ops := range differentialRegressions {
+		checkDifferential(t, ops)
+	}
+	n := int64(500)
+	if testing.Short() {
+		n = 50
+	}
+	for seed := int64(0); seed < n && !t.Failed(); seed++ {
+		checkDifferential(t, decodeOps(randomOpsData(seed)))
+	}
+}
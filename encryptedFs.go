@@ -0,0 +1,726 @@
+package afero
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrNotEncrypted is returned for files of an EncryptedFs that don't
+	// have a valid header.
+	ErrNotEncrypted = errors.New("not an encrypted file")
+	// ErrDecryption is returned when a chunk of an encrypted file fails
+	// authentication: it was altered, moved or encrypted with another key.
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// KeyProvider supplies the AES keys of an EncryptedFs. Keys must be 16, 24
+// or 32 bytes long. The id of the key a file is encrypted with is stored in
+// its header, ids are at most 32 bytes long.
+type KeyProvider interface {
+	// CurrentKey returns the key new files are encrypted with.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding the keys in memory.
+type StaticKeys struct {
+	// Current is the id of the key new files are encrypted with.
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, errors.New("unknown key id " + id)
+	}
+	return key, nil
+}
+
+// DefaultEncryptedChunkSize is the number of plaintext bytes per chunk of
+// the files written by an EncryptedFs.
+const DefaultEncryptedChunkSize = 64 * 1024
+
+// EncryptedFsOptions configures an EncryptedFs.
+type EncryptedFsOptions struct {
+	// Keys supplies the keys the file contents are encrypted with.
+	Keys KeyProvider
+
+	// ChunkSize is the number of plaintext bytes per chunk of new files, 0
+	// means DefaultEncryptedChunkSize.
+	ChunkSize int
+
+	// NameKey, if set, is the secret the file and directory names are
+	// encrypted with. The same name always encrypts to the same string, so
+	// files can be looked up, and the directory structure is kept.
+	NameKey []byte
+}
+
+const (
+	encMagic      = "AFEROENC"
+	encVersion    = 1
+	encMaxKeyID   = 32
+	encFileIDSize = 16
+	// magic, version, key id length, chunk size, file id, key id
+	encHeaderSize = len(encMagic) + 1 + 1 + 4 + encFileIDSize + encMaxKeyID
+	encNonceSize  = 12
+	encOverhead   = encNonceSize + 16
+)
+
+// The EncryptedFs encrypts the contents of the files of the source Fs with
+// AES-GCM. Each file starts with a header holding the id of its key and a
+// random file id, followed by independently authenticated chunks, so that
+// Seek, ReadAt and WriteAt only touch the chunks involved. A chunk is bound
+// to its file and position and can't be moved around undetected; cutting a
+// file at a chunk boundary is not detected.
+//
+// Stat and Readdir report the plaintext sizes. File names are encrypted too
+// if EncryptedFsOptions.NameKey is set. Symlinks are not supported.
+type EncryptedFs struct {
+	source    Fs
+	keys      KeyProvider
+	chunkSize int
+
+	names cipher.AEAD
+	ivKey []byte
+}
+
+func NewEncryptedFs(source Fs, opts EncryptedFsOptions) Fs {
+	e := &EncryptedFs{source: source, keys: opts.Keys, chunkSize: opts.ChunkSize}
+	if e.chunkSize <= 0 {
+		e.chunkSize = DefaultEncryptedChunkSize
+	}
+	if opts.NameKey != nil {
+		block, _ := aes.NewCipher(deriveKey(opts.NameKey, "afero name key"))
+		e.names, _ = cipher.NewGCM(block)
+		e.ivKey = deriveKey(opts.NameKey, "afero name iv")
+	}
+	return e
+}
+
+func deriveKey(secret []byte, label string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+var encNameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// encryptName encrypts a single name deterministically: the nonce is a MAC
+// of the name, which is checked again on decryption.
+func (e *EncryptedFs) encryptName(name string) string {
+	mac := hmac.New(sha256.New, e.ivKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:encNonceSize]
+	sealed := e.names.Seal(nonce, nonce, []byte(name), nil)
+	return strings.ToLower(encNameEncoding.EncodeToString(sealed))
+}
+
+func (e *EncryptedFs) decryptName(name string) (string, error) {
+	sealed, err := encNameEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil || len(sealed) < encOverhead {
+		return "", ErrDecryption
+	}
+	nonce := sealed[:encNonceSize]
+	plain, err := e.names.Open(nil, nonce, sealed[encNonceSize:], nil)
+	if err != nil {
+		return "", ErrDecryption
+	}
+	mac := hmac.New(sha256.New, e.ivKey)
+	mac.Write(plain)
+	if !hmac.Equal(mac.Sum(nil)[:encNonceSize], nonce) {
+		return "", ErrDecryption
+	}
+	return string(plain), nil
+}
+
+// realName returns the name of the file of the source Fs.
+func (e *EncryptedFs) realName(name string) string {
+	if e.names == nil {
+		return name
+	}
+	parts := strings.Split(filepath.Clean(name), string(filepath.Separator))
+	for i, p := range parts {
+		if p != "" && p != "." && p != ".." && !(i == 0 && filepath.VolumeName(name) == p) {
+			parts[i] = e.encryptName(p)
+		}
+	}
+	return strings.Join(parts, string(filepath.Separator))
+}
+
+// plainSize returns the size of the plaintext of a file, given its header
+// and the size of the file in the source Fs.
+func plainSize(cipherSize int64, chunkSize int64) int64 {
+	n := cipherSize - int64(encHeaderSize)
+	if n <= 0 {
+		return 0
+	}
+	full, rest := n/(chunkSize+encOverhead), n%(chunkSize+encOverhead)
+	size := full * chunkSize
+	if rest > encOverhead {
+		size += rest - encOverhead
+	}
+	return size
+}
+
+// encryptedFileInfo reports the plaintext name and size of a file.
+type encryptedFileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (i encryptedFileInfo) Name() string { return i.name }
+func (i encryptedFileInfo) Size() int64  { return i.size }
+
+// fileInfo converts fi, the info of the source file realName, to plaintext.
+func (e *EncryptedFs) fileInfo(realName string, fi os.FileInfo, name string) (os.FileInfo, error) {
+	size := fi.Size()
+	if fi.Mode().IsRegular() && size > 0 {
+		f, err := e.source.Open(realName)
+		if err != nil {
+			return nil, err
+		}
+		h, err := readEncHeader(f)
+		f.Close()
+		if err != nil {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+		}
+		size = plainSize(size, int64(h.chunkSize))
+	}
+	return encryptedFileInfo{FileInfo: fi, name: filepath.Base(name), size: size}, nil
+}
+
+type encHeader struct {
+	keyID     string
+	chunkSize uint32
+	fileID    []byte
+}
+
+func (h *encHeader) marshal() []byte {
+	b := make([]byte, encHeaderSize)
+	n := copy(b, encMagic)
+	b[n] = encVersion
+	b[n+1] = byte(len(h.keyID))
+	binary.BigEndian.PutUint32(b[n+2:], h.chunkSize)
+	copy(b[n+6:], h.fileID)
+	copy(b[n+6+encFileIDSize:], h.keyID)
+	return b
+}
+
+func readEncHeader(f File) (*encHeader, error) {
+	b := make([]byte, encHeaderSize)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	n := len(encMagic)
+	if string(b[:n]) != encMagic || b[n] != encVersion || b[n+1] > encMaxKeyID {
+		return nil, ErrNotEncrypted
+	}
+	h := &encHeader{chunkSize: binary.BigEndian.Uint32(b[n+2:])}
+	if h.chunkSize == 0 {
+		return nil, ErrNotEncrypted
+	}
+	h.fileID = append([]byte(nil), b[n+6:n+6+encFileIDSize]...)
+	h.keyID = string(b[n+6+encFileIDSize : n+6+encFileIDSize+int(b[n+1])])
+	return h, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *EncryptedFs) Name() string {
+	return "EncryptedFs"
+}
+
+// Capabilities returns the features of the source. With encrypted names,
+// the names are case sensitive and shorter, as their encryption is longer
+// than them.
+func (e *EncryptedFs) Capabilities() Features {
+	f := wrapperFeatures(e, e.source)
+	if e.names != nil {
+		f.CaseInsensitive = false
+		if f.MaxNameLength > 0 {
+			// the base32 encoding of the nonce, the name and the tag
+			f.MaxNameLength = f.MaxNameLength*5/8 - encOverhead
+			if f.MaxNameLength <= 0 {
+				f.MaxNameLength = 1
+			}
+		}
+	}
+	return f
+}
+
+func (e *EncryptedFs) Unwrap() []Fs {
+	return []Fs{e.source}
+}
+
+func (e *EncryptedFs) Create(name string) (File, error) {
+	return e.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (e *EncryptedFs) Open(name string) (File, error) {
+	return e.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (e *EncryptedFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	realName := e.realName(name)
+	// partial chunks are read back to be rewritten
+	realFlag := flag &^ os.O_APPEND
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		realFlag = realFlag&^os.O_WRONLY | os.O_RDWR
+	}
+	f, err := e.source.OpenFile(realName, realFlag, perm)
+	if err != nil {
+		return nil, err
+	}
+	ef := &EncryptedFile{fs: e, file: f, name: name, flag: flag}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		return ef, nil
+	}
+	if fi.Size() == 0 {
+		if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+			// an empty file, there is nothing to decrypt
+			return ef, nil
+		}
+		err = ef.initHeader()
+	} else {
+		err = ef.readHeader()
+	}
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return ef, nil
+}
+
+func (e *EncryptedFs) Mkdir(name string, perm os.FileMode) error {
+	return e.source.Mkdir(e.realName(name), perm)
+}
+
+func (e *EncryptedFs) MkdirAll(path string, perm os.FileMode) error {
+	return e.source.MkdirAll(e.realName(path), perm)
+}
+
+func (e *EncryptedFs) Remove(name string) error {
+	return e.source.Remove(e.realName(name))
+}
+
+func (e *EncryptedFs) RemoveAll(path string) error {
+	return e.source.RemoveAll(e.realName(path))
+}
+
+func (e *EncryptedFs) Rename(oldname, newname string) error {
+	return e.source.Rename(e.realName(oldname), e.realName(newname))
+}
+
+func (e *EncryptedFs) Stat(name string) (os.FileInfo, error) {
+	realName := e.realName(name)
+	fi, err := e.source.Stat(realName)
+	if err != nil {
+		return nil, err
+	}
+	return e.fileInfo(realName, fi, name)
+}
+
+func (e *EncryptedFs) Chmod(name string, mode os.FileMode) error {
+	return e.source.Chmod(e.realName(name), mode)
+}
+
+func (e *EncryptedFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return e.source.Chtimes(e.realName(name), atime, mtime)
+}
+
+// EncryptedFile is a File of an EncryptedFs. It keeps its own offset and
+// reads the size of the file from the source Fs, handles of the same file
+// may be used concurrently as long as they don't write to the same chunks.
+type EncryptedFile struct {
+	fs   *EncryptedFs
+	file File
+	name string
+	flag int
+
+	mu        sync.Mutex
+	aead      cipher.AEAD
+	fileID    []byte
+	chunkSize int64
+	off       int64
+}
+
+func (f *EncryptedFile) initHeader() error {
+	if f.fs.keys == nil {
+		return errors.New("no key provider")
+	}
+	id, key, err := f.fs.keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	if len(id) > encMaxKeyID {
+		return errors.New("key id too long")
+	}
+	if f.aead, err = newGCM(key); err != nil {
+		return err
+	}
+	h := &encHeader{keyID: id, chunkSize: uint32(f.fs.chunkSize), fileID: make([]byte, encFileIDSize)}
+	if _, err := io.ReadFull(crand.Reader, h.fileID); err != nil {
+		return err
+	}
+	f.fileID, f.chunkSize = h.fileID, int64(h.chunkSize)
+	_, err = f.file.WriteAt(h.marshal(), 0)
+	return err
+}
+
+func (f *EncryptedFile) readHeader() error {
+	h, err := readEncHeader(f.file)
+	if err != nil {
+		return err
+	}
+	if f.fs.keys == nil {
+		return errors.New("no key provider")
+	}
+	key, err := f.fs.keys.Key(h.keyID)
+	if err != nil {
+		return err
+	}
+	if f.aead, err = newGCM(key); err != nil {
+		return err
+	}
+	f.fileID, f.chunkSize = h.fileID, int64(h.chunkSize)
+	return nil
+}
+
+// size returns the plaintext size of the file.
+func (f *EncryptedFile) size() (int64, error) {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if f.aead == nil {
+		return 0, nil
+	}
+	return plainSize(fi.Size(), f.chunkSize), nil
+}
+
+func (f *EncryptedFile) chunkOffset(idx int64) int64 {
+	return int64(encHeaderSize) + idx*(f.chunkSize+encOverhead)
+}
+
+func (f *EncryptedFile) additionalData(idx int64) []byte {
+	ad := make([]byte, encFileIDSize+8)
+	copy(ad, f.fileID)
+	binary.BigEndian.PutUint64(ad[encFileIDSize:], uint64(idx))
+	return ad
+}
+
+// readChunk decrypts chunk idx of a file of the given plaintext size.
+func (f *EncryptedFile) readChunk(idx, size int64) ([]byte, error) {
+	l := size - idx*f.chunkSize
+	if l > f.chunkSize {
+		l = f.chunkSize
+	}
+	sealed := make([]byte, l+encOverhead)
+	if _, err := f.file.ReadAt(sealed, f.chunkOffset(idx)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	plain, err := f.aead.Open(sealed[encNonceSize:encNonceSize], sealed[:encNonceSize], sealed[encNonceSize:], f.additionalData(idx))
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.name, Err: ErrDecryption}
+	}
+	return plain, nil
+}
+
+func (f *EncryptedFile) writeChunk(idx int64, plain []byte) error {
+	sealed := make([]byte, encNonceSize, len(plain)+encOverhead)
+	if _, err := io.ReadFull(crand.Reader, sealed); err != nil {
+		return err
+	}
+	sealed = f.aead.Seal(sealed, sealed, plain, f.additionalData(idx))
+	_, err := f.file.WriteAt(sealed, f.chunkOffset(idx))
+	return err
+}
+
+func (f *EncryptedFile) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.aead == nil {
+		return f.file.ReadAt(p, off)
+	}
+	size, err := f.size()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < size {
+		pos := off + int64(n)
+		idx := pos / f.chunkSize
+		chunk, err := f.readChunk(idx, size)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], chunk[pos-idx*f.chunkSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *EncryptedFile) writeAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: errors.New("negative offset")}
+	}
+	if f.aead == nil {
+		return f.file.WriteAt(p, off)
+	}
+	size, err := f.size()
+	if err != nil {
+		return 0, err
+	}
+	end := off + int64(len(p))
+	newSize := size
+	if end > newSize {
+		newSize = end
+	}
+	start := off
+	if size < start {
+		// the gap is filled with encrypted zeros
+		start = size
+	}
+	for idx := start / f.chunkSize; idx*f.chunkSize < end; idx++ {
+		cstart := idx * f.chunkSize
+		var chunk []byte
+		if cstart < size {
+			if chunk, err = f.readChunk(idx, size); err != nil {
+				return 0, err
+			}
+		}
+		l := newSize - cstart
+		if l > f.chunkSize {
+			l = f.chunkSize
+		}
+		if int64(len(chunk)) < l {
+			chunk = append(chunk, make([]byte, l-int64(len(chunk)))...)
+		}
+		lo, hi := off, end
+		if lo < cstart {
+			lo = cstart
+		}
+		if hi > cstart+l {
+			hi = cstart + l
+		}
+		if lo < hi {
+			copy(chunk[lo-cstart:], p[lo-off:hi-off])
+		}
+		if err := f.writeChunk(idx, chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *EncryptedFile) Close() error {
+	return f.file.Close()
+}
+
+func (f *EncryptedFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.off)
+	f.off += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *EncryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *EncryptedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *EncryptedFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		f.off = size
+	}
+	n, err := f.writeAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *EncryptedFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+func (f *EncryptedFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *EncryptedFile) Name() string {
+	return f.name
+}
+
+func (f *EncryptedFile) Readdir(count int) ([]os.FileInfo, error) {
+	realDir := f.fs.realName(f.name)
+	for {
+		fis, err := f.file.Readdir(count)
+		var out []os.FileInfo
+		for _, fi := range fis {
+			name := fi.Name()
+			if f.fs.names != nil {
+				plain, derr := f.fs.decryptName(name)
+				if derr != nil {
+					// not written through the EncryptedFs
+					continue
+				}
+				name = plain
+			}
+			efi, serr := f.fs.fileInfo(filepath.Join(realDir, fi.Name()), fi, name)
+			if serr != nil {
+				efi = encryptedFileInfo{FileInfo: fi, name: name, size: fi.Size()}
+			}
+			out = append(out, efi)
+		}
+		if len(out) > 0 || err != nil || count <= 0 || len(fis) == 0 {
+			return out, err
+		}
+	}
+}
+
+func (f *EncryptedFile) Readdirnames(n int) ([]string, error) {
+	if f.fs.names == nil {
+		return f.file.Readdirnames(n)
+	}
+	for {
+		names, err := f.file.Readdirnames(n)
+		var out []string
+		for _, name := range names {
+			if plain, derr := f.fs.decryptName(name); derr == nil {
+				out = append(out, plain)
+			}
+		}
+		if len(out) > 0 || err != nil || n <= 0 || len(names) == 0 {
+			return out, err
+		}
+	}
+}
+
+func (f *EncryptedFile) Stat() (os.FileInfo, error) {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.size()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		size = fi.Size()
+	}
+	return encryptedFileInfo{FileInfo: fi, name: filepath.Base(f.name), size: size}, nil
+}
+
+func (f *EncryptedFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *EncryptedFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: ErrInvalid}
+	}
+	if f.aead == nil {
+		// a read only handle or a directory
+		return f.file.Truncate(size)
+	}
+	cur, err := f.size()
+	if err != nil {
+		return err
+	}
+	if size >= cur {
+		_, err := f.writeAt(nil, size)
+		return err
+	}
+	idx, rest := size/f.chunkSize, size%f.chunkSize
+	var last []byte
+	if rest > 0 {
+		chunk, err := f.readChunk(idx, cur)
+		if err != nil {
+			return err
+		}
+		last = chunk[:rest]
+	}
+	if err := f.file.Truncate(f.chunkOffset(idx)); err != nil {
+		return err
+	}
+	if last != nil {
+		return f.writeChunk(idx, last)
+	}
+	return nil
+}
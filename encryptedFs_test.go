@@ -0,0 +1,237 @@
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testKeys() *StaticKeys {
+	return &StaticKeys{Current: "k1", Keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	}}
+}
+
+func TestEncryptedFsRoundTrip(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewEncryptedFs(base, EncryptedFsOptions{Keys: testKeys(), ChunkSize: 16})
+
+	plain := []byte("the quick brown fox jumps over the lazy dog, twice: the quick brown fox")
+	if err := WriteFile(fs, "/fox.txt", plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(fs, "/fox.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("got %q", got)
+	}
+
+	raw, _ := ReadFile(base, "/fox.txt")
+	if bytes.Contains(raw, []byte("fox")) {
+		t.Error("plaintext found in the source Fs")
+	}
+	fi, err := fs.Stat("/fox.txt")
+	if err != nil || fi.Size() != int64(len(plain)) {
+		t.Errorf("Stat: %v, %v", fi, err)
+	}
+	fis, err := ReadDir(fs, "/")
+	if err != nil || len(fis) != 1 || fis[0].Size() != int64(len(plain)) {
+		t.Errorf("Readdir: %v, %v", fis, err)
+	}
+}
+
+func TestEncryptedFsRandomAccess(t *testing.T) {
+	fs := NewEncryptedFs(NewMemMapFs(), EncryptedFsOptions{Keys: testKeys(), ChunkSize: 16})
+	f, err := fs.Create("/random")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var model []byte
+	rnd := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		switch rnd.Intn(4) {
+		case 0, 1:
+			off := rnd.Int63n(100)
+			p := make([]byte, rnd.Intn(40))
+			rnd.Read(p)
+			if _, err := f.WriteAt(p, off); err != nil {
+				t.Fatal(err)
+			}
+			if end := int(off) + len(p); end > len(model) {
+				model = append(model, make([]byte, end-len(model))...)
+			}
+			copy(model[off:], p)
+		case 2:
+			size := rnd.Int63n(120)
+			if err := f.Truncate(size); err != nil {
+				t.Fatal(err)
+			}
+			if int(size) > len(model) {
+				model = append(model, make([]byte, int(size)-len(model))...)
+			}
+			model = model[:size]
+		case 3:
+			off := rnd.Int63n(120)
+			p := make([]byte, rnd.Intn(40))
+			n, err := f.ReadAt(p, off)
+			want := 0
+			if int(off) < len(model) {
+				want = copy(make([]byte, len(p)), model[off:])
+			}
+			if n != want || (n < len(p) && err != io.EOF) {
+				t.Fatalf("step %d: ReadAt(%d, %d) = %d, %v; want %d bytes", i, len(p), off, n, err, want)
+			}
+			if n > 0 && !bytes.Equal(p[:n], model[off:int(off)+n]) {
+				t.Fatalf("step %d: ReadAt(%d, %d) returned wrong data", i, len(p), off)
+			}
+		}
+		fi, err := f.Stat()
+		if err != nil || fi.Size() != int64(len(model)) {
+			t.Fatalf("step %d: size %d, want %d (%v)", i, fi.Size(), len(model), err)
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	all, err := ioutil.ReadAll(f)
+	if err != nil || !bytes.Equal(all, model) {
+		t.Errorf("sequential read differs from the model: %v", err)
+	}
+}
+
+func TestEncryptedFsAppendAndSeek(t *testing.T) {
+	fs := NewEncryptedFs(NewMemMapFs(), EncryptedFsOptions{Keys: testKeys(), ChunkSize: 8})
+	WriteFile(fs, "/log", []byte("0123456789"), 0644)
+
+	f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("abc")
+	f.WriteString("def")
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Error("read from a write only handle")
+	}
+	f.Close()
+
+	f, _ = fs.Open("/log")
+	defer f.Close()
+	if pos, err := f.Seek(-4, io.SeekEnd); err != nil || pos != 12 {
+		t.Fatalf("Seek: %d, %v", pos, err)
+	}
+	buf := make([]byte, 10)
+	n, _ := f.Read(buf)
+	if string(buf[:n]) != "cdef" {
+		t.Errorf("read %q after seek", buf[:n])
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("wrote to a read only handle")
+	}
+}
+
+func TestEncryptedFsTampering(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewEncryptedFs(base, EncryptedFsOptions{Keys: testKeys(), ChunkSize: 16})
+	plain := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	WriteFile(fs, "/a", plain, 0644)
+	WriteFile(fs, "/b", plain, 0644)
+
+	raw, _ := ReadFile(base, "/a")
+	chunk := 16 + encOverhead
+
+	flipped := append([]byte(nil), raw...)
+	flipped[encHeaderSize+chunk+20] ^= 1
+	WriteFile(base, "/flipped", flipped, 0644)
+	if _, err := ReadFile(fs, "/flipped"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("altered chunk: %v", err)
+	}
+
+	swapped := append([]byte(nil), raw[:encHeaderSize]...)
+	swapped = append(swapped, raw[encHeaderSize+chunk:encHeaderSize+2*chunk]...)
+	swapped = append(swapped, raw[encHeaderSize:encHeaderSize+chunk]...)
+	swapped = append(swapped, raw[encHeaderSize+2*chunk:]...)
+	WriteFile(base, "/swapped", swapped, 0644)
+	if _, err := ReadFile(fs, "/swapped"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("swapped chunks: %v", err)
+	}
+
+	// chunks are bound to their file
+	rawB, _ := ReadFile(base, "/b")
+	mixed := append(append([]byte(nil), raw[:encHeaderSize]...), rawB[encHeaderSize:]...)
+	WriteFile(base, "/mixed", mixed, 0644)
+	if _, err := ReadFile(fs, "/mixed"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("chunks of another file: %v", err)
+	}
+
+	WriteFile(base, "/plain", []byte("not encrypted at all, but long enough for a header......"), 0644)
+	if _, err := fs.Open("/plain"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("plaintext file: %v", err)
+	}
+}
+
+func TestEncryptedFsKeyRotation(t *testing.T) {
+	keys := testKeys()
+	base := NewMemMapFs()
+	fs := NewEncryptedFs(base, EncryptedFsOptions{Keys: keys})
+	WriteFile(fs, "/old", []byte("old"), 0644)
+	keys.Current = "k2"
+	WriteFile(fs, "/new", []byte("new"), 0644)
+
+	for name, want := range map[string]string{"/old": "old", "/new": "new"} {
+		if got, err := ReadFile(fs, name); err != nil || string(got) != want {
+			t.Errorf("%s: %q, %v", name, got, err)
+		}
+	}
+	delete(keys.Keys, "k1")
+	if _, err := ReadFile(fs, "/old"); err == nil {
+		t.Error("read a file whose key is gone")
+	}
+}
+
+func TestEncryptedFsNames(t *testing.T) {
+	base := NewMemMapFs()
+	fs := NewEncryptedFs(base, EncryptedFsOptions{Keys: testKeys(), NameKey: []byte("name secret")})
+	if err := fs.MkdirAll("/secret/plans", 0755); err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(fs, "/secret/plans/world domination.txt", []byte("step 1"), 0644)
+	WriteFile(fs, "/secret/todo", []byte("step 2"), 0644)
+
+	Walk(base, "/", func(path string, fi os.FileInfo, err error) error {
+		if bytes.Contains([]byte(path), []byte("secret")) || bytes.Contains([]byte(path), []byte("plans")) {
+			t.Errorf("plaintext name in the source Fs: %s", path)
+		}
+		return nil
+	})
+
+	names, err := readDirNames(fs, "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"plans", "todo"}) {
+		t.Errorf("Readdirnames: %v", names)
+	}
+	fis, err := ReadDir(fs, "/secret/plans")
+	if err != nil || len(fis) != 1 || fis[0].Name() != "world domination.txt" || fis[0].Size() != 6 {
+		t.Errorf("Readdir: %v, %v", fis, err)
+	}
+	if err := fs.Rename("/secret/todo", "/secret/done"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(fs, "/secret/done"); err != nil || string(got) != "step 2" {
+		t.Errorf("renamed file: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,100 @@
+package afero
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrorHelpers(t *testing.T) {
+	wrap := func(err error) error {
+		return fmt.Errorf("context: %w", &os.PathError{Op: "op", Path: "/p", Err: err})
+	}
+	for _, tt := range []struct {
+		name  string
+		is    func(error) bool
+		match error
+	}{
+		{"IsNotDir", IsNotDir, ErrNotDir},
+		{"IsNotEmpty", IsNotEmpty, ErrNotEmpty},
+		{"IsDirErr", IsDirErr, ErrIsDir},
+		{"IsInvalid", IsInvalid, ErrInvalid},
+		{"IsInvalid", IsInvalid, syscall.EINVAL},
+	} {
+		if !tt.is(tt.match) {
+			t.Errorf("%s(%v) = false", tt.name, tt.match)
+		}
+		if !tt.is(wrap(tt.match)) {
+			t.Errorf("%s(%v) = false", tt.name, wrap(tt.match))
+		}
+		if tt.is(wrap(ErrFileNotFound)) {
+			t.Errorf("%s(%v) = true", tt.name, wrap(ErrFileNotFound))
+		}
+	}
+	if !errors.Is(ErrFileClosed, os.ErrClosed) {
+		t.Error("ErrFileClosed is not os.ErrClosed")
+	}
+}
+
+// checkPathErr fails the test unless err is an *os.PathError for path
+// matching target.
+func checkPathErr(t *testing.T, err error, path string, target error) {
+	t.Helper()
+	var pe *os.PathError
+	if !errors.As(err, &pe) {
+		t.Errorf("got %#v, want an *os.PathError", err)
+		return
+	}
+	if pe.Path != path || !errors.Is(err, target) {
+		t.Errorf("got %v, want a %v error for %s", err, target, path)
+	}
+}
+
+func TestReadOnlyFsErrors(t *testing.T) {
+	base := NewMemMapFs()
+	WriteFile(base, "/file", []byte("data"), 0644)
+	ro := NewReadOnlyFs(base)
+
+	checkPathErr(t, ro.Remove("/file"), "/file", os.ErrPermission)
+	checkPathErr(t, ro.Mkdir("/dir", 0755), "/dir", os.ErrPermission)
+	checkPathErr(t, ro.Chmod("/file", 0600), "/file", os.ErrPermission)
+	_, err := ro.OpenFile("/file", os.O_RDWR, 0)
+	checkPathErr(t, err, "/file", os.ErrPermission)
+
+	err = ro.Rename("/file", "/moved")
+	var le *os.LinkError
+	if !errors.As(err, &le) || le.Old != "/file" || le.New != "/moved" || !os.IsPermission(err) {
+		t.Errorf("Rename: got %#v, want a permission *os.LinkError", err)
+	}
+}
+
+func TestCopyOnWriteFsErrors(t *testing.T) {
+	base := NewMemMapFs()
+	WriteFile(base, "/base", []byte("data"), 0644)
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), NewMemMapFs())
+
+	checkPathErr(t, ufs.Remove("/base"), "/base", os.ErrPermission)
+	checkPathErr(t, ufs.RemoveAll("/base"), "/base", os.ErrPermission)
+	checkPathErr(t, ufs.Remove("/missing"), "/missing", os.ErrNotExist)
+	checkPathErr(t, ufs.Mkdir("/base", 0755), "/base", os.ErrExist)
+	if err := ufs.RemoveAll("/missing"); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+}
+
+func TestMemMapFsClosedErrors(t *testing.T) {
+	fs := NewMemMapFs()
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, err = f.Read(make([]byte, 1))
+	checkPathErr(t, err, "/file", ErrFileClosed)
+	_, err = f.Write([]byte("x"))
+	checkPathErr(t, err, "/file", os.ErrClosed)
+	checkPathErr(t, f.Close(), "/file", os.ErrClosed)
+}
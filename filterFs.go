@@ -0,0 +1,428 @@
+package afero
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*FilterFs)(nil)
+
+// FilterOptions selects the entries visible through a FilterFs created with
+// NewFilterFs. The rules are matched against the slash separated path
+// relative to the root of the source Fs, wrap it in a BasePathFs to filter a
+// sub tree.
+type FilterOptions struct {
+	// Include, if not nil, hides the files that are neither matched by it
+	// nor in a directory matched by it. Directories are not subject to
+	// Include.
+	Include *FilterRules
+
+	// Exclude hides the entries it matches, and the contents of the
+	// directories it matches.
+	Exclude *FilterRules
+
+	// IgnoreFiles lists the names of the ignore files, like ".gitignore" or
+	// ".dockerignore", whose rules apply to the directory they are found in
+	// and below, as git does. Exclude takes precedence over them.
+	IgnoreFiles []string
+}
+
+// The FilterFs hides entries of the source Fs: they can't be seen through
+// Stat, Open or Readdir, nor changed with Rename, Remove, Chmod... as if
+// they didn't exist. The contents of hidden directories are hidden as well.
+// Creating a hidden entry fails with ENOENT.
+type FilterFs struct {
+	source Fs
+	// hidden reports whether name is filtered out, see NewPredicateFs
+	hidden func(name string, fi os.FileInfo) bool
+	// hiddenEntry, if set, is used instead of hidden for the entries
+	// listed by Readdir
+	hiddenEntry func(name string, fi os.FileInfo) bool
+	// hiddenScope, if set, returns the hidden function used for the checks
+	// of a single operation, which may share what they read
+	hiddenScope func() func(name string, fi os.FileInfo) bool
+}
+
+// NewPredicateFs returns a FilterFs hiding the entries for which keep
+// returns false. keep is called with the name of the entry and the
+// os.FileInfo returned by LstatIfPossible, for the entry and each of its
+// parent directories. fi is nil if the entry doesn't exist, except for the
+// directories being created by Mkdir and MkdirAll and for the new name of
+// Rename, which get a description of the entry to be. The entries listed by
+// Readdir are passed joined to the name of the directory.
+func NewPredicateFs(source Fs, keep func(path string, fi os.FileInfo) bool) Fs {
+	return &FilterFs{source: source, hidden: func(path string, fi os.FileInfo) bool {
+		return !keep(path, fi)
+	}}
+}
+
+// NewFilterFs returns a FilterFs hiding the entries of source according to
+// the gitignore-style rules of opts.
+func NewFilterFs(source Fs, opts FilterOptions) Fs {
+	r := &ruleFilter{source: source, opts: opts, ignores: make(map[string]*ignoreFile)}
+	return &FilterFs{source: source, hidden: r.hidden, hiddenScope: r.scope}
+}
+
+// check returns an ENOENT error if name or one of its parent directories is
+// hidden.
+func (f *FilterFs) check(op, name string) error {
+	return f.checkPath(op, name, nil, false)
+}
+
+// checkPath is check for an entry about to be created, or renamed to name.
+// pending describes the entry and is used instead of nil if name doesn't
+// exist yet, and for its missing parents if all is set.
+func (f *FilterFs) checkPath(op, name string, pending os.FileInfo, all bool) error {
+	clean := filepath.Clean(name)
+	var parents []string
+	for d := filepath.Dir(clean); d != "." && d != filepath.Dir(d); d = filepath.Dir(d) {
+		parents = append(parents, d)
+	}
+	parentPending := pending
+	if !all {
+		parentPending = nil
+	}
+	hidden := f.scope()
+	for i := len(parents) - 1; i >= 0; i-- {
+		if f.hiddenName(hidden, parents[i], parentPending) {
+			return &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+		}
+	}
+	if clean != "." && clean != filepath.Dir(clean) && f.hiddenName(hidden, clean, pending) {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	return nil
+}
+
+// scope returns the hidden function for the checks of a single operation.
+func (f *FilterFs) scope() func(name string, fi os.FileInfo) bool {
+	if f.hiddenScope != nil {
+		return f.hiddenScope()
+	}
+	return f.hidden
+}
+
+func (f *FilterFs) hiddenName(hidden func(string, os.FileInfo) bool, name string, pending os.FileInfo) bool {
+	fi, err := lstatIfPossible(f.source, name)
+	if err != nil {
+		fi = nil
+		if pending != nil {
+			fi = renamedFileInfo{FileInfo: pending, name: filepath.Base(name)}
+		}
+	}
+	return hidden(name, fi)
+}
+
+// pendingDirInfo describes a directory about to be created.
+type pendingDirInfo struct {
+	mode os.FileMode
+}
+
+func (i pendingDirInfo) Name() string       { return "" }
+func (i pendingDirInfo) Size() int64        { return 0 }
+func (i pendingDirInfo) Mode() os.FileMode  { return os.ModeDir | i.mode }
+func (i pendingDirInfo) ModTime() time.Time { return time.Now() }
+func (i pendingDirInfo) IsDir() bool        { return true }
+func (i pendingDirInfo) Sys() interface{}   { return nil }
+
+func (f *FilterFs) Name() string {
+	return "FilterFs"
+}
+
+func (f *FilterFs) Capabilities() Features {
+	return wrapperFeatures(f, f.source)
+}
+
+func (f *FilterFs) Unwrap() []Fs {
+	return []Fs{f.source}
+}
+
+func (f *FilterFs) mapPath(name string) (Fs, string, bool) {
+	return f.source, name, true
+}
+
+func (f *FilterFs) Create(name string) (File, error) {
+	if err := f.check("create", name); err != nil {
+		return nil, err
+	}
+	file, err := f.source.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterFile{File: file, fs: f, name: name}, nil
+}
+
+func (f *FilterFs) Mkdir(name string, perm os.FileMode) error {
+	if err := f.checkPath("mkdir", name, pendingDirInfo{mode: perm}, false); err != nil {
+		return err
+	}
+	return f.source.Mkdir(name, perm)
+}
+
+func (f *FilterFs) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.checkPath("mkdir", path, pendingDirInfo{mode: perm}, true); err != nil {
+		return err
+	}
+	return f.source.MkdirAll(path, perm)
+}
+
+func (f *FilterFs) Open(name string) (File, error) {
+	if err := f.check("open", name); err != nil {
+		return nil, err
+	}
+	file, err := f.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterFile{File: file, fs: f, name: name}, nil
+}
+
+func (f *FilterFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := f.check("open", name); err != nil {
+		return nil, err
+	}
+	file, err := f.source.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterFile{File: file, fs: f, name: name}, nil
+}
+
+func (f *FilterFs) Remove(name string) error {
+	if err := f.check("remove", name); err != nil {
+		return err
+	}
+	return f.source.Remove(name)
+}
+
+func (f *FilterFs) RemoveAll(path string) error {
+	if err := f.check("removeall", path); err != nil {
+		return err
+	}
+	return f.source.RemoveAll(path)
+}
+
+func (f *FilterFs) Rename(oldname, newname string) error {
+	if err := f.check("rename", oldname); err != nil {
+		return err
+	}
+	fi, err := lstatIfPossible(f.source, oldname)
+	if err != nil {
+		return err
+	}
+	if err := f.checkPath("rename", newname, fi, false); err != nil {
+		return err
+	}
+	return f.source.Rename(oldname, newname)
+}
+
+func (f *FilterFs) Stat(name string) (os.FileInfo, error) {
+	if err := f.check("stat", name); err != nil {
+		return nil, err
+	}
+	return f.source.Stat(name)
+}
+
+func (f *FilterFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if err := f.check("lstat", name); err != nil {
+		return nil, false, err
+	}
+	if lsf, ok := f.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := f.source.Stat(name)
+	return fi, false, err
+}
+
+func (f *FilterFs) Chmod(name string, mode os.FileMode) error {
+	if err := f.check("chmod", name); err != nil {
+		return err
+	}
+	return f.source.Chmod(name, mode)
+}
+
+func (f *FilterFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := f.check("chtimes", name); err != nil {
+		return err
+	}
+	return f.source.Chtimes(name, atime, mtime)
+}
+
+func (f *FilterFs) SymlinkIfPossible(oldname, newname string) error {
+	if err := f.check("symlink", newname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.ENOENT}
+	}
+	if linker, ok := f.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (f *FilterFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := f.check("readlink", name); err != nil {
+		return "", err
+	}
+	if reader, ok := f.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// FilterFile is a File opened through a FilterFs, Readdir and Readdirnames
+// leave out the hidden entries.
+type FilterFile struct {
+	File
+	fs   *FilterFs
+	name string
+}
+
+func (f *FilterFile) Readdir(count int) ([]os.FileInfo, error) {
+	hidden := f.fs.scope()
+	if f.fs.hiddenEntry != nil {
+		hidden = f.fs.hiddenEntry
+	}
+	for {
+		fis, err := f.File.Readdir(count)
+		var kept []os.FileInfo
+		for _, fi := range fis {
+			if !hidden(filepath.Join(f.name, fi.Name()), fi) {
+				kept = append(kept, fi)
+			}
+		}
+		// a positive count must not return an empty slice before the end
+		if len(kept) > 0 || err != nil || count <= 0 || len(fis) == 0 {
+			return kept, err
+		}
+	}
+}
+
+func (f *FilterFile) Readdirnames(n int) ([]string, error) {
+	fis, err := f.Readdir(n)
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+// ruleFilter hides the entries matched by FilterOptions.
+type ruleFilter struct {
+	source Fs
+	opts   FilterOptions
+
+	mu      sync.Mutex
+	ignores map[string]*ignoreFile
+}
+
+// ignoreFile caches the rules of an ignore file.
+type ignoreFile struct {
+	size    int64
+	modTime time.Time
+	rules   *FilterRules
+}
+
+// filterRel returns the slash separated path of name relative to the root.
+func filterRel(name string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+func (r *ruleFilter) hidden(name string, fi os.FileInfo) bool {
+	return r.hiddenIn(name, fi, nil)
+}
+
+// scope returns the hidden function of a single operation, which reads the
+// ignore files of each directory once.
+func (r *ruleFilter) scope() func(name string, fi os.FileInfo) bool {
+	dirRules := make(map[string][]*FilterRules)
+	return func(name string, fi os.FileInfo) bool {
+		return r.hiddenIn(name, fi, dirRules)
+	}
+}
+
+// hiddenIn is hidden, the rules of the ignore files of the directories are
+// looked up in dirRules first and added to it, if not nil.
+func (r *ruleFilter) hiddenIn(name string, fi os.FileInfo, dirRules map[string][]*FilterRules) bool {
+	rel := filterRel(name)
+	if rel == "" {
+		return false
+	}
+	isDir := fi != nil && fi.IsDir()
+
+	var hide bool
+	if len(r.opts.IgnoreFiles) > 0 {
+		dirs := []string{""}
+		for i := 0; i < len(rel); i++ {
+			if rel[i] == '/' {
+				dirs = append(dirs, rel[:i])
+			}
+		}
+		for _, dir := range dirs {
+			all, ok := dirRules[dir]
+			if !ok {
+				all = make([]*FilterRules, len(r.opts.IgnoreFiles))
+				for i, ignore := range r.opts.IgnoreFiles {
+					all[i] = r.ignoreRules(path.Join("/", dir, ignore))
+				}
+				if dirRules != nil {
+					dirRules[dir] = all
+				}
+			}
+			sub := strings.TrimPrefix(rel[len(dir):], "/")
+			for _, rules := range all {
+				if m, neg := rules.match(sub, isDir); m {
+					hide = !neg
+				}
+			}
+		}
+	}
+	if m, neg := r.opts.Exclude.match(rel, isDir); m {
+		hide = !neg
+	}
+	if hide || isDir || r.opts.Include == nil {
+		return hide
+	}
+
+	if m, neg := r.opts.Include.match(rel, false); m {
+		return neg
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if m, neg := r.opts.Include.match(dir, true); m {
+			return neg
+		}
+	}
+	return true
+}
+
+// ignoreRules returns the rules of the ignore file at the slash separated
+// path name, or nil if there is no such file.
+func (r *ruleFilter) ignoreRules(name string) *FilterRules {
+	name = filepath.FromSlash(name)
+	fi, err := r.source.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	r.mu.Lock()
+	cached := r.ignores[name]
+	r.mu.Unlock()
+	if cached != nil && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.rules
+	}
+	f, err := r.source.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	rules, err := ParseFilterRules(f)
+	if err != nil {
+		return nil
+	}
+	r.mu.Lock()
+	r.ignores[name] = &ignoreFile{size: fi.Size(), modTime: fi.ModTime(), rules: rules}
+	r.mu.Unlock()
+	return rules
+}
@@ -0,0 +1,214 @@
+package afero
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var filterFiles = map[string]string{
+	"src/main.go":      "package main",
+	"src/main_test.go": "package main",
+	"src/gen/x.go":     "package gen",
+	"src/.gitignore":   "gen/\n",
+	"build/out.bin":    "bin",
+	"docs/a.md":        "a",
+	"logs/a.log":       "log",
+	"logs/keep.log":    "keep",
+	"README.md":        "readme",
+	".gitignore":       "*.log\n!keep.log\n",
+}
+
+func visibleNames(t *testing.T, fs Fs) []string {
+	var names []string
+	err := Walk(fs, "/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, filterRel(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFilterFsExclude(t *testing.T) {
+	base := NewMemMapFs()
+	writeTree(t, base, "/", filterFiles)
+	fs := NewFilterFs(base, FilterOptions{
+		Exclude: MustCompileFilterRules("/build/", "*_test.go"),
+	})
+
+	want := []string{"", ".gitignore", "README.md", "docs", "docs/a.md", "logs", "logs/a.log", "logs/keep.log",
+		"src", "src/.gitignore", "src/gen", "src/gen/x.go", "src/main.go"}
+	if got := visibleNames(t, fs); !reflect.DeepEqual(got, want) {
+		t.Errorf("visible entries\n got %v\nwant %v", got, want)
+	}
+
+	for _, name := range []string{"/build", "/build/out.bin", "/src/main_test.go"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Stat(%s): expected not exist, got %v", name, err)
+		}
+		if _, err := fs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("Open(%s): expected not exist, got %v", name, err)
+		}
+		if err := fs.Remove(name); !os.IsNotExist(err) {
+			t.Errorf("Remove(%s): expected not exist, got %v", name, err)
+		}
+	}
+	if err := fs.Rename("/src/main.go", "/src/x_test.go"); !os.IsNotExist(err) {
+		t.Errorf("renaming to an excluded name: %v", err)
+	}
+	if err := fs.Rename("/build/out.bin", "/out.bin"); !os.IsNotExist(err) {
+		t.Errorf("renaming an excluded entry: %v", err)
+	}
+	if _, err := fs.Create("/build/new"); !os.IsNotExist(err) {
+		t.Errorf("creating in an excluded directory: %v", err)
+	}
+}
+
+func TestFilterFsIgnoreFiles(t *testing.T) {
+	base := NewMemMapFs()
+	writeTree(t, base, "/", filterFiles)
+	fs := NewFilterFs(base, FilterOptions{IgnoreFiles: []string{".gitignore"}})
+
+	want := []string{"", ".gitignore", "README.md", "build", "build/out.bin", "docs", "docs/a.md", "logs", "logs/keep.log",
+		"src", "src/.gitignore", "src/main.go", "src/main_test.go"}
+	if got := visibleNames(t, fs); !reflect.DeepEqual(got, want) {
+		t.Errorf("visible entries\n got %v\nwant %v", got, want)
+	}
+
+	// changes to the ignore files are picked up
+	if err := WriteFile(fs, "/.gitignore", []byte("*.md\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/README.md"); !os.IsNotExist(err) {
+		t.Errorf("README.md should be ignored now: %v", err)
+	}
+	if _, err := fs.Stat("/logs/a.log"); err != nil {
+		t.Errorf("a.log should not be ignored anymore: %v", err)
+	}
+}
+
+func TestFilterFsInclude(t *testing.T) {
+	base := NewMemMapFs()
+	writeTree(t, base, "/", filterFiles)
+	fs := NewFilterFs(base, FilterOptions{
+		Include: MustCompileFilterRules("*.go", "docs/", "!*_test.go"),
+	})
+
+	want := []string{"", "build", "docs", "docs/a.md", "logs", "src", "src/gen", "src/gen/x.go", "src/main.go"}
+	if got := visibleNames(t, fs); !reflect.DeepEqual(got, want) {
+		t.Errorf("visible entries\n got %v\nwant %v", got, want)
+	}
+
+	f, err := fs.Open("/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	for {
+		batch, err := f.Readdirnames(1)
+		if err != nil {
+			break
+		}
+		if len(batch) != 1 {
+			t.Fatalf("Readdirnames(1) returned %v", batch)
+		}
+		names = append(names, batch...)
+	}
+	if !reflect.DeepEqual(names, []string{"gen", "main.go"}) {
+		t.Errorf("Readdirnames(1) listed %v", names)
+	}
+}
+
+func TestPredicateFs(t *testing.T) {
+	base := NewMemMapFs()
+	writeTree(t, base, "/", filterFiles)
+	base.Chmod("/src/main.go", os.ModeSetuid|0755)
+	WriteFile(base, "/docs/big.md", make([]byte, 1024), 0644)
+
+	fs := NewPredicateFs(base, func(path string, fi os.FileInfo) bool {
+		if fi == nil {
+			return true
+		}
+		return fi.Mode()&os.ModeSetuid == 0 && fi.Size() < 512
+	})
+
+	if _, err := fs.Stat("/src/main.go"); !os.IsNotExist(err) {
+		t.Errorf("setuid file is visible: %v", err)
+	}
+	if _, err := fs.Open("/docs/big.md"); !os.IsNotExist(err) {
+		t.Errorf("large file is visible: %v", err)
+	}
+	names, err := ReadDir(fs, "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0].Name() != "a.md" {
+		t.Errorf("Readdir doesn't apply the predicate: %v", names)
+	}
+
+	// the predicate is re-evaluated as the files change
+	if err := base.Chmod("/src/main.go", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/src/main.go"); err != nil {
+		t.Errorf("file should be visible once setuid is cleared: %v", err)
+	}
+}
+
+func TestPredicateFsMkdir(t *testing.T) {
+	var seen os.FileInfo
+	fs := NewPredicateFs(NewMemMapFs(), func(path string, fi os.FileInfo) bool {
+		if filterRel(path) == "new" {
+			seen = fi
+		}
+		return fi == nil || !fi.IsDir() || fi.Mode().Perm()&0002 == 0
+	})
+
+	if err := fs.Mkdir("/new", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if seen == nil || !seen.IsDir() {
+		t.Errorf("predicate got %v for a directory being created", seen)
+	}
+	if err := fs.MkdirAll("/open/sub", 0777); !os.IsNotExist(err) {
+		t.Errorf("created a hidden directory: %v", err)
+	}
+}
+
+// statCountingFs counts the calls to Stat.
+type statCountingFs struct {
+	Fs
+	stats int
+}
+
+func (fs *statCountingFs) Stat(name string) (os.FileInfo, error) {
+	fs.stats++
+	return fs.Fs.Stat(name)
+}
+
+func TestFilterFsIgnoreFilesReadOnce(t *testing.T) {
+	base := &statCountingFs{Fs: NewMemMapFs()}
+	writeTree(t, base, "/", map[string]string{
+		"a/b/c/d/e/f/g/h": "h",
+		".gitignore":      "*.log\n",
+	})
+	fs := NewFilterFs(base, FilterOptions{IgnoreFiles: []string{".gitignore", ".dockerignore"}})
+
+	base.stats = 0
+	if _, err := fs.Stat("/a/b/c/d/e/f/g/h"); err != nil {
+		t.Fatal(err)
+	}
+	// the 2 ignore files of each of the 8 directories, the 7 parents and
+	// the file, which is then stat'ed
+	if base.stats > 2*8+7+1+1 {
+		t.Errorf("%d calls to Stat", base.stats)
+	}
+}
@@ -0,0 +1,189 @@
+package afero
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// FilterRules is a set of patterns with the syntax of .gitignore files:
+//
+//	# comment
+//	*.log       matches in any directory
+//	/build      anchored to the root of the rule set
+//	docs/*.md   anchored as well, the pattern contains a slash
+//	cache/      matches directories only
+//	**/tmp      matches in any directory, like tmp
+//	out/**      matches everything inside out
+//	a/**/b      matches a/b, a/x/b, a/x/y/b...
+//	!keep.log   negation, re-includes what a previous pattern matched
+//
+// The last pattern matching a path decides whether it is matched. As with
+// git, an entry can't be re-included if one of its parent directories is
+// matched.
+type FilterRules struct {
+	rules []filterRule
+}
+
+type filterRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// CompileFilterRules compiles the given patterns, blank patterns and
+// comments are ignored.
+func CompileFilterRules(patterns ...string) (*FilterRules, error) {
+	r := &FilterRules{}
+	for _, p := range patterns {
+		if err := r.add(p); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// MustCompileFilterRules is like CompileFilterRules but panics if a pattern
+// can't be compiled.
+func MustCompileFilterRules(patterns ...string) *FilterRules {
+	r, err := CompileFilterRules(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// ParseFilterRules reads the patterns of a .gitignore style file, one per
+// line.
+func ParseFilterRules(rd io.Reader) (*FilterRules, error) {
+	r := &FilterRules{}
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		if err := r.add(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	return r, scanner.Err()
+}
+
+func (r *FilterRules) add(pattern string) error {
+	pattern = strings.TrimSuffix(pattern, "\r")
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(pattern, " ") && !strings.HasSuffix(pattern, `\ `) {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if pattern == "" || pattern[0] == '#' {
+		return nil
+	}
+	rule := filterRule{}
+	switch {
+	case pattern[0] == '!':
+		rule.negate = true
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, `\!`), strings.HasPrefix(pattern, `\#`):
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	re, err := filterPatternToRegexp(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+	}
+	rule.re = re
+	r.rules = append(r.rules, rule)
+	return nil
+}
+
+func filterPatternToRegexp(p string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(p, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	p = strings.TrimPrefix(p, "/")
+	for i := 0; i < len(p); i++ {
+		atStart := i == 0 || p[i-1] == '/'
+		switch c := p[i]; {
+		case atStart && strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case atStart && p[i:] == "**":
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := i + 1
+			if j < len(p) && (p[j] == '!' || p[j] == '^') {
+				j++
+			}
+			if j < len(p) && p[j] == ']' {
+				j++
+			}
+			for j < len(p) && p[j] != ']' {
+				j++
+			}
+			if j >= len(p) {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := p[i+1 : j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i = j
+		case c == '\\' && i+1 < len(p):
+			i++
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// match returns whether a rule matches the slash separated relative path
+// itself, and if so whether the last matching rule is a negation.
+func (r *FilterRules) match(rel string, isDir bool) (matched, negated bool) {
+	if r == nil {
+		return false, false
+	}
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			matched, negated = true, rule.negate
+		}
+	}
+	return matched, negated
+}
+
+// Match reports whether the slash separated path, relative to the root of
+// the rules, or one of its parent directories is matched.
+func (r *FilterRules) Match(rel string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	rel = strings.Trim(path.Clean("/"+rel), "/")
+	if rel == "" {
+		return false
+	}
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' {
+			if m, neg := r.match(rel[:i], true); m && !neg {
+				return true
+			}
+		}
+	}
+	m, neg := r.match(rel, isDir)
+	return m && !neg
+}
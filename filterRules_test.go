@@ -0,0 +1,67 @@
+package afero
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterRulesMatch(t *testing.T) {
+	for _, test := range []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{[]string{"*.log"}, "a.log", false, true},
+		{[]string{"*.log"}, "sub/dir/a.log", false, true},
+		{[]string{"*.log"}, "a.log.txt", false, false},
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "sub/build", true, false},
+		{[]string{"docs/*.md"}, "docs/a.md", false, true},
+		{[]string{"docs/*.md"}, "docs/sub/a.md", false, false},
+		{[]string{"docs/*.md"}, "x/docs/a.md", false, false},
+		{[]string{"cache/"}, "cache", true, true},
+		{[]string{"cache/"}, "cache", false, false},
+		{[]string{"cache/"}, "x/cache/file", false, true},
+		{[]string{"**/tmp"}, "a/b/tmp", false, true},
+		{[]string{"**/tmp"}, "tmp", false, true},
+		{[]string{"out/**"}, "out/a/b", false, true},
+		{[]string{"out/**"}, "out", true, false},
+		{[]string{"a/**/b"}, "a/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/c", false, false},
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "drop.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{[]string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{[]string{"file[0-9].txt"}, "file7.txt", false, true},
+		{[]string{"file[!0-9].txt"}, "file7.txt", false, false},
+		{[]string{"?.txt"}, "a.txt", false, true},
+		{[]string{"?.txt"}, "ab.txt", false, false},
+		{[]string{`\#hash`}, "#hash", false, true},
+		{[]string{`\!bang`}, "!bang", false, true},
+		{[]string{"# comment", ""}, "# comment", false, false},
+		{[]string{"*"}, "", true, false},
+	} {
+		r, err := CompileFilterRules(test.patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Match(test.path, test.isDir); got != test.want {
+			t.Errorf("%q matching %q (dir %v): got %v, want %v", test.patterns, test.path, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestParseFilterRules(t *testing.T) {
+	r, err := ParseFilterRules(strings.NewReader("# build output\r\n/bin/  \n\n*.o\n!main.o\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Match("bin", true) || !r.Match("x.o", false) || r.Match("main.o", false) {
+		t.Error("rules read from a file don't match")
+	}
+	if _, err := CompileFilterRules("[abc"); err == nil {
+		t.Error("expected an error for an unterminated character class")
+	}
+}
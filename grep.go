@@ -0,0 +1,273 @@
+package afero
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+// StopSearch is returned by the callback of SearchTree to stop the search
+// early, SearchTree then returns nil.
+var StopSearch = errors.New("stop search")
+
+// binaryPeekSize is the number of leading bytes searched for a NUL byte, the
+// mark of a binary file, as git does.
+const binaryPeekSize = 8000
+
+// GrepOptions selects the files searched by SearchTree.
+type GrepOptions struct {
+	// Include, if not nil, restricts the search to the files it matches,
+	// or that are in a directory it matches. The rules are matched against
+	// the slash separated path relative to the root of the search.
+	Include *FilterRules
+
+	// Exclude skips the files and directories it matches.
+	Exclude *FilterRules
+
+	// Concurrency is the number of files searched at once, 0 means
+	// GOMAXPROCS.
+	Concurrency int
+
+	// OnError, if not nil, is called with the errors reading a file or a
+	// directory, in walk order. The search goes on if it returns nil, and
+	// stops with the error it returns otherwise. Without it, the first
+	// error ends the search.
+	OnError func(path string, err error) error
+}
+
+// GrepMatch is a line matched by SearchTree.
+type GrepMatch struct {
+	// Path is the path of the file, joined to the root of the search.
+	Path string
+	// Line is the line number, starting at 1.
+	Line int
+	// Offset is the offset in the file of the first match of the line.
+	Offset int64
+	// Text is the line, without its line terminator.
+	Text string
+}
+
+func (a Afero) Grep(root string, re *regexp.Regexp, opts *GrepOptions, fn func(GrepMatch) error) error {
+	return Grep(a.Fs, root, re, opts, fn)
+}
+
+// Grep calls fn for each line matched by re in the files under root, see
+// SearchTree.
+func Grep(fs Fs, root string, re *regexp.Regexp, opts *GrepOptions, fn func(GrepMatch) error) error {
+	return SearchTree(fs, root, func(line []byte) int {
+		if loc := re.FindIndex(line); loc != nil {
+			return loc[0]
+		}
+		return -1
+	}, opts, fn)
+}
+
+func (a Afero) GrepBytes(root string, pattern []byte, opts *GrepOptions, fn func(GrepMatch) error) error {
+	return GrepBytes(a.Fs, root, pattern, opts, fn)
+}
+
+// GrepBytes calls fn for each line containing pattern in the files under
+// root, see SearchTree.
+func GrepBytes(fs Fs, root string, pattern []byte, opts *GrepOptions, fn func(GrepMatch) error) error {
+	return SearchTree(fs, root, func(line []byte) int {
+		return bytes.Index(line, pattern)
+	}, opts, fn)
+}
+
+// SearchTree walks the tree rooted at root, which may also be a file, and
+// calls fn for each line for which match returns the index of a match, or
+// -1 if there is none. Binary files, with a NUL byte in their first 8000
+// bytes, are skipped, as are symlinks.
+//
+// The files are searched concurrently, but fn is called from a single
+// goroutine, with the matches in walk order, as soon as they are found in the
+// file being reported. The search stops at the first error, returned by
+// SearchTree unless GrepOptions.OnError skips it, or when fn returns
+// StopSearch, without reading the rest of the files, including the file being
+// searched.
+func SearchTree(fs Fs, root string, match func(line []byte) int, opts *GrepOptions, fn func(GrepMatch) error) error {
+	if opts == nil {
+		opts = &GrepOptions{}
+	}
+	n := opts.Concurrency
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	var (
+		jobs  = make(chan *grepJob)
+		order = make(chan *grepJob, n)
+		stop  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				select {
+				case <-stop:
+				default:
+					j.err = grepFile(fs, j.path, match, func(m GrepMatch) bool {
+						select {
+						case j.matches <- m:
+							return true
+						case <-stop:
+							return false
+						}
+					})
+				}
+				close(j.matches)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(order)
+		err := Walk(fs, root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if opts.OnError == nil {
+					return err
+				}
+				// reported in order, the walk goes on
+				j := newGrepJob(path)
+				j.err = err
+				close(j.matches)
+				select {
+				case order <- j:
+					return nil
+				case <-stop:
+					return StopSearch
+				}
+			}
+			if !grepSelected(root, path, fi, opts) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			j := newGrepJob(path)
+			for _, ch := range []chan *grepJob{order, jobs} {
+				select {
+				case ch <- j:
+				case <-stop:
+					return StopSearch
+				}
+			}
+			return nil
+		})
+		if err != nil && err != StopSearch {
+			// reported after the matches found before it
+			j := newGrepJob("")
+			j.err = err
+			close(j.matches)
+			select {
+			case order <- j:
+			case <-stop:
+			}
+		}
+	}()
+
+	err := func() error {
+		for j := range order {
+			for m := range j.matches {
+				if err := fn(m); err != nil {
+					return err
+				}
+			}
+			if j.err == nil {
+				continue
+			}
+			if opts.OnError == nil {
+				return j.err
+			}
+			if err := opts.OnError(j.path, j.err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	close(stop)
+	wg.Wait()
+	if err == StopSearch {
+		return nil
+	}
+	return err
+}
+
+// grepJobBuffer is the number of matches a file searched ahead of the one
+// being reported may hold before its search waits.
+const grepJobBuffer = 64
+
+// grepJob is the search of a file, its matches are sent as they are found,
+// the channel is closed at the end of the search, after err is set.
+type grepJob struct {
+	path    string
+	matches chan GrepMatch
+	err     error
+}
+
+func newGrepJob(path string) *grepJob {
+	return &grepJob{path: path, matches: make(chan GrepMatch, grepJobBuffer)}
+}
+
+// grepSelected reports whether path, found under root, passes the filters of
+// opts.
+func grepSelected(root, path string, fi os.FileInfo, opts *GrepOptions) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	if opts.Exclude.Match(rel, fi.IsDir()) {
+		return false
+	}
+	return fi.IsDir() || opts.Include == nil || opts.Include.Match(rel, false)
+}
+
+// grepFile calls emit for each line of the file path for which match finds a
+// match, and stops reading the file when emit returns false.
+func grepFile(fs Fs, path string, match func(line []byte) int, emit func(GrepMatch) bool) error {
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 64*1024)
+	if head, err := r.Peek(binaryPeekSize); err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	} else if bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+
+	var off int64
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if len(b) > 0 {
+			text := bytes.TrimSuffix(bytes.TrimSuffix(b, []byte("\n")), []byte("\r"))
+			if i := match(text); i >= 0 {
+				if !emit(GrepMatch{Path: path, Line: line, Offset: off + int64(i), Text: string(text)}) {
+					return nil
+				}
+			}
+			off += int64(len(b))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
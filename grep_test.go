@@ -0,0 +1,215 @@
+package afero
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var grepFiles = map[string]string{
+	"src/main.go":             "package main\n\n// TODO: flags\nfunc main() {}\n",
+	"src/pkg/util.go":         "package pkg\r\n// TODO: tests\r\n// todo lower case\r\n",
+	"src/pkg/README.md":       "TODO: document\n",
+	"src/vendor/lib/lib.go":   "// TODO: upstream\n",
+	"src/pkg/data.bin":        "TODO\x00binary",
+	"src/pkg/no_newline.go":   "// TODO: last line",
+	"src/pkg/empty.go":        "",
+	"src/pkg/other/notes.txt": "",
+}
+
+func collectGrep(t *testing.T, fs Fs, root string, re string, opts *GrepOptions) []GrepMatch {
+	var matches []GrepMatch
+	err := Grep(fs, root, regexp.MustCompile(re), opts, func(m GrepMatch) error {
+		matches = append(matches, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestGrep(t *testing.T) {
+	fs := NewMemMapFs()
+	writeTree(t, fs, "/", grepFiles)
+	matches := collectGrep(t, fs, "/src", `TODO: \w+`, nil)
+	want := []GrepMatch{
+		{Path: "/src/main.go", Line: 3, Offset: 17, Text: "// TODO: flags"},
+		{Path: "/src/pkg/README.md", Line: 1, Offset: 0, Text: "TODO: document"},
+		{Path: "/src/pkg/no_newline.go", Line: 1, Offset: 3, Text: "// TODO: last line"},
+		{Path: "/src/pkg/util.go", Line: 2, Offset: 16, Text: "// TODO: tests"},
+		{Path: "/src/vendor/lib/lib.go", Line: 1, Offset: 3, Text: "// TODO: upstream"},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("got\n%v\nwant\n%v", matches, want)
+	}
+
+	for _, n := range []int{1, 2, 16} {
+		if got := collectGrep(t, fs, "/src", `TODO: \w+`, &GrepOptions{Concurrency: n}); !reflect.DeepEqual(got, want) {
+			t.Errorf("concurrency %d: got %v", n, got)
+		}
+	}
+
+	if got := collectGrep(t, fs, "/src/main.go", `func`, nil); len(got) != 1 || got[0].Line != 4 {
+		t.Errorf("single file: %v", got)
+	}
+}
+
+func TestGrepFilters(t *testing.T) {
+	fs := NewMemMapFs()
+	writeTree(t, fs, "/", grepFiles)
+	opts := &GrepOptions{
+		Include: MustCompileFilterRules("*.go"),
+		Exclude: MustCompileFilterRules("vendor/"),
+	}
+	var paths []string
+	for _, m := range collectGrep(t, fs, "/src", `(?i)todo`, opts) {
+		paths = append(paths, fmt.Sprintf("%s:%d", m.Path, m.Line))
+	}
+	want := []string{"/src/main.go:3", "/src/pkg/no_newline.go:1", "/src/pkg/util.go:2", "/src/pkg/util.go:3"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestGrepBytes(t *testing.T) {
+	fs := NewMemMapFs()
+	writeTree(t, fs, "/", grepFiles)
+	var n int
+	err := GrepBytes(fs, "/", []byte("TODO"), nil, func(m GrepMatch) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 5 {
+		t.Errorf("%d matches, %v", n, err)
+	}
+}
+
+func TestGrepStop(t *testing.T) {
+	fs := NewMemMapFs()
+	writeTree(t, fs, "/", grepFiles)
+	var got []GrepMatch
+	err := Grep(fs, "/", regexp.MustCompile("TODO"), &GrepOptions{Concurrency: 1}, func(m GrepMatch) error {
+		got = append(got, m)
+		if len(got) == 2 {
+			return StopSearch
+		}
+		return nil
+	})
+	if err != nil || len(got) != 2 {
+		t.Errorf("StopSearch: %d matches, %v", len(got), err)
+	}
+
+	failure := errors.New("failure")
+	err = Grep(fs, "/", regexp.MustCompile("TODO"), nil, func(m GrepMatch) error {
+		return failure
+	})
+	if err != failure {
+		t.Errorf("callback error: %v", err)
+	}
+
+	if err := Grep(fs, "/missing", regexp.MustCompile("x"), nil, func(GrepMatch) error { return nil }); err == nil {
+		t.Error("no error for a missing root")
+	}
+}
+
+// readCountingFs counts the bytes read from its files.
+type readCountingFs struct {
+	Fs
+	n int64
+}
+
+type readCountingFile struct {
+	File
+	fs *readCountingFs
+}
+
+func (fs *readCountingFs) Open(name string) (File, error) {
+	f, err := fs.Fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return readCountingFile{f, fs}, nil
+}
+
+func (f readCountingFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	atomic.AddInt64(&f.fs.n, int64(n))
+	return n, err
+}
+
+func TestGrepStreams(t *testing.T) {
+	mem := NewMemMapFs()
+	big := strings.Repeat("TODO: a match on every line\n", 1<<16)
+	WriteFile(mem, "/big.txt", []byte(big), 0644)
+	fs := &readCountingFs{Fs: mem}
+
+	var got int
+	err := Grep(fs, "/", regexp.MustCompile("TODO"), nil, func(m GrepMatch) error {
+		if got++; got == 10 {
+			return StopSearch
+		}
+		return nil
+	})
+	if err != nil || got != 10 {
+		t.Fatalf("StopSearch: %d matches, %v", got, err)
+	}
+	if n := atomic.LoadInt64(&fs.n); n >= int64(len(big)) {
+		t.Errorf("the whole file was read after StopSearch: %d bytes", n)
+	}
+}
+
+// openFailingFs fails to open the given names.
+type openFailingFs struct {
+	Fs
+	names map[string]bool
+}
+
+func (fs openFailingFs) Open(name string) (File, error) {
+	if fs.names[name] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestGrepOnError(t *testing.T) {
+	base := NewMemMapFs()
+	writeTree(t, base, "/", grepFiles)
+	fs := openFailingFs{base, map[string]bool{"/src/main.go": true, "/src/vendor/lib": true}}
+	re := regexp.MustCompile(`TODO: \w+`)
+
+	if err := Grep(fs, "/src", re, nil, func(GrepMatch) error { return nil }); !os.IsPermission(err) {
+		t.Errorf("without OnError: %v", err)
+	}
+
+	var failed []string
+	opts := &GrepOptions{OnError: func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}}
+	var paths []string
+	err := Grep(fs, "/src", re, opts, func(m GrepMatch) error {
+		paths = append(paths, m.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/src/main.go", "/src/vendor/lib"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("errors for %v, want %v", failed, want)
+	}
+	if want := []string{"/src/pkg/README.md", "/src/pkg/no_newline.go", "/src/pkg/util.go"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("matches in %v, want %v", paths, want)
+	}
+
+	stop := errors.New("stop")
+	opts.OnError = func(string, error) error { return stop }
+	if err := Grep(fs, "/src", re, opts, func(GrepMatch) error { return nil }); err != stop {
+		t.Errorf("OnError failing: %v", err)
+	}
+}
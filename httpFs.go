@@ -59,6 +59,10 @@ func (h HttpFs) Dir(s string) *httpDir {
 
 func (h HttpFs) Name() string { return "h HttpFs" }
 
+func (h HttpFs) Unwrap() []Fs {
+	return []Fs{h.source}
+}
+
 func (h HttpFs) Create(name string) (File, error) {
 	return h.source.Create(name)
 }
@@ -0,0 +1,388 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Lstater = (*InstrumentedFs)(nil)
+
+// OpEvent describes a single completed call on an InstrumentedFs or on one
+// of the files opened through it.
+type OpEvent struct {
+	// Op is the name of the operation, e.g. "open", "read" or "rename".
+	Op string
+	// Path is the file name the operation was called with. For file
+	// operations it is the name of the file handle.
+	Path string
+	// Start is the time the call was made.
+	Start time.Time
+	// Duration is how long the call took.
+	Duration time.Duration
+	// Bytes is the number of bytes read or written, if any.
+	Bytes int64
+	// Err is the error returned by the call, if any.
+	Err error
+}
+
+// Observer receives an OpEvent for every call made through an
+// InstrumentedFs. Observe may be called concurrently from several
+// goroutines; implementations feeding metric or tracing systems should
+// not block.
+type Observer interface {
+	Observe(ev OpEvent)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions as
+// Observers.
+type ObserverFunc func(ev OpEvent)
+
+func (f ObserverFunc) Observe(ev OpEvent) { f(ev) }
+
+// MultiObserver fans out every event to all of its Observers.
+type MultiObserver []Observer
+
+func (m MultiObserver) Observe(ev OpEvent) {
+	for _, o := range m {
+		o.Observe(ev)
+	}
+}
+
+// OpStats holds the aggregated figures for one operation. An io.EOF
+// returned by a read is not counted as an error.
+type OpStats struct {
+	Count  int64
+	Errors int64
+	Bytes  int64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// MemCollector is an Observer that aggregates the events in memory, per
+// operation. It is the default Observer of an InstrumentedFs and is meant
+// to be asserted against in tests.
+type MemCollector struct {
+	mu    sync.Mutex
+	stats map[string]*OpStats
+}
+
+func NewMemCollector() *MemCollector {
+	return &MemCollector{stats: make(map[string]*OpStats)}
+}
+
+func (c *MemCollector) Observe(ev OpEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stats[ev.Op]
+	if !ok {
+		s = &OpStats{}
+		c.stats[ev.Op] = s
+	}
+	s.Count++
+	if ev.Err != nil && ev.Err != io.EOF {
+		s.Errors++
+	}
+	s.Bytes += ev.Bytes
+	s.Total += ev.Duration
+	if ev.Duration > s.Max {
+		s.Max = ev.Duration
+	}
+}
+
+// Stats returns a copy of the figures collected for op.
+func (c *MemCollector) Stats(op string) OpStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.stats[op]; ok {
+		return *s
+	}
+	return OpStats{}
+}
+
+// Ops returns the sorted names of all operations seen so far.
+func (c *MemCollector) Ops() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ops := make([]string, 0, len(c.stats))
+	for op := range c.stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Reset drops all collected figures.
+func (c *MemCollector) Reset() {
+	c.mu.Lock()
+	c.stats = make(map[string]*OpStats)
+	c.mu.Unlock()
+}
+
+// The InstrumentedFs reports every call made on the source Fs, and on the
+// files opened through it, to an Observer: the operation, the path, the
+// latency, the number of bytes transferred and the error returned.
+type InstrumentedFs struct {
+	source Fs
+	obs    Observer
+}
+
+// NewInstrumentedFs wraps source. If obs is nil, a new MemCollector is used,
+// it can be retrieved with Observer().
+func NewInstrumentedFs(source Fs, obs Observer) Fs {
+	if obs == nil {
+		obs = NewMemCollector()
+	}
+	return &InstrumentedFs{source: source, obs: obs}
+}
+
+// Observer returns the Observer the events are reported to.
+func (i *InstrumentedFs) Observer() Observer {
+	return i.obs
+}
+
+func (i *InstrumentedFs) observe(op, name string, start time.Time, n int64, err error) {
+	i.obs.Observe(OpEvent{
+		Op:       op,
+		Path:     name,
+		Start:    start,
+		Duration: time.Since(start),
+		Bytes:    n,
+		Err:      err,
+	})
+}
+
+func (i *InstrumentedFs) wrap(f File) File {
+	if f == nil {
+		return nil
+	}
+	return &InstrumentedFile{File: f, fs: i}
+}
+
+func (i *InstrumentedFs) Name() string {
+	return "InstrumentedFs"
+}
+
+func (i *InstrumentedFs) Capabilities() Features {
+	return wrapperFeatures(i, i.source)
+}
+
+func (i *InstrumentedFs) Unwrap() []Fs {
+	return []Fs{i.source}
+}
+
+func (i *InstrumentedFs) mapPath(name string) (Fs, string, bool) {
+	return i.source, name, true
+}
+
+func (i *InstrumentedFs) Create(name string) (File, error) {
+	start := time.Now()
+	f, err := i.source.Create(name)
+	i.observe("create", name, start, 0, err)
+	return i.wrap(f), err
+}
+
+func (i *InstrumentedFs) Mkdir(name string, perm os.FileMode) error {
+	start := time.Now()
+	err := i.source.Mkdir(name, perm)
+	i.observe("mkdir", name, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) MkdirAll(path string, perm os.FileMode) error {
+	start := time.Now()
+	err := i.source.MkdirAll(path, perm)
+	i.observe("mkdirall", path, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) Open(name string) (File, error) {
+	start := time.Now()
+	f, err := i.source.Open(name)
+	i.observe("open", name, start, 0, err)
+	return i.wrap(f), err
+}
+
+func (i *InstrumentedFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	start := time.Now()
+	f, err := i.source.OpenFile(name, flag, perm)
+	i.observe("openfile", name, start, 0, err)
+	return i.wrap(f), err
+}
+
+func (i *InstrumentedFs) Remove(name string) error {
+	start := time.Now()
+	err := i.source.Remove(name)
+	i.observe("remove", name, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) RemoveAll(path string) error {
+	start := time.Now()
+	err := i.source.RemoveAll(path)
+	i.observe("removeall", path, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) Rename(oldname, newname string) error {
+	start := time.Now()
+	err := i.source.Rename(oldname, newname)
+	i.observe("rename", oldname, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) Stat(name string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := i.source.Stat(name)
+	i.observe("stat", name, start, 0, err)
+	return fi, err
+}
+
+func (i *InstrumentedFs) Chmod(name string, mode os.FileMode) error {
+	start := time.Now()
+	err := i.source.Chmod(name, mode)
+	i.observe("chmod", name, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	start := time.Now()
+	err := i.source.Chtimes(name, atime, mtime)
+	i.observe("chtimes", name, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	start := time.Now()
+	var (
+		fi    os.FileInfo
+		lstat bool
+		err   error
+	)
+	if lsf, ok := i.source.(Lstater); ok {
+		fi, lstat, err = lsf.LstatIfPossible(name)
+	} else {
+		fi, err = i.source.Stat(name)
+	}
+	i.observe("lstat", name, start, 0, err)
+	return fi, lstat, err
+}
+
+func (i *InstrumentedFs) SymlinkIfPossible(oldname, newname string) error {
+	start := time.Now()
+	var err error
+	if linker, ok := i.source.(Linker); ok {
+		err = linker.SymlinkIfPossible(oldname, newname)
+	} else {
+		err = &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	i.observe("symlink", newname, start, 0, err)
+	return err
+}
+
+func (i *InstrumentedFs) ReadlinkIfPossible(name string) (string, error) {
+	start := time.Now()
+	var (
+		link string
+		err  error
+	)
+	if reader, ok := i.source.(LinkReader); ok {
+		link, err = reader.ReadlinkIfPossible(name)
+	} else {
+		err = &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	i.observe("readlink", name, start, 0, err)
+	return link, err
+}
+
+// InstrumentedFile is the File returned by an InstrumentedFs.
+type InstrumentedFile struct {
+	File
+	fs *InstrumentedFs
+}
+
+func (f *InstrumentedFile) Close() error {
+	start := time.Now()
+	err := f.File.Close()
+	f.fs.observe("close", f.Name(), start, 0, err)
+	return err
+}
+
+func (f *InstrumentedFile) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Read(p)
+	f.fs.observe("read", f.Name(), start, int64(n), err)
+	return n, err
+}
+
+func (f *InstrumentedFile) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.ReadAt(p, off)
+	f.fs.observe("readat", f.Name(), start, int64(n), err)
+	return n, err
+}
+
+func (f *InstrumentedFile) Seek(offset int64, whence int) (int64, error) {
+	start := time.Now()
+	ret, err := f.File.Seek(offset, whence)
+	f.fs.observe("seek", f.Name(), start, 0, err)
+	return ret, err
+}
+
+func (f *InstrumentedFile) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Write(p)
+	f.fs.observe("write", f.Name(), start, int64(n), err)
+	return n, err
+}
+
+func (f *InstrumentedFile) WriteAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.WriteAt(p, off)
+	f.fs.observe("writeat", f.Name(), start, int64(n), err)
+	return n, err
+}
+
+func (f *InstrumentedFile) WriteString(s string) (int, error) {
+	start := time.Now()
+	n, err := f.File.WriteString(s)
+	f.fs.observe("write", f.Name(), start, int64(n), err)
+	return n, err
+}
+
+func (f *InstrumentedFile) Readdir(count int) ([]os.FileInfo, error) {
+	start := time.Now()
+	fi, err := f.File.Readdir(count)
+	f.fs.observe("readdir", f.Name(), start, 0, err)
+	return fi, err
+}
+
+func (f *InstrumentedFile) Readdirnames(n int) ([]string, error) {
+	start := time.Now()
+	names, err := f.File.Readdirnames(n)
+	f.fs.observe("readdirnames", f.Name(), start, 0, err)
+	return names, err
+}
+
+func (f *InstrumentedFile) Stat() (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := f.File.Stat()
+	f.fs.observe("fstat", f.Name(), start, 0, err)
+	return fi, err
+}
+
+func (f *InstrumentedFile) Sync() error {
+	start := time.Now()
+	err := f.File.Sync()
+	f.fs.observe("sync", f.Name(), start, 0, err)
+	return err
+}
+
+func (f *InstrumentedFile) Truncate(size int64) error {
+	start := time.Now()
+	err := f.File.Truncate(size)
+	f.fs.observe("truncate", f.Name(), start, 0, err)
+	return err
+}
@@ -0,0 +1,65 @@
+package afero
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInstrumentedFsCollects(t *testing.T) {
+	fs := NewInstrumentedFs(NewMemMapFs(), nil)
+	c := fs.(*InstrumentedFs).Observer().(*MemCollector)
+
+	if err := WriteFile(fs, "/foo.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(fs, "/foo.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/missing"); err == nil {
+		t.Fatal("expected stat of missing file to fail")
+	}
+
+	if s := c.Stats("openfile"); s.Count != 1 || s.Errors != 0 {
+		t.Errorf("openfile: got %+v", s)
+	}
+	if s := c.Stats("write"); s.Count != 1 || s.Bytes != 5 {
+		t.Errorf("write: got %+v", s)
+	}
+	if s := c.Stats("read"); s.Bytes != 5 || s.Errors != 0 {
+		t.Errorf("read: got %+v", s)
+	}
+	if s := c.Stats("stat"); s.Count != 1 || s.Errors != 1 {
+		t.Errorf("stat: got %+v", s)
+	}
+	if s := c.Stats("close"); s.Count != 2 {
+		t.Errorf("close: got %+v", s)
+	}
+
+	c.Reset()
+	if ops := c.Ops(); len(ops) != 0 {
+		t.Errorf("expected no ops after reset, got %v", ops)
+	}
+}
+
+func TestInstrumentedFsObserver(t *testing.T) {
+	var events []OpEvent
+	obs := ObserverFunc(func(ev OpEvent) { events = append(events, ev) })
+	fs := NewInstrumentedFs(NewMemMapFs(), obs)
+
+	fs.Mkdir("/dir", 0755)
+	fs.Rename("/dir", "/other")
+	fs.Remove("/nope")
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0].Op != "mkdir" || events[0].Path != "/dir" || events[0].Err != nil {
+		t.Errorf("unexpected mkdir event %+v", events[0])
+	}
+	if events[1].Op != "rename" || events[1].Err != nil {
+		t.Errorf("unexpected rename event %+v", events[1])
+	}
+	if events[2].Op != "remove" || !os.IsNotExist(events[2].Err) {
+		t.Errorf("unexpected remove event %+v", events[2])
+	}
+}
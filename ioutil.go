@@ -19,7 +19,6 @@ import (
 	"bytes"
 	"io"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -193,7 +192,7 @@ func TempFile(fs Fs, dir, pattern string) (f File, err error) {
 
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		name := filepath.Join(dir, prefix+nextRandom()+suffix)
+		name := pathStyleOf(fs).join(dir, prefix+nextRandom()+suffix)
 		f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 		if os.IsExist(err) {
 			if nconflict++; nconflict > 10 {
@@ -229,7 +228,7 @@ func TempDir(fs Fs, dir, prefix string) (name string, err error) {
 
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		try := filepath.Join(dir, prefix+nextRandom())
+		try := pathStyleOf(fs).join(dir, prefix+nextRandom())
 		err = fs.Mkdir(try, 0700)
 		if os.IsExist(err) {
 			if nconflict++; nconflict > 10 {
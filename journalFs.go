@@ -0,0 +1,300 @@
+package afero
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*JournalFs)(nil)
+
+// JournalRecord is one line of the journal written by a JournalFs. Only the
+// fields relevant to Op are set.
+type JournalRecord struct {
+	Time    time.Time   `json:"time"`
+	Op      string      `json:"op"`
+	Path    string      `json:"path"`
+	NewPath string      `json:"newpath,omitempty"`
+	Flag    int         `json:"flag,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	Offset  int64       `json:"offset,omitempty"`
+	Size    int64       `json:"size,omitempty"`
+	Data    []byte      `json:"data,omitempty"`
+	Atime   *time.Time  `json:"atime,omitempty"`
+	Mtime   *time.Time  `json:"mtime,omitempty"`
+	// Err is the error the operation returned on the journaled Fs, if any.
+	Err string `json:"err,omitempty"`
+}
+
+// The JournalFs appends a JournalRecord, encoded as a line of JSON, to a sink
+// for every mutating call on the source Fs and on the files opened through
+// it: creates, writes, truncates, chmods, chtimes, renames, removes and
+// symlinks. Failed calls are journaled as well, with their error. Reads are
+// not journaled.
+//
+// The journal can be applied to another filesystem with Replay.
+//
+// If a record cannot be written to the sink, the error is returned to the
+// caller even though the call itself has already been performed on the
+// source Fs.
+type JournalFs struct {
+	source Fs
+	mu     sync.Mutex
+	enc    *json.Encoder
+}
+
+func NewJournalFs(source Fs, sink io.Writer) Fs {
+	return &JournalFs{source: source, enc: json.NewEncoder(sink)}
+}
+
+// record writes rec to the sink. It returns err, or the sink error if err
+// is nil and the record could not be written.
+func (j *JournalFs) record(rec JournalRecord, err error) error {
+	rec.Time = time.Now()
+	if err != nil {
+		rec.Err = err.Error()
+	}
+	j.mu.Lock()
+	jerr := j.enc.Encode(&rec)
+	j.mu.Unlock()
+	if err == nil {
+		return jerr
+	}
+	return err
+}
+
+func (j *JournalFs) Name() string {
+	return "JournalFs"
+}
+
+func (j *JournalFs) Capabilities() Features {
+	return wrapperFeatures(j, j.source)
+}
+
+func (j *JournalFs) Unwrap() []Fs {
+	return []Fs{j.source}
+}
+
+func (j *JournalFs) mapPath(name string) (Fs, string, bool) {
+	return j.source, name, true
+}
+
+func (j *JournalFs) Create(name string) (File, error) {
+	f, err := j.source.Create(name)
+	err = j.record(JournalRecord{Op: "create", Path: name}, err)
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	return &JournalFile{File: f, fs: j, name: name}, nil
+}
+
+func (j *JournalFs) Mkdir(name string, perm os.FileMode) error {
+	err := j.source.Mkdir(name, perm)
+	return j.record(JournalRecord{Op: "mkdir", Path: name, Mode: perm}, err)
+}
+
+func (j *JournalFs) MkdirAll(path string, perm os.FileMode) error {
+	err := j.source.MkdirAll(path, perm)
+	return j.record(JournalRecord{Op: "mkdirall", Path: path, Mode: perm}, err)
+}
+
+func (j *JournalFs) Open(name string) (File, error) {
+	f, err := j.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalFile{File: f, fs: j, name: name}, nil
+}
+
+func (j *JournalFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := j.source.OpenFile(name, flag, perm)
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		err = j.record(JournalRecord{Op: "openfile", Path: name, Flag: flag, Mode: perm}, err)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	return &JournalFile{File: f, fs: j, name: name, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (j *JournalFs) Remove(name string) error {
+	err := j.source.Remove(name)
+	return j.record(JournalRecord{Op: "remove", Path: name}, err)
+}
+
+func (j *JournalFs) RemoveAll(path string) error {
+	err := j.source.RemoveAll(path)
+	return j.record(JournalRecord{Op: "removeall", Path: path}, err)
+}
+
+func (j *JournalFs) Rename(oldname, newname string) error {
+	err := j.source.Rename(oldname, newname)
+	return j.record(JournalRecord{Op: "rename", Path: oldname, NewPath: newname}, err)
+}
+
+func (j *JournalFs) Stat(name string) (os.FileInfo, error) {
+	return j.source.Stat(name)
+}
+
+func (j *JournalFs) Chmod(name string, mode os.FileMode) error {
+	err := j.source.Chmod(name, mode)
+	return j.record(JournalRecord{Op: "chmod", Path: name, Mode: mode}, err)
+}
+
+func (j *JournalFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	err := j.source.Chtimes(name, atime, mtime)
+	return j.record(JournalRecord{Op: "chtimes", Path: name, Atime: &atime, Mtime: &mtime}, err)
+}
+
+func (j *JournalFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lsf, ok := j.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := j.source.Stat(name)
+	return fi, false, err
+}
+
+func (j *JournalFs) SymlinkIfPossible(oldname, newname string) error {
+	var err error
+	if linker, ok := j.source.(Linker); ok {
+		err = linker.SymlinkIfPossible(oldname, newname)
+	} else {
+		err = &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	return j.record(JournalRecord{Op: "symlink", Path: oldname, NewPath: newname}, err)
+}
+
+func (j *JournalFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := j.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// JournalFile is the File returned by a JournalFs. Writes are journaled with
+// the offset they were made at, writes to a file opened with O_APPEND are
+// journaled as appends.
+type JournalFile struct {
+	File
+	fs     *JournalFs
+	name   string
+	append bool
+}
+
+func (f *JournalFile) Write(p []byte) (int, error) {
+	if f.append {
+		n, err := f.File.Write(p)
+		return n, f.fs.record(JournalRecord{Op: "append", Path: f.name, Data: p[:n]}, err)
+	}
+	off, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.File.Write(p)
+	return n, f.fs.record(JournalRecord{Op: "write", Path: f.name, Offset: off, Data: p[:n]}, err)
+}
+
+func (f *JournalFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	return n, f.fs.record(JournalRecord{Op: "write", Path: f.name, Offset: off, Data: p[:n]}, err)
+}
+
+func (f *JournalFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *JournalFile) Truncate(size int64) error {
+	err := f.File.Truncate(size)
+	return f.fs.record(JournalRecord{Op: "truncate", Path: f.name, Size: size}, err)
+}
+
+// Replay reads the JSON lines journal written by a JournalFs and performs the
+// same mutations on target, in order. Calls that failed when they were
+// journaled are replayed as well, their outcome is ignored. Replay stops at
+// the first call that succeeded in the journal but fails on target.
+func Replay(journal io.Reader, target Fs) error {
+	dec := json.NewDecoder(journal)
+	for i := 1; ; i++ {
+		var rec JournalRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("journal record %d: %v", i, err)
+		}
+		err := replayRecord(&rec, target)
+		if err != nil && rec.Err == "" {
+			return fmt.Errorf("journal record %d (%s %s): %v", i, rec.Op, rec.Path, err)
+		}
+	}
+}
+
+func replayRecord(rec *JournalRecord, target Fs) error {
+	switch rec.Op {
+	case "create":
+		f, err := target.Create(rec.Path)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case "openfile":
+		f, err := target.OpenFile(rec.Path, rec.Flag, rec.Mode)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case "mkdir":
+		return target.Mkdir(rec.Path, rec.Mode)
+	case "mkdirall":
+		return target.MkdirAll(rec.Path, rec.Mode)
+	case "remove":
+		return target.Remove(rec.Path)
+	case "removeall":
+		return target.RemoveAll(rec.Path)
+	case "rename":
+		return target.Rename(rec.Path, rec.NewPath)
+	case "chmod":
+		return target.Chmod(rec.Path, rec.Mode)
+	case "chtimes":
+		if rec.Atime == nil || rec.Mtime == nil {
+			return ErrInvalid
+		}
+		return target.Chtimes(rec.Path, *rec.Atime, *rec.Mtime)
+	case "symlink":
+		if linker, ok := target.(Linker); ok {
+			return linker.SymlinkIfPossible(rec.Path, rec.NewPath)
+		}
+		return &os.LinkError{Op: "symlink", Old: rec.Path, New: rec.NewPath, Err: ErrNoSymlink}
+	case "write", "append", "truncate":
+		flag := os.O_WRONLY
+		if rec.Op == "append" {
+			flag |= os.O_APPEND
+		}
+		f, err := target.OpenFile(rec.Path, flag, 0)
+		if err != nil {
+			return err
+		}
+		switch rec.Op {
+		case "write":
+			_, err = f.WriteAt(rec.Data, rec.Offset)
+		case "append":
+			_, err = f.Write(rec.Data)
+		case "truncate":
+			err = f.Truncate(rec.Size)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return fmt.Errorf("unknown journal operation %q", rec.Op)
+}
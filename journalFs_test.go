@@ -0,0 +1,98 @@
+package afero
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalFsRecords(t *testing.T) {
+	var buf bytes.Buffer
+	fs := NewJournalFs(NewMemMapFs(), &buf)
+
+	fs.MkdirAll("/a/b", 0755)
+	f, err := fs.Create("/a/b/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("hello")
+	f.Close()
+	ReadFile(fs, "/a/b/file.txt")
+	fs.Remove("/missing")
+
+	var ops []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec JournalRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, rec.Op)
+		if rec.Op == "remove" && rec.Err == "" {
+			t.Error("failed remove was journaled without error")
+		}
+	}
+	if got, want := strings.Join(ops, ","), "mkdirall,create,write,remove"; got != want {
+		t.Errorf("got ops %s, want %s", got, want)
+	}
+}
+
+func TestJournalFsReplay(t *testing.T) {
+	var buf bytes.Buffer
+	src := NewMemMapFs()
+	fs := NewJournalFs(src, &buf)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	fs.MkdirAll("/data", 0755)
+	WriteFile(fs, "/data/a.txt", []byte("0123456789"), 0644)
+	f, _ := fs.OpenFile("/data/a.txt", os.O_RDWR, 0)
+	f.WriteAt([]byte("AB"), 4)
+	f.Truncate(8)
+	f.Close()
+	f, _ = fs.OpenFile("/data/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte("xyz"))
+	f.Close()
+	fs.Chmod("/data/a.txt", 0600)
+	fs.Chtimes("/data/a.txt", mtime, mtime)
+	WriteFile(fs, "/data/b.txt", []byte("b"), 0644)
+	fs.Rename("/data/b.txt", "/data/c.txt")
+	fs.Mkdir("/data", 0755) // fails, must not stop the replay
+
+	target := NewMemMapFs()
+	if err := Replay(&buf, target); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ReadFile(target, "/data/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "0123AB67xyz" {
+		t.Errorf("got %q", b)
+	}
+	fi, err := target.Stat("/data/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v", fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("got mtime %v", fi.ModTime())
+	}
+	if _, err := target.Stat("/data/b.txt"); !os.IsNotExist(err) {
+		t.Errorf("b.txt should have been renamed: %v", err)
+	}
+	if _, err := target.Stat("/data/c.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJournalFsReplayFailure(t *testing.T) {
+	journal := `{"op":"remove","path":"/missing"}` + "\n"
+	if err := Replay(strings.NewReader(journal), NewMemMapFs()); err == nil {
+		t.Error("expected replay of a successful remove of a missing file to fail")
+	}
+}
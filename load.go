@@ -0,0 +1,224 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LoadOptions controls which entries LoadFrom and Mirror copy.
+//
+// Patterns use the syntax of filepath.Match and are matched against both the
+// slash separated path relative to the source root and the base name.
+type LoadOptions struct {
+	// Include, if not empty, restricts the copied files to those matching at
+	// least one of the patterns. Directories are not subject to Include.
+	Include []string
+
+	// Exclude lists the files and directories to skip. The contents of an
+	// excluded directory are skipped as well.
+	Exclude []string
+
+	// MaxSize is the maximum number of bytes of file contents to copy, 0
+	// means no limit. The copy fails with ErrTooLarge when it is exceeded.
+	MaxSize int64
+
+	// FollowSymlinks copies the files symlinks point to instead of the links
+	// themselves. Links to files are always followed if the destination Fs
+	// can't create symlinks; links to directories are then skipped.
+	FollowSymlinks bool
+}
+
+func (o *LoadOptions) matches(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skip reports whether the entry at rel, relative to the source root, is
+// filtered out.
+func (o *LoadOptions) skip(rel string, fi os.FileInfo) bool {
+	if rel == "." {
+		return false
+	}
+	if o.matches(o.Exclude, rel) {
+		return true
+	}
+	return !fi.IsDir() && len(o.Include) > 0 && !o.matches(o.Include, rel)
+}
+
+// LoadFrom copies the tree rooted at srcPath in src to dstPath in dst,
+// preserving modes, modification times and, if both filesystems support
+// them, symlinks. It is typically used to preload a MemMapFs with a fixture
+// directory from an OsFs.
+//
+// A nil opts copies everything.
+func LoadFrom(src Fs, srcPath string, dst Fs, dstPath string, opts *LoadOptions) error {
+	if opts == nil {
+		opts = &LoadOptions{}
+	}
+	l := &loader{src: src, dst: dst, opts: opts}
+	err := Walk(src, srcPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		if opts.skip(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return l.copy(path, filepath.Join(dstPath, rel), fi)
+	})
+	if err != nil {
+		return err
+	}
+	return l.fixDirs()
+}
+
+// loader copies single entries from src to dst.
+type loader struct {
+	src, dst Fs
+	opts     *LoadOptions
+	copied   int64
+	dirs     []string
+	dirInfos map[string]os.FileInfo
+}
+
+func (l *loader) copy(srcName, dstName string, fi os.FileInfo) error {
+	switch {
+	case fi.IsDir():
+		return l.copyDir(dstName, fi)
+	case fi.Mode()&os.ModeSymlink != 0:
+		return l.copySymlink(srcName, dstName)
+	default:
+		return l.copyFile(srcName, dstName, fi)
+	}
+}
+
+func (l *loader) copyDir(dstName string, fi os.FileInfo) error {
+	// the directory stays writable until its contents are copied
+	if err := l.dst.MkdirAll(dstName, fi.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	if err := l.dst.Chmod(dstName, fi.Mode()&chmodBits|0700); err != nil {
+		return err
+	}
+	l.deferDir(dstName, fi)
+	return nil
+}
+
+// deferDir records the mode and times of fi to be set on the directory name
+// once its contents have been written.
+func (l *loader) deferDir(name string, fi os.FileInfo) {
+	if l.dirInfos == nil {
+		l.dirInfos = make(map[string]os.FileInfo)
+	}
+	if _, ok := l.dirInfos[name]; !ok {
+		l.dirs = append(l.dirs, name)
+	}
+	l.dirInfos[name] = fi
+}
+
+func (l *loader) fixDirs() error {
+	sort.Sort(sort.Reverse(sort.StringSlice(l.dirs)))
+	for _, name := range l.dirs {
+		fi := l.dirInfos[name]
+		if err := l.dst.Chmod(name, fi.Mode()&chmodBits); err != nil {
+			return err
+		}
+		mtime := fi.ModTime()
+		if err := l.dst.Chtimes(name, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	l.dirs, l.dirInfos = nil, nil
+	return nil
+}
+
+// followsSymlinks reports whether symlinks are copied as the files they
+// point to.
+func (l *loader) followsSymlinks() bool {
+	_, canRead := l.src.(LinkReader)
+	_, canLink := l.dst.(Linker)
+	return l.opts.FollowSymlinks || !canRead || !canLink
+}
+
+func (l *loader) copySymlink(srcName, dstName string) error {
+	if !l.followsSymlinks() {
+		target, err := l.src.(LinkReader).ReadlinkIfPossible(srcName)
+		if err != nil {
+			return err
+		}
+		return l.dst.(Linker).SymlinkIfPossible(target, dstName)
+	}
+	fi, err := l.src.Stat(srcName)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	return l.copyFile(srcName, dstName, fi)
+}
+
+func (l *loader) copyFile(srcName, dstName string, fi os.FileInfo) error {
+	if l.opts.MaxSize > 0 && l.copied+fi.Size() > l.opts.MaxSize {
+		return &os.PathError{Op: "load", Path: srcName, Err: ErrTooLarge}
+	}
+	in, err := l.src.Open(srcName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := l.dst.OpenFile(dstName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	var r io.Reader = in
+	if l.opts.MaxSize > 0 {
+		// the file may have grown since it was stat'ed
+		r = io.LimitReader(in, l.opts.MaxSize-l.copied+1)
+	}
+	n, err := io.Copy(out, r)
+	l.copied += n
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if l.opts.MaxSize > 0 && l.copied > l.opts.MaxSize {
+		return &os.PathError{Op: "load", Path: srcName, Err: ErrTooLarge}
+	}
+	if err := l.dst.Chmod(dstName, fi.Mode()&chmodBits); err != nil {
+		return err
+	}
+	return l.dst.Chtimes(dstName, fi.ModTime(), fi.ModTime())
+}
+
+// Mirror makes the tree rooted at dstPath in dst identical to the one rooted
+// at srcPath in src, as LoadFrom would have created it. Only the entries that
+// differ in type, size, modification time or mode are updated, entries
+// missing from the source are removed. Entries filtered out by opts are left
+// alone. See Sync for more control.
+func Mirror(src Fs, srcPath string, dst Fs, dstPath string, opts *LoadOptions) error {
+	if opts == nil {
+		opts = &LoadOptions{}
+	}
+	_, err := syncTrees(src, srcPath, dst, dstPath, &SyncOptions{LoadOptions: *opts, Delete: true})
+	return err
+}
@@ -0,0 +1,174 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var loadFiles = map[string]string{
+	"a.txt":         "alpha",
+	"b.log":         "bravo",
+	"sub/c.txt":     "charlie",
+	"skip/d.txt":    "delta",
+	"sub/deep/e.go": "echo",
+}
+
+func TestLoadFrom(t *testing.T) {
+	osFs := NewOsFs()
+	root, err := TempDir(osFs, "", "afero-load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(root)
+	writeTree(t, osFs, root, loadFiles)
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mem := NewMemMapFs()
+	opts := &LoadOptions{Include: []string{"*.txt", "link"}, Exclude: []string{"skip"}}
+	if err := LoadFrom(osFs, root, mem, "/fixture", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/fixture/a.txt", "/fixture/sub/c.txt"} {
+		fi, err := mem.Stat(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Mode().Perm() != 0644 {
+			t.Errorf("%s: got mode %v", name, fi.Mode())
+		}
+		if !fi.ModTime().Equal(treeModTime) {
+			t.Errorf("%s: got mtime %v", name, fi.ModTime())
+		}
+	}
+	for _, name := range []string{"/fixture/b.log", "/fixture/skip", "/fixture/sub/deep/e.go"} {
+		if _, err := mem.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s should have been filtered out: %v", name, err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		// MemMapFs has no symlinks, the link is copied as a file
+		if b, err := ReadFile(mem, "/fixture/link"); err != nil || string(b) != "alpha" {
+			t.Errorf("got %q, %v", b, err)
+		}
+	}
+}
+
+func TestLoadFromMaxSize(t *testing.T) {
+	src := NewMemMapFs()
+	writeTree(t, src, "/src", loadFiles)
+	err := LoadFrom(src, "/src", NewMemMapFs(), "/dst", &LoadOptions{MaxSize: 10})
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	writeTree(t, src, "/src", loadFiles)
+	if err := LoadFrom(src, "/src", dst, "/dst", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	WriteFile(src, "/src/a.txt", []byte("ALPHA!"), 0640)
+	src.Remove("/src/b.log")
+	src.MkdirAll("/src/new", 0700)
+	WriteFile(src, "/src/new/f.txt", []byte("foxtrot"), 0600)
+	WriteFile(dst, "/dst/extra.txt", []byte("extra"), 0600)
+
+	// files Mirror must not touch because they did not change
+	untouched := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	WriteFile(dst, "/dst/sub/c.txt", []byte("CHARLIE"), 0644)
+	dst.Chtimes("/dst/sub/c.txt", untouched, treeModTime)
+
+	if err := Mirror(src, "/src", dst, "/dst", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ReadFile(dst, "/dst/a.txt"); string(b) != "ALPHA!" {
+		t.Errorf("a.txt not updated: %q", b)
+	}
+	if b, _ := ReadFile(dst, "/dst/new/f.txt"); string(b) != "foxtrot" {
+		t.Errorf("new/f.txt not copied: %q", b)
+	}
+	if fi, err := dst.Stat("/dst/new"); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("new dir: %v, %v", fi, err)
+	}
+	for _, name := range []string{"/dst/b.log", "/dst/extra.txt"} {
+		if _, err := dst.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed: %v", name, err)
+		}
+	}
+	// same size and mtime: left alone
+	if b, _ := ReadFile(dst, "/dst/sub/c.txt"); string(b) != "CHARLIE" {
+		t.Errorf("sub/c.txt should not have been copied: %q", b)
+	}
+}
+
+// permFs refuses, like an OsFs for a user other than root, to create entries
+// in the directories that aren't writable by their owner.
+type permFs struct {
+	Fs
+}
+
+func (p permFs) check(op, name string) error {
+	fi, err := p.Fs.Stat(filepath.Dir(name))
+	if err == nil && fi.Mode().Perm()&0200 == 0 {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (p permFs) Create(name string) (File, error) {
+	return p.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (p permFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if _, err := p.Fs.Stat(name); flag&os.O_CREATE != 0 && os.IsNotExist(err) {
+		if err := p.check("open", name); err != nil {
+			return nil, err
+		}
+	}
+	return p.Fs.OpenFile(name, flag, perm)
+}
+
+func (p permFs) Mkdir(name string, perm os.FileMode) error {
+	if err := p.check("mkdir", name); err != nil {
+		return err
+	}
+	return p.Fs.Mkdir(name, perm)
+}
+
+func (p permFs) MkdirAll(path string, perm os.FileMode) error {
+	if _, err := p.Fs.Stat(path); os.IsNotExist(err) {
+		if err := p.check("mkdir", path); err != nil {
+			return err
+		}
+	}
+	return p.Fs.MkdirAll(path, perm)
+}
+
+func TestLoadFromReadOnlyDir(t *testing.T) {
+	src := NewMemMapFs()
+	writeTree(t, src, "/src", map[string]string{"ro/f": "f"})
+	src.Chmod("/src/ro", os.ModeDir|0555)
+
+	dst := permFs{NewMemMapFs()}
+	if err := LoadFrom(src, "/src", dst, "/dst", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ReadFile(dst, "/dst/ro/f"); string(b) != "f" {
+		t.Errorf("ro/f not copied: %q", b)
+	}
+	if fi, err := dst.Stat("/dst/ro"); err != nil || fi.Mode().Perm() != 0555 {
+		t.Errorf("ro: %v, %v", fi, err)
+	}
+}
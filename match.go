@@ -40,18 +40,19 @@ func Glob(fs Fs, pattern string) (matches []string, err error) {
 		return []string{pattern}, nil
 	}
 
-	dir, file := filepath.Split(pattern)
+	style := pathStyleOf(fs)
+	dir, file := style.split(pattern)
 	switch dir {
 	case "":
 		dir = "."
-	case string(filepath.Separator):
+	case style.volumeName(dir) + style.separator():
 	// nothing
 	default:
 		dir = dir[0 : len(dir)-1] // chop off trailing separator
 	}
 
 	if !hasMeta(dir) {
-		return glob(fs, dir, file, nil)
+		return glob(fs, style, dir, file, nil)
 	}
 
 	var m []string
@@ -60,7 +61,7 @@ func Glob(fs Fs, pattern string) (matches []string, err error) {
 		return
 	}
 	for _, d := range m {
-		matches, err = glob(fs, d, file, matches)
+		matches, err = glob(fs, style, d, file, matches)
 		if err != nil {
 			return
 		}
@@ -72,7 +73,7 @@ func Glob(fs Fs, pattern string) (matches []string, err error) {
 // and appends them to matches. If the directory cannot be
 // opened, it returns the existing matches. New matches are
 // added in lexicographical order.
-func glob(fs Fs, dir, pattern string, matches []string) (m []string, e error) {
+func glob(fs Fs, style PathStyle, dir, pattern string, matches []string) (m []string, e error) {
 	m = matches
 	fi, err := fs.Stat(dir)
 	if err != nil {
@@ -96,7 +97,7 @@ func glob(fs Fs, dir, pattern string, matches []string) (m []string, e error) {
 			return m, err
 		}
 		if matched {
-			m = append(m, filepath.Join(dir, n))
+			m = append(m, style.join(dir, n))
 		}
 	}
 	return
@@ -15,11 +15,11 @@
 package mem
 
 import (
-	"bytes"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -34,6 +34,8 @@ type File struct {
 	readDirCount int64
 	closed       bool
 	readOnly     bool
+	writeOnly    bool
+	append       bool
 	fileData     *FileData
 }
 
@@ -45,6 +47,19 @@ func NewReadOnlyFileHandle(data *FileData) *File {
 	return &File{fileData: data, readOnly: true}
 }
 
+// NewFileHandleFlag returns a handle honouring the access mode and the
+// O_APPEND flag of flag, as given to os.OpenFile.
+func NewFileHandleFlag(data *FileData, flag int) *File {
+	f := &File{fileData: data, append: flag&os.O_APPEND != 0}
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		f.readOnly = true
+	case os.O_WRONLY:
+		f.writeOnly = true
+	}
+	return f
+}
+
 func (f File) Data() *FileData {
 	return f.fileData
 }
@@ -52,7 +67,8 @@ func (f File) Data() *FileData {
 type FileData struct {
 	sync.Mutex
 	name    string
-	data    []byte
+	sep     byte
+	data    Storage
 	memDir  Dir
 	dir     bool
 	mode    os.FileMode
@@ -65,8 +81,40 @@ func (d *FileData) Name() string {
 	return d.name
 }
 
+// Size returns the length of the contents of the file.
+func (d *FileData) Size() int64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.storage().Len()
+}
+
+// storage returns the Storage of the file contents, d must be locked.
+func (d *FileData) storage() Storage {
+	if d.data == nil {
+		d.data = newByteStorage(nil)
+	}
+	return d.data
+}
+
 func CreateFile(name string) *FileData {
-	return &FileData{name: name, mode: os.ModeTemporary, modtime: time.Now()}
+	return CreateFileWithStorage(name, newByteStorage(nil))
+}
+
+// CreateFileWithStorage is like CreateFile, the contents of the file are
+// kept in s.
+func CreateFileWithStorage(name string, s Storage) *FileData {
+	return &FileData{name: name, data: s, mode: os.ModeTemporary, modtime: time.Now()}
+}
+
+// GetStorage returns the Storage holding the contents of f, nil for a
+// directory.
+func GetStorage(f *FileData) Storage {
+	f.Lock()
+	defer f.Unlock()
+	if f.dir {
+		return nil
+	}
+	return f.storage()
 }
 
 func CreateDir(name string) *FileData {
@@ -79,6 +127,15 @@ func ChangeFileName(f *FileData, newname string) {
 	f.Unlock()
 }
 
+// SetPathSeparator sets the separator splitting the name of f into its
+// directory and its base name, filepath.Separator by default, for the
+// filesystems emulating the paths of another operating system.
+func SetPathSeparator(f *FileData, sep byte) {
+	f.Lock()
+	f.sep = sep
+	f.Unlock()
+}
+
 func SetMode(f *FileData, mode os.FileMode) {
 	f.Lock()
 	f.mode = mode
@@ -109,15 +166,15 @@ func (f *File) Open() error {
 }
 
 func (f *File) Close() error {
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
 	if f.closed {
-		return os.ErrClosed
+		return &os.PathError{Op: "close", Path: f.fileData.name, Err: ErrFileClosed}
 	}
-	f.fileData.Lock()
 	f.closed = true
 	if !f.readOnly {
 		setModTime(f.fileData, time.Now())
 	}
-	f.fileData.Unlock()
 	return nil
 }
 
@@ -135,7 +192,7 @@ func (f *File) Sync() error {
 
 func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
 	if !f.fileData.dir {
-		return nil, &os.PathError{Op: "readdir", Path: f.fileData.name, Err: errors.New("not a dir")}
+		return nil, &os.PathError{Op: "readdir", Path: f.fileData.name, Err: syscall.ENOTDIR}
 	}
 	var outLength int64
 
@@ -177,45 +234,61 @@ func (f *File) Read(b []byte) (n int, err error) {
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
 	if f.closed == true {
-		return 0, ErrFileClosed
+		return 0, &os.PathError{Op: "read", Path: f.fileData.name, Err: ErrFileClosed}
+	}
+	if len(b) == 0 {
+		return 0, nil
 	}
-	if len(b) > 0 && int(f.at) == len(f.fileData.data) {
+	if f.writeOnly {
+		return 0, &os.PathError{Op: "read", Path: f.fileData.name, Err: errors.New("file handle is write only")}
+	}
+	if f.fileData.dir {
+		return 0, &os.PathError{Op: "read", Path: f.fileData.name, Err: syscall.EISDIR}
+	}
+	size := f.fileData.storage().Len()
+	if len(b) > 0 && f.at == size {
 		return 0, io.EOF
 	}
-	if int(f.at) > len(f.fileData.data) {
+	if f.at > size {
 		return 0, io.ErrUnexpectedEOF
 	}
-	if len(f.fileData.data)-int(f.at) >= len(b) {
-		n = len(b)
-	} else {
-		n = len(f.fileData.data) - int(f.at)
+	n, err = f.fileData.storage().ReadAt(b, f.at)
+	if err == io.EOF {
+		err = nil
 	}
-	copy(b, f.fileData.data[f.at:f.at+int64(n)])
 	atomic.AddInt64(&f.at, int64(n))
 	return
 }
 
+// ReadAt reads from the file at offset off, it does not use nor change the
+// offset of the file handle and may be called concurrently with any other
+// read or write.
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	if off < 0 {
 		return 0, &os.PathError{Op: "readat", Path: f.fileData.name, Err: errors.New("negative offset")}
 	}
-	prev := atomic.LoadInt64(&f.at)
-	atomic.StoreInt64(&f.at, off)
-	n, err = f.Read(b)
-	atomic.StoreInt64(&f.at, prev)
-	if n < len(b) && err == nil {
-		// ReadAt must return an error if n < len(b). See io.ReaderAt
-		if int(off)+n != len(f.fileData.data) {
-			panic("Nil error returned from Read while buffer is not EOF and not filled")
-		}
-		err = io.EOF
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	if f.closed == true {
+		return 0, &os.PathError{Op: "readat", Path: f.fileData.name, Err: ErrFileClosed}
 	}
-	return
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if f.writeOnly {
+		return 0, &os.PathError{Op: "readat", Path: f.fileData.name, Err: errors.New("file handle is write only")}
+	}
+	if f.fileData.dir {
+		return 0, &os.PathError{Op: "readat", Path: f.fileData.name, Err: syscall.EISDIR}
+	}
+	return f.fileData.storage().ReadAt(b, off)
 }
 
 func (f *File) Truncate(size int64) error {
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
 	if f.closed == true {
-		return ErrFileClosed
+		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: ErrFileClosed}
 	}
 	if f.readOnly {
 		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: errors.New("file handle is read only")}
@@ -223,11 +296,8 @@ func (f *File) Truncate(size int64) error {
 	if size < 0 {
 		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: syscall.EINVAL}
 	}
-	if size > int64(len(f.fileData.data)) {
-		diff := size - int64(len(f.fileData.data))
-		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
-	} else {
-		f.fileData.data = f.fileData.data[0:size]
+	if err := f.fileData.storage().Truncate(size); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: err}
 	}
 	setModTime(f.fileData, time.Now())
 	return nil
@@ -235,54 +305,68 @@ func (f *File) Truncate(size int64) error {
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
 	if f.closed == true {
-		return 0, ErrFileClosed
+		return 0, &os.PathError{Op: "seek", Path: f.fileData.name, Err: ErrFileClosed}
 	}
 	switch whence {
 	case 0:
-		atomic.StoreInt64(&f.at, offset)
 	case 1:
-		atomic.AddInt64(&f.at, int64(offset))
+		offset += atomic.LoadInt64(&f.at)
 	case 2:
-		atomic.StoreInt64(&f.at, int64(len(f.fileData.data))+offset)
+		offset += f.fileData.Size()
+	default:
+		offset = -1
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.fileData.name, Err: syscall.EINVAL}
 	}
-	return f.at, nil
+	atomic.StoreInt64(&f.at, offset)
+	return offset, nil
 }
 
 func (f *File) Write(b []byte) (n int, err error) {
-	if f.closed == true {
-		return 0, ErrFileClosed
-	}
-	if f.readOnly {
-		return 0, &os.PathError{Op: "write", Path: f.fileData.name, Err: errors.New("file handle is read only")}
-	}
-	n = len(b)
-	cur := atomic.LoadInt64(&f.at)
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
-	diff := cur - int64(len(f.fileData.data))
-	var tail []byte
-	if n+int(cur) < len(f.fileData.data) {
-		tail = f.fileData.data[n+int(cur):]
-	}
-	if diff > 0 {
-		f.fileData.data = append(bytes.Repeat([]byte{00}, int(diff)), b...)
-		f.fileData.data = append(f.fileData.data, tail...)
-	} else {
-		f.fileData.data = append(f.fileData.data[:cur], b...)
-		f.fileData.data = append(f.fileData.data, tail...)
+	cur := atomic.LoadInt64(&f.at)
+	if f.append && len(b) > 0 {
+		cur = f.fileData.storage().Len()
 	}
-	setModTime(f.fileData, time.Now())
-
-	atomic.StoreInt64(&f.at, int64(len(f.fileData.data)))
+	n, err = f.writeAt(b, cur, "write")
+	atomic.StoreInt64(&f.at, cur+int64(n))
 	return
 }
 
+// WriteAt writes to the file at offset off, it does not use nor change the
+// offset of the file handle and may be called concurrently with any other
+// read or write.
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
 	if off < 0 {
 		return 0, &os.PathError{Op: "writeat", Path: f.fileData.name, Err: errors.New("negative offset")}
 	}
-	atomic.StoreInt64(&f.at, off)
-	return f.Write(b)
+	if f.append {
+		return 0, &os.PathError{Op: "writeat", Path: f.fileData.name, Err: errors.New("invalid use of WriteAt on file opened with O_APPEND")}
+	}
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	return f.writeAt(b, off, "writeat")
+}
+
+// writeAt writes b at off, f.fileData must be locked.
+func (f *File) writeAt(b []byte, off int64, op string) (n int, err error) {
+	if f.closed == true {
+		return 0, &os.PathError{Op: op, Path: f.fileData.name, Err: ErrFileClosed}
+	}
+	if f.readOnly {
+		return 0, &os.PathError{Op: op, Path: f.fileData.name, Err: errors.New("file handle is read only")}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err = f.fileData.storage().WriteAt(b, off)
+	setModTime(f.fileData, time.Now())
+	if err != nil {
+		err = &os.PathError{Op: op, Path: f.fileData.name, Err: err}
+	}
+	return
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -300,9 +384,12 @@ type FileInfo struct {
 // Implements os.FileInfo
 func (s *FileInfo) Name() string {
 	s.Lock()
-	_, name := filepath.Split(s.name)
-	s.Unlock()
-	return name
+	defer s.Unlock()
+	if s.sep == 0 {
+		_, name := filepath.Split(s.name)
+		return name
+	}
+	return s.name[strings.LastIndexByte(s.name, s.sep)+1:]
 }
 func (s *FileInfo) Mode() os.FileMode {
 	s.Lock()
@@ -324,13 +411,11 @@ func (s *FileInfo) Size() int64 {
 	if s.IsDir() {
 		return int64(42)
 	}
-	s.Lock()
-	defer s.Unlock()
-	return int64(len(s.data))
+	return s.FileData.Size()
 }
 
 var (
-	ErrFileClosed        = errors.New("File is closed")
+	ErrFileClosed        = os.ErrClosed
 	ErrTooLarge          = errors.New("Too large")
 	ErrFileNotFound      = os.ErrNotExist
 	ErrFileExists        = os.ErrExist
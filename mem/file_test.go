@@ -2,6 +2,7 @@ package mem
 
 import (
 	"io"
+	"sync"
 	"testing"
 	"time"
 )
@@ -126,7 +127,7 @@ func TestFileDataSizeRace(t *testing.T) {
 	const someOtherDataSize = "Hello World"
 
 	d := FileData{
-		data: []byte(someData),
+		data: newByteStorage([]byte(someData)),
 		dir:  false,
 	}
 
@@ -140,7 +141,7 @@ func TestFileDataSizeRace(t *testing.T) {
 
 	go func() {
 		s.Lock()
-		d.data = []byte(someOtherDataSize)
+		d.data = newByteStorage([]byte(someOtherDataSize))
 		s.Unlock()
 	}()
 
@@ -274,3 +275,112 @@ func TestReadAtMustEOF(t *testing.T) {
 		t.Error("ReadAt must return an error since n < len(buf). Must be EOF here, but got:", err)
 	}
 }
+
+func TestWriteAfterSeekPastEOF(t *testing.T) {
+	t.Parallel()
+
+	f := NewFileHandle(CreateFile("foo"))
+	f.WriteString("abc")
+	f.Seek(5, io.SeekStart)
+	f.WriteString("xy")
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 7 {
+		t.Errorf("expected offset 7 after write, got %d", pos)
+	}
+	f.Seek(1, io.SeekStart)
+	f.WriteString("B")
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 2 {
+		t.Errorf("expected offset 2 after write, got %d", pos)
+	}
+
+	buf := make([]byte, 7)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "aBc\x00\x00xy" {
+		t.Errorf("got %q", buf)
+	}
+}
+
+func TestWriteAtKeepsOffset(t *testing.T) {
+	t.Parallel()
+
+	f := NewFileHandle(CreateFile("foo"))
+	f.WriteString("abcdef")
+	f.Seek(2, io.SeekStart)
+	if _, err := f.WriteAt([]byte("XY"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 2 {
+		t.Errorf("WriteAt should not affect offset, got %d", pos)
+	}
+	buf := make([]byte, 4)
+	if _, err := f.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "cdXY" {
+		t.Errorf("got %q", buf)
+	}
+}
+
+func TestFileConcurrentReadAt(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f := NewFileHandle(CreateFile("foo"))
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			buf := make([]byte, 64)
+			for i := 0; i < 200; i++ {
+				off := int64((g*200 + i) % (len(data) - len(buf)))
+				if _, err := f.ReadAt(buf, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if string(buf) != string(data[off:off+int64(len(buf))]) {
+					t.Errorf("ReadAt(%d) returned data from the wrong offset", off)
+					return
+				}
+			}
+		}(g)
+	}
+	// reads through the cursor must not see the positional reads
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 16)
+		for pos := 0; pos < len(data); pos += len(buf) {
+			if _, err := io.ReadFull(f, buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if string(buf) != string(data[pos:pos+len(buf)]) {
+				t.Errorf("Read at %d returned data from the wrong offset", pos)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestFileInfoNameSeparator(t *testing.T) {
+	f := CreateFile(`C:\dir\file.txt`)
+	SetPathSeparator(f, '\\')
+	if name := GetFileInfo(f).Name(); name != "file.txt" {
+		t.Errorf("Name with the \\ separator: %q", name)
+	}
+	SetPathSeparator(f, '/')
+	if name := GetFileInfo(f).Name(); name != `C:\dir\file.txt` {
+		t.Errorf("Name with the / separator: %q", name)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright © 2020 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import "io"
+
+// Storage holds the contents of a file. Storage implementations need not be
+// safe for concurrent use, the FileData they belong to serialises all calls.
+//
+// ReadAt behaves like io.ReaderAt. Bytes that were never written, e.g. after
+// a WriteAt past the end or a Truncate growing the file, read as zeros.
+// WriteAt and Truncate extend the storage as needed.
+type Storage interface {
+	Len() int64
+	ReadAt(p []byte, off int64) (n int, err error)
+	WriteAt(p []byte, off int64) (n int, err error)
+	Truncate(size int64) error
+}
+
+// byteStorage keeps the contents in a single contiguous slice. It is the
+// default Storage.
+type byteStorage struct {
+	data []byte
+}
+
+func newByteStorage(data []byte) *byteStorage {
+	return &byteStorage{data: data}
+}
+
+func (s *byteStorage) Len() int64 {
+	return int64(len(s.data))
+}
+
+func (s *byteStorage) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= int64(len(s.data)) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n = copy(p, s.data[off:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// grow extends the slice to size bytes, the new bytes are zeroed.
+func (s *byteStorage) grow(size int64) {
+	l := int64(len(s.data))
+	if size <= l {
+		return
+	}
+	if size <= int64(cap(s.data)) {
+		s.data = s.data[:size]
+		for i := l; i < size; i++ {
+			s.data[i] = 0
+		}
+		return
+	}
+	s.data = append(s.data, make([]byte, size-l)...)
+}
+
+func (s *byteStorage) WriteAt(p []byte, off int64) (n int, err error) {
+	s.grow(off + int64(len(p)))
+	return copy(s.data[off:], p), nil
+}
+
+func (s *byteStorage) Truncate(size int64) error {
+	if size > int64(len(s.data)) {
+		s.grow(size)
+	} else {
+		s.data = s.data[:size]
+	}
+	return nil
+}
+
+// DefaultChunkSize is the chunk size used by NewChunkedStorage when none is
+// given.
+const DefaultChunkSize = 64 * 1024
+
+// ChunkedStorage is a sparse Storage keeping the contents in fixed-size
+// chunks. Chunks that were never written are holes that read as zeros and
+// are not allocated, so the memory used is proportional to the bytes
+// actually written rather than to the size of the file. Growing a file
+// never copies the existing contents.
+type ChunkedStorage struct {
+	chunkSize int64
+	size      int64
+	chunks    map[int64][]byte
+}
+
+// NewChunkedStorage returns an empty ChunkedStorage. A chunkSize <= 0 means
+// DefaultChunkSize.
+func NewChunkedStorage(chunkSize int) *ChunkedStorage {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &ChunkedStorage{chunkSize: int64(chunkSize), chunks: make(map[int64][]byte)}
+}
+
+func (s *ChunkedStorage) Len() int64 {
+	return s.size
+}
+
+// Allocated returns the number of bytes held in memory.
+func (s *ChunkedStorage) Allocated() int64 {
+	var n int64
+	for _, c := range s.chunks {
+		n += int64(cap(c))
+	}
+	return n
+}
+
+func (s *ChunkedStorage) ReadAt(p []byte, off int64) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > s.size {
+		end = s.size
+		err = io.EOF
+	}
+	for pos := off; pos < end; {
+		idx, co := pos/s.chunkSize, pos%s.chunkSize
+		l := s.chunkSize - co
+		if l > end-pos {
+			l = end - pos
+		}
+		dst := p[pos-off : pos-off+l]
+		k := 0
+		if c := s.chunks[idx]; int64(len(c)) > co {
+			k = copy(dst, c[co:])
+		}
+		for i := k; i < len(dst); i++ {
+			dst[i] = 0
+		}
+		pos += l
+	}
+	return int(end - off), err
+}
+
+func (s *ChunkedStorage) WriteAt(p []byte, off int64) (n int, err error) {
+	end := off + int64(len(p))
+	for pos := off; pos < end; {
+		idx, co := pos/s.chunkSize, pos%s.chunkSize
+		l := s.chunkSize - co
+		if l > end-pos {
+			l = end - pos
+		}
+		c := s.chunk(idx, co+l)
+		copy(c[co:], p[pos-off:pos-off+l])
+		pos += l
+	}
+	if end > s.size {
+		s.size = end
+	}
+	return len(p), nil
+}
+
+// chunk returns chunk idx, allocated and zero-filled up to length l.
+func (s *ChunkedStorage) chunk(idx, l int64) []byte {
+	c := s.chunks[idx]
+	old := int64(len(c))
+	if l <= old {
+		return c
+	}
+	if l <= int64(cap(c)) {
+		c = c[:l]
+		for i := old; i < l; i++ {
+			c[i] = 0
+		}
+	} else {
+		newCap := 2 * int64(cap(c))
+		if newCap < l {
+			newCap = l
+		}
+		if newCap > s.chunkSize {
+			newCap = s.chunkSize
+		}
+		nc := make([]byte, l, newCap)
+		copy(nc, c)
+		c = nc
+	}
+	s.chunks[idx] = c
+	return c
+}
+
+func (s *ChunkedStorage) Truncate(size int64) error {
+	if size < s.size {
+		last := size / s.chunkSize
+		for idx, c := range s.chunks {
+			switch {
+			case idx > last:
+				delete(s.chunks, idx)
+			case idx == last:
+				if co := size % s.chunkSize; co == 0 {
+					delete(s.chunks, idx)
+				} else if int64(len(c)) > co {
+					s.chunks[idx] = c[:co]
+				}
+			}
+		}
+	}
+	s.size = size
+	return nil
+}
@@ -0,0 +1,90 @@
+package mem
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func testStorage(t *testing.T, s Storage) {
+	if n, err := s.WriteAt([]byte("hello"), 0); n != 5 || err != nil {
+		t.Fatalf("WriteAt: %d, %v", n, err)
+	}
+	// write past the end leaves a hole
+	if _, err := s.WriteAt([]byte("world"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 15 {
+		t.Fatalf("got len %d", s.Len())
+	}
+	buf := make([]byte, 20)
+	n, err := s.ReadAt(buf, 0)
+	if n != 15 || err != io.EOF {
+		t.Fatalf("ReadAt: %d, %v", n, err)
+	}
+	if want := []byte("hello\x00\x00\x00\x00\x00world"); !bytes.Equal(buf[:n], want) {
+		t.Errorf("got %q, want %q", buf[:n], want)
+	}
+
+	// shrinking and growing again must not resurrect old data
+	if err := s.Truncate(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Truncate(8); err != nil {
+		t.Fatal(err)
+	}
+	n, _ = s.ReadAt(buf, 0)
+	if want := []byte("hel\x00\x00\x00\x00\x00"); !bytes.Equal(buf[:n], want) {
+		t.Errorf("got %q, want %q", buf[:n], want)
+	}
+	if _, err := s.WriteAt([]byte("X"), 6); err != nil {
+		t.Fatal(err)
+	}
+	n, _ = s.ReadAt(buf, 0)
+	if want := []byte("hel\x00\x00\x00X\x00"); !bytes.Equal(buf[:n], want) {
+		t.Errorf("got %q, want %q", buf[:n], want)
+	}
+	if n, err := s.ReadAt(buf, 8); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at EOF: %d, %v", n, err)
+	}
+}
+
+func TestByteStorage(t *testing.T) {
+	testStorage(t, newByteStorage(nil))
+}
+
+func TestChunkedStorage(t *testing.T) {
+	testStorage(t, NewChunkedStorage(4))
+}
+
+func TestChunkedStorageSparse(t *testing.T) {
+	s := NewChunkedStorage(0)
+	const size = 1 << 40
+	if _, err := s.WriteAt([]byte("tail"), size-4); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFileHandle(CreateFileWithStorage("sparse", s))
+	if err := f.Truncate(2 * size); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := f.Stat(); fi.Size() != 2*size {
+		t.Errorf("got size %d", fi.Size())
+	}
+	if s.Allocated() > DefaultChunkSize {
+		t.Errorf("allocated %d bytes for a 4 byte write", s.Allocated())
+	}
+
+	buf := make([]byte, 8)
+	if _, err := f.ReadAt(buf, size-4); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "tail\x00\x00\x00\x00" {
+		t.Errorf("got %q", buf)
+	}
+	if _, err := f.Seek(size/2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(buf); err != nil || !bytes.Equal(buf, make([]byte, 8)) {
+		t.Errorf("reading a hole: %q, %v", buf, err)
+	}
+}
@@ -31,30 +31,134 @@ type MemMapFs struct {
 	mu   sync.RWMutex
 	data map[string]*mem.FileData
 	init sync.Once
+	opts MemMapFsOptions
+	// unlinked, if set, is called with mu held for every file and directory
+	// removed from the tree, by Remove, RemoveAll or a Rename replacing it.
+	unlinked func(*mem.FileData)
+}
+
+// MemMapFsOptions configures a MemMapFs created with
+// NewMemMapFsWithOptions.
+type MemMapFsOptions struct {
+	// NewStorage returns the mem.Storage holding the contents of a new file.
+	// The default keeps each file in a single contiguous byte slice, use
+	// mem.NewChunkedStorage for large sparse files.
+	NewStorage func() mem.Storage
+
+	// PathStyle selects the rules followed by the names of the files. With
+	// WindowsPaths, the names differing only in case are the same file,
+	// which keeps the spelling it was created with, the names without a
+	// volume are on C:, the only volume existing besides Volumes, and a file
+	// along the path of a missing file is reported as os.ErrNotExist rather
+	// than ErrNotDir, like on Windows.
+	PathStyle PathStyle
+
+	// Volumes are the volumes existing with WindowsPaths, besides C:, like
+	// D: or \\server\share.
+	Volumes []string
 }
 
 func NewMemMapFs() Fs {
 	return &MemMapFs{}
 }
 
+func NewMemMapFsWithOptions(opts MemMapFsOptions) Fs {
+	return &MemMapFs{opts: opts}
+}
+
+func (m *MemMapFs) createFile(name string) *mem.FileData {
+	var f *mem.FileData
+	if m.opts.NewStorage != nil {
+		f = mem.CreateFileWithStorage(name, m.opts.NewStorage())
+	} else {
+		f = mem.CreateFile(name)
+	}
+	m.setPathSeparator(f)
+	return f
+}
+
+func (m *MemMapFs) createDir(name string) *mem.FileData {
+	d := mem.CreateDir(name)
+	m.setPathSeparator(d)
+	return d
+}
+
+func (m *MemMapFs) setPathSeparator(f *mem.FileData) {
+	if m.opts.PathStyle != HostPaths {
+		mem.SetPathSeparator(f, m.opts.PathStyle.separator()[0])
+	}
+}
+
 func (m *MemMapFs) getData() map[string]*mem.FileData {
 	m.init.Do(func() {
 		m.data = make(map[string]*mem.FileData)
-		// Root should always exist, right?
-		// TODO: what about windows?
-		root := mem.CreateDir(FilePathSeparator)
-		mem.SetMode(root, os.ModeDir|0755)
-		m.data[FilePathSeparator] = root
+		// The roots of the volumes always exist
+		roots := []string{FilePathSeparator}
+		if m.opts.PathStyle == WindowsPaths {
+			roots = []string{`C:\`}
+			for _, vol := range m.opts.Volumes {
+				roots = append(roots, m.opts.PathStyle.rooted(vol))
+			}
+		}
+		for _, name := range roots {
+			root := m.createDir(name)
+			mem.SetMode(root, os.ModeDir|0755)
+			m.data[m.key(name)] = root
+		}
 	})
 	return m.data
 }
 
+// normalizePath is the package normalizePath, following the path style of
+// m.
+func (m *MemMapFs) normalizePath(path string) string {
+	return m.opts.PathStyle.rooted(path)
+}
+
+// key returns the key of the normalized name in m.data: with WindowsPaths,
+// the names differing only in case are the same file.
+func (m *MemMapFs) key(name string) string {
+	if m.opts.PathStyle == WindowsPaths {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// spelledName returns name with its parent directory spelled as it was
+// created, for a new entry of a case insensitive m. It must be called with
+// m.mu held.
+func (m *MemMapFs) spelledName(name string) string {
+	if m.opts.PathStyle != WindowsPaths {
+		return name
+	}
+	s := m.opts.PathStyle
+	parent, ok := m.getData()[m.key(s.dir(name))]
+	if !ok {
+		return name
+	}
+	_, base := s.split(name)
+	return s.join(parent.Name(), base)
+}
+
 func (*MemMapFs) Name() string { return "MemMapFS" }
 
+func (m *MemMapFs) Capabilities() Features {
+	return Features{
+		Chmod:           true,
+		Chtimes:         true,
+		AtomicRename:    true,
+		CaseInsensitive: m.opts.PathStyle == WindowsPaths,
+		PathStyle:       m.opts.PathStyle,
+	}
+}
+
 func (m *MemMapFs) Create(name string) (File, error) {
 	const createPerm = 0666
 
-	name = normalizePath(name)
+	name = m.normalizePath(name)
+	if err := m.opts.PathStyle.validate(name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
 	err := m.requireParentDirectory("open", name)
 	if err != nil {
 		return nil, err
@@ -66,9 +170,9 @@ func (m *MemMapFs) Create(name string) (File, error) {
 		// if not exist or is a file, truncate
 		m.mu.Lock()
 		m.lockFreeRemoveAll(name)
-		file := mem.CreateFile(name)
+		file := m.createFile(m.spelledName(name))
 		mem.SetMode(file, createPerm)
-		m.getData()[name] = file
+		m.getData()[m.key(name)] = file
 		m.registerWithParent(file)
 		m.mu.Unlock()
 		return mem.NewFileHandle(file), nil
@@ -79,7 +183,7 @@ func (m *MemMapFs) Create(name string) (File, error) {
 	default:
 		// exists and is a file
 		m.mu.RLock()
-		fileData := m.getData()[name]
+		fileData := m.getData()[m.key(name)]
 		m.mu.RUnlock()
 		file := mem.NewFileHandle(fileData)
 		err := file.Truncate(0)
@@ -105,8 +209,8 @@ func (m *MemMapFs) unRegisterWithParent(fileName string) error {
 
 // requireParentDirectory requires the parent to 'path' exists and is a directory
 func (m *MemMapFs) requireParentDirectory(operationName, path string) error {
-	path = normalizePath(path)
-	parentPath := filepath.Dir(path)
+	path = m.normalizePath(path)
+	parentPath := m.opts.PathStyle.dir(path)
 	parent, parentErr := m.Stat(parentPath)
 	if parentErr != nil {
 		if os.IsNotExist(parentErr) {
@@ -121,8 +225,7 @@ func (m *MemMapFs) requireParentDirectory(operationName, path string) error {
 }
 
 func (m *MemMapFs) findParent(f *mem.FileData) *mem.FileData {
-	pdir, _ := filepath.Split(f.Name())
-	pdir = filepath.Clean(pdir)
+	pdir := m.opts.PathStyle.dir(f.Name())
 	pfile, err := m.lockfreeOpen(pdir)
 	if err != nil {
 		return nil
@@ -147,23 +250,26 @@ func (m *MemMapFs) registerWithParent(f *mem.FileData) {
 
 func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
 	perm &= chmodBits
-	name = normalizePath(name)
+	name = m.normalizePath(name)
 
 	m.mu.RLock()
-	_, ok := m.getData()[name]
+	_, ok := m.getData()[m.key(name)]
 	m.mu.RUnlock()
 	if ok {
 		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
 
+	if err := m.opts.PathStyle.validate(name); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
 	err := m.requireParentDirectory("mkdir", name)
 	if err != nil {
 		return err
 	}
 
 	m.mu.Lock()
-	item := mem.CreateDir(name)
-	m.getData()[name] = item
+	item := m.createDir(m.spelledName(name))
+	m.getData()[m.key(name)] = item
 	m.registerWithParent(item)
 	m.mu.Unlock()
 
@@ -187,12 +293,13 @@ func (m *MemMapFs) MkdirAll(path string, perm os.FileMode) error {
 
 // findMissingDirs returns all paths that must be created, in reverse order
 func (m *MemMapFs) findMissingDirs(path string) ([]string, error) {
-	path = normalizePath(path)
+	path = m.normalizePath(path)
 	var missingDirs []string
-	for currentPath := path; currentPath != FilePathSeparator; currentPath = filepath.Dir(currentPath) {
+	root := m.opts.PathStyle.root(path)
+	for currentPath := path; currentPath != root; currentPath = m.opts.PathStyle.dir(currentPath) {
 		info, err := m.Stat(currentPath)
 		switch {
-		case os.IsNotExist(err):
+		case os.IsNotExist(err) || IsNotDir(err):
 			missingDirs = append(missingDirs, currentPath)
 		case err != nil:
 			return nil, err
@@ -238,20 +345,41 @@ func (m *MemMapFs) openWrite(name string) (File, error) {
 }
 
 func (m *MemMapFs) open(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
+	name = m.normalizePath(name)
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
+	defer m.mu.RUnlock()
+	f, ok := m.getData()[m.key(name)]
 	if !ok {
-		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileNotFound}
+		return nil, &os.PathError{Op: "open", Path: name, Err: m.notFoundErr(name)}
 	}
 	return f, nil
 }
 
+// notFoundErr returns the error of the missing file name: ErrNotDir if its
+// closest existing parent is a file, as on Unix, ErrFileNotFound otherwise.
+// It must be called with m.mu held.
+func (m *MemMapFs) notFoundErr(name string) error {
+	if m.opts.PathStyle == WindowsPaths {
+		// ERROR_PATH_NOT_FOUND
+		return ErrFileNotFound
+	}
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if f, ok := m.getData()[m.key(dir)]; ok {
+			if mem.GetFileInfo(f).IsDir() {
+				return ErrFileNotFound
+			}
+			return ErrNotDir
+		}
+		if dir == FilePathSeparator {
+			return ErrFileNotFound
+		}
+	}
+}
+
 func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
-	f, ok := m.getData()[name]
+	name = m.normalizePath(name)
+	f, ok := m.getData()[m.key(name)]
 	if ok {
 		return f, nil
 	} else {
@@ -282,16 +410,6 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 			return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDir}
 		}
 	}
-	if flag == os.O_RDONLY {
-		file = mem.NewReadOnlyFileHandle(file.(*mem.File).Data())
-	}
-	if flag&os.O_APPEND > 0 {
-		_, err = file.Seek(0, os.SEEK_END)
-		if err != nil {
-			file.Close()
-			return nil, err
-		}
-	}
 	if flag&os.O_TRUNC > 0 {
 		err = file.Truncate(0)
 		if err != nil {
@@ -299,6 +417,7 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 			return nil, err
 		}
 	}
+	file = mem.NewFileHandleFlag(file.(*mem.File).Data(), flag)
 	if chmod {
 		return file, m.unrestrictedChmod(name, perm)
 	}
@@ -306,12 +425,12 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 }
 
 func (m *MemMapFs) Remove(name string) error {
-	name = normalizePath(name)
+	name = m.normalizePath(name)
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if f, ok := m.getData()[name]; ok {
+	if f, ok := m.getData()[m.key(name)]; ok {
 		if mem.GetFileInfo(f).IsDir() {
 			dir, err := mem.ReadMemDir(f)
 			if err != nil {
@@ -325,22 +444,32 @@ func (m *MemMapFs) Remove(name string) error {
 		if err != nil {
 			return &os.PathError{Op: "remove", Path: name, Err: err}
 		}
-		delete(m.getData(), name)
+		delete(m.getData(), m.key(name))
+		if m.unlinked != nil {
+			m.unlinked(f)
+		}
 	} else {
-		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+		return &os.PathError{Op: "remove", Path: name, Err: m.notFoundErr(name)}
 	}
 	return nil
 }
 
 func (m *MemMapFs) RemoveAll(path string) error {
+	path = m.normalizePath(path)
 	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.getData()[m.key(path)]; !ok {
+		if err := m.notFoundErr(path); err != ErrFileNotFound {
+			return &os.PathError{Op: "unlinkat", Path: path, Err: err}
+		}
+		return nil
+	}
 	m.lockFreeRemoveAll(path)
-	m.mu.Unlock()
 	return nil
 }
 
 func (m *MemMapFs) lockFreeRemoveAll(path string) {
-	path = normalizePath(path)
+	path = m.normalizePath(path)
 	fileData, err := m.lockfreeOpen(path)
 	if err == ErrFileNotFound {
 		return
@@ -352,21 +481,48 @@ func (m *MemMapFs) lockFreeRemoveAll(path string) {
 	if err != nil {
 		panic("failed to unregister with parent: " + err.Error())
 	}
-	defer delete(m.getData(), path)
+	defer func() {
+		delete(m.getData(), m.key(path))
+		if m.unlinked != nil {
+			m.unlinked(fileData)
+		}
+	}()
 
 	dir, err := mem.ReadMemDir(fileData)
 	if err == nil {
 		for _, f := range dir {
-			m.lockFreeRemoveAll(filepath.Join(path, f.Name()))
+			m.lockFreeRemoveAll(m.opts.PathStyle.join(path, f.Name()))
 		}
 	}
 	return
 }
 
 func (m *MemMapFs) Rename(oldname, newname string) error {
-	oldname = normalizePath(oldname)
-	newname = normalizePath(newname)
+	oldname = m.normalizePath(oldname)
+	newname = m.normalizePath(newname)
+	if err := m.opts.PathStyle.validate(newname); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
 
+	for _, name := range []string{oldname, newname} {
+		if err := m.requireParentDirectory("rename", name); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err.(*os.PathError).Err}
+		}
+	}
+	oldInfo, err := m.Stat(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err.(*os.PathError).Err}
+	}
+	if oldname != newname && m.key(oldname) == m.key(newname) {
+		// the same file, whose spelling changes
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.getData()[m.key(oldname)]; !ok {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
+		}
+		m.lockFreeRename(oldname, m.spelledName(newname))
+		return nil
+	}
 	info, err := m.Stat(newname)
 	if err == nil && info.IsDir() {
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileExists}
@@ -375,43 +531,47 @@ func (m *MemMapFs) Rename(oldname, newname string) error {
 	if oldname == newname {
 		return nil
 	}
-	if strings.HasPrefix(newname, oldname+FilePathSeparator) {
+	if strings.HasPrefix(m.key(newname), m.key(oldname)+m.opts.PathStyle.separator()) {
 		// new path must not be inside the old path
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrInvalid}
 	}
+	if err == nil && oldInfo.IsDir() {
+		// a directory can't replace a file
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrNotDir}
+	}
 
 	m.mu.RLock()
-	_, ok := m.getData()[oldname]
+	_, ok := m.getData()[m.key(oldname)]
 	m.mu.RUnlock()
 	if ok {
 		// File existed a moment ago. Upgrade to full write lock, then double-check 'ok' is still true.
 		m.mu.Lock()
 		defer m.mu.Unlock()
-		_, ok = m.getData()[oldname]
+		_, ok = m.getData()[m.key(oldname)]
 	}
 	if !ok {
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
 	}
 
-	newParentDir := filepath.Dir(newname)
-	if _, ok := m.getData()[newParentDir]; !ok {
+	newParentDir := m.opts.PathStyle.dir(newname)
+	if _, ok := m.getData()[m.key(newParentDir)]; !ok {
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
 	}
 
 	// proceed with rename. if newname exists, delete it
 	m.lockFreeRemoveAll(newname)
 
-	m.lockFreeRename(oldname, newname)
+	m.lockFreeRename(oldname, m.spelledName(newname))
 	return nil
 }
 
 func (m *MemMapFs) lockFreeRename(oldname, newname string) {
 	// 1. add file data to new map location
-	fileData, ok := m.getData()[oldname]
+	fileData, ok := m.getData()[m.key(oldname)]
 	if !ok {
 		panic("File not found: " + oldname)
 	}
-	m.getData()[newname] = fileData
+	m.getData()[m.key(newname)] = fileData
 
 	// 2. record children entries before rename
 	dir, err := mem.ReadMemDir(fileData)
@@ -433,13 +593,15 @@ func (m *MemMapFs) lockFreeRename(oldname, newname string) {
 	// 6. recurse into children, renaming each one
 	for _, f := range dir {
 		m.lockFreeRename(
-			filepath.Join(oldname, f.Name()),
-			filepath.Join(newname, f.Name()),
+			m.opts.PathStyle.join(oldname, f.Name()),
+			m.opts.PathStyle.join(newname, f.Name()),
 		)
 	}
 
-	// 7. delete old file data from map
-	delete(m.getData(), oldname)
+	// 7. delete old file data from map, unless only the spelling changed
+	if m.key(oldname) != m.key(newname) {
+		delete(m.getData(), m.key(oldname))
+	}
 }
 
 func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
@@ -452,15 +614,17 @@ func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
 }
 
 func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
+	name = m.normalizePath(name)
 	mode &= chmodBits
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
+	f, ok := m.getData()[m.key(name)]
 	if !ok {
-		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
+		err := m.notFoundErr(name)
+		m.mu.RUnlock()
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
 	}
+	m.mu.RUnlock()
 	prevOtherBits := mem.GetFileInfo(f).Mode() & ^chmodBits
 
 	mode = prevOtherBits | mode
@@ -468,10 +632,10 @@ func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
 }
 
 func (m *MemMapFs) unrestrictedChmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
+	name = m.normalizePath(name)
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	f, ok := m.getData()[m.key(name)]
 	m.mu.RUnlock()
 	if !ok {
 		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
@@ -485,14 +649,16 @@ func (m *MemMapFs) unrestrictedChmod(name string, mode os.FileMode) error {
 }
 
 func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normalizePath(name)
+	name = m.normalizePath(name)
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
+	f, ok := m.getData()[m.key(name)]
 	if !ok {
-		return &os.PathError{Op: "chtimes", Path: name, Err: ErrFileNotFound}
+		err := m.notFoundErr(name)
+		m.mu.RUnlock()
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
 	}
+	m.mu.RUnlock()
 
 	m.mu.Lock()
 	mem.SetModTime(f, mtime)
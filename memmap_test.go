@@ -1,13 +1,18 @@
 package afero
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/spf13/afero/mem"
 )
 
 func TestNormalizePath(t *testing.T) {
@@ -558,6 +563,72 @@ func TestMemFsUnexpectedEOF(t *testing.T) {
 	}
 }
 
+func TestMemFsConcurrentPositionalIO(t *testing.T) {
+	t.Parallel()
+
+	const (
+		workers = 8
+		block   = 512
+		rounds  = 50
+	)
+	fs := NewMemMapFs()
+	f, err := fs.Create("/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(workers * block); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			off := int64(w * block)
+			want := bytes.Repeat([]byte{byte('a' + w)}, block)
+			got := make([]byte, block)
+			for i := 0; i < rounds; i++ {
+				if _, err := f.WriteAt(want, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := f.ReadAt(got, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("worker %d read back foreign data", w)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 100)
+		for i := 0; i < rounds; i++ {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Error(err)
+				return
+			}
+			n, err := io.ReadFull(f, make([]byte, workers*block))
+			if err != nil || n != workers*block {
+				t.Errorf("sequential read got %d bytes: %v", n, err)
+				return
+			}
+			f.ReadAt(buf, 0)
+		}
+	}()
+	wg.Wait()
+
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != workers*block {
+		t.Errorf("positional I/O moved the cursor to %d", pos)
+	}
+}
+
 // https://github.com/spf13/afero/issues/149
 func TestMemFsMkdirWithoutParent(t *testing.T) {
 	t.Parallel()
@@ -964,3 +1035,165 @@ func TestMemFsOpenFileTruncateReadOnly(t *testing.T) {
 		t.Error("Truncate on read-only settings should work. Actual size after truncate open:", info.Size())
 	}
 }
+
+func TestMemFsChunkedStorage(t *testing.T) {
+	fs := NewMemMapFsWithOptions(MemMapFsOptions{
+		NewStorage: func() mem.Storage { return mem.NewChunkedStorage(16) },
+	})
+	f, err := fs.Create("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("end"), 1<<30)
+	f.Seek(5, io.SeekStart)
+	f.Write([]byte("middle"))
+	f.Close()
+
+	fi, err := fs.Stat("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 1<<30+3 {
+		t.Errorf("got size %d", fi.Size())
+	}
+	f, _ = fs.Open("/big")
+	buf := make([]byte, 16)
+	if _, err := f.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "\x00\x00\x00\x00\x00middle\x00\x00\x00\x00\x00" {
+		t.Errorf("got %q", buf)
+	}
+}
+
+func TestMemFsWindowsPaths(t *testing.T) {
+	fs := NewMemMapFsWithOptions(MemMapFsOptions{
+		PathStyle: WindowsPaths,
+		Volumes:   []string{"d:", `\\server\share`},
+	})
+
+	if err := fs.MkdirAll(`C:/Dir/sub`, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(`\Dir\a.txt. `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != `C:\Dir\a.txt` {
+		t.Errorf("Name: %q", f.Name())
+	}
+	f.Close()
+	for _, name := range []string{`Dir\a.txt`, `c:\Dir\.\sub\..\a.txt`, `C:Dir/a.txt.`} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Errorf("Stat(%q): %v", name, err)
+		} else if fi.Name() != "a.txt" {
+			t.Errorf("Stat(%q).Name() = %q", name, fi.Name())
+		}
+	}
+	dir, _ := fs.Open(`C:\Dir`)
+	names, _ := dir.Readdirnames(-1)
+	dir.Close()
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "sub" {
+		t.Errorf("Readdirnames: %q", names)
+	}
+
+	// Windows errors
+	for _, name := range []string{`C:\Dir\CON`, `C:\Dir\nul.txt`, `C:\Dir\a?b`, `C:\Dir\a:b`} {
+		if _, err := fs.Create(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Create(%q): %v", name, err)
+		}
+		if err := fs.Mkdir(name, 0755); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Mkdir(%q): %v", name, err)
+		}
+	}
+	if err := fs.Rename(`C:\Dir\a.txt`, `C:\Dir\LPT1`); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Rename to a reserved name: %v", err)
+	}
+	_, err = fs.Open(`C:\Dir\a.txt\child`)
+	if !os.IsNotExist(err) || IsNotDir(err) {
+		t.Errorf("Open under a file: %v", err)
+	}
+
+	// volumes
+	if err := fs.MkdirAll(`E:\dir`, 0755); !os.IsNotExist(err) {
+		t.Errorf("MkdirAll on a missing volume: %v", err)
+	}
+	for _, name := range []string{`D:\dir\file`, `\\server\share\dir\file`} {
+		if err := WriteFile(fs, name, []byte("data"), 0644); !os.IsNotExist(err) {
+			t.Errorf("WriteFile(%q) in a missing directory: %v", name, err)
+		}
+		if err := fs.MkdirAll(name[:len(name)-len(`\file`)], 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(fs, name, []byte("data"), 0644); err != nil {
+			t.Errorf("WriteFile(%q): %v", name, err)
+		}
+	}
+	if _, err := fs.Stat(`//server/share/dir/file`); err != nil {
+		t.Errorf("Stat with slashes: %v", err)
+	}
+
+	// rename
+	if err := fs.Rename(`C:\Dir`, `C:\Renamed`); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(`C:\Renamed\a.txt`); err != nil || fi.Name() != "a.txt" {
+		t.Errorf("Stat after rename: %v", err)
+	}
+}
+
+func TestMemFsWindowsPathsCaseInsensitive(t *testing.T) {
+	fs := NewMemMapFsWithOptions(MemMapFsOptions{PathStyle: WindowsPaths})
+	if !Capabilities(fs).CaseInsensitive {
+		t.Error("Capabilities: not case insensitive")
+	}
+	fs.MkdirAll(`C:\Docs`, 0755)
+	if err := WriteFile(fs, `C:\docs\Foo.txt`, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat(`c:\DOCS\foo.TXT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "Foo.txt" {
+		t.Errorf("Name: %q", fi.Name())
+	}
+	f, _ := fs.Open(`C:\docs\FOO.txt`)
+	if f.Name() != `C:\Docs\Foo.txt` {
+		t.Errorf("the file is not spelled as created: %q", f.Name())
+	}
+	f.Close()
+
+	// overwriting keeps the spelling
+	if err := WriteFile(fs, `C:\DOCS\FOO.TXT`, []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(fs, `C:\Docs\Foo.txt`); string(data) != "bar" {
+		t.Errorf("ReadFile: %q", data)
+	}
+	if err := fs.Mkdir(`C:\docs`, 0755); !os.IsExist(err) {
+		t.Errorf("Mkdir of an existing directory spelled differently: %v", err)
+	}
+
+	// renaming changes the spelling
+	if err := fs.Rename(`C:\docs`, `C:\DOCS`); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(`C:\docs`); err != nil || fi.Name() != "DOCS" {
+		t.Errorf("Stat after changing the spelling: %v, %v", fi, err)
+	}
+	if data, err := ReadFile(fs, `C:\docs\foo.txt`); err != nil || string(data) != "bar" {
+		t.Errorf("the contents of the renamed directory: %q, %v", data, err)
+	}
+	names, _ := ReadDir(fs, `C:\`)
+	if len(names) != 1 || names[0].Name() != "DOCS" {
+		t.Errorf("ReadDir: %v", names)
+	}
+	if err := fs.Remove(`C:\docs\FOO.txt`); err != nil {
+		t.Errorf("Remove: %v", err)
+	}
+	if err := fs.Remove(`C:\Docs`); err != nil {
+		t.Errorf("Remove of the emptied directory: %v", err)
+	}
+}
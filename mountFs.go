@@ -0,0 +1,539 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*MountFs)(nil)
+
+// The MountFs presents several filesystems as a single tree, each of them
+// mounted under a path prefix. Every call is routed to the filesystem
+// mounted at the longest prefix of the name, which sees the rest of the name
+// as an absolute path. Mount an OsFs through a BasePathFs to expose a sub
+// tree of the OS filesystem.
+//
+// The directories leading to mount points exist even if no filesystem
+// provides them, they are listed by Readdir and can't be modified. A mount
+// point shadows any entry of the same name in the parent filesystem and
+// can't be removed or renamed.
+//
+// Renaming between two mounts fails with a *os.LinkError wrapping
+// syscall.EXDEV, unless CopyAcrossMounts is set.
+//
+// Filesystems may be mounted and unmounted while the MountFs is in use.
+type MountFs struct {
+	// CopyAcrossMounts makes Rename copy the entries between two mounts
+	// and remove the originals, like mv does. Set it before use.
+	CopyAcrossMounts bool
+
+	mu     sync.RWMutex
+	mounts map[string]Fs
+}
+
+// NewMountFs returns a MountFs with root mounted at the top of the tree.
+// With a nil root, only the directories leading to mount points exist.
+func NewMountFs(root Fs) *MountFs {
+	m := &MountFs{mounts: make(map[string]Fs)}
+	if root != nil {
+		m.mounts[FilePathSeparator] = root
+	}
+	return m
+}
+
+func mountKey(name string) string {
+	return filepath.Clean(FilePathSeparator + name)
+}
+
+// Mount mounts fs at prefix. It fails if another filesystem is already
+// mounted there.
+func (m *MountFs) Mount(prefix string, fs Fs) error {
+	if fs == nil {
+		return &os.PathError{Op: "mount", Path: prefix, Err: ErrInvalid}
+	}
+	key := mountKey(prefix)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[key]; ok {
+		return &os.PathError{Op: "mount", Path: prefix, Err: ErrFileExists}
+	}
+	m.mounts[key] = fs
+	return nil
+}
+
+// Unmount removes the filesystem mounted at prefix.
+func (m *MountFs) Unmount(prefix string) error {
+	key := mountKey(prefix)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[key]; !ok {
+		return &os.PathError{Op: "unmount", Path: prefix, Err: ErrFileNotFound}
+	}
+	delete(m.mounts, key)
+	return nil
+}
+
+// Mounts returns the sorted mount points.
+func (m *MountFs) Mounts() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prefixes := make([]string, 0, len(m.mounts))
+	for p := range m.mounts {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// mountPath is a name resolved against the mount table.
+type mountPath struct {
+	key    string // the clean absolute name
+	fs     Fs     // the filesystem mounted at the longest prefix, or nil
+	prefix string // the prefix fs is mounted at
+	rel    string // the name within fs
+	// children holds the names of the entries of key leading to mount
+	// points below it
+	children map[string]bool
+}
+
+// virtual reports whether p is a mount point or leads to one.
+func (p *mountPath) virtual() bool {
+	return p.fs != nil && p.prefix == p.key || len(p.children) > 0
+}
+
+func (m *MountFs) lookup(name string) *mountPath {
+	p := &mountPath{key: mountKey(name)}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for prefix := p.key; ; prefix = filepath.Dir(prefix) {
+		if fs, ok := m.mounts[prefix]; ok {
+			p.fs, p.prefix = fs, prefix
+			switch {
+			case prefix == p.key:
+				p.rel = FilePathSeparator
+			case prefix == FilePathSeparator:
+				p.rel = p.key
+			default:
+				p.rel = p.key[len(prefix):]
+			}
+			break
+		}
+		if prefix == FilePathSeparator {
+			break
+		}
+	}
+	for prefix := range m.mounts {
+		if prefix == p.key || !HostPaths.inBasePath(p.key, prefix) {
+			continue
+		}
+		rest := strings.TrimLeft(prefix[len(p.key):], FilePathSeparator)
+		if i := strings.Index(rest, FilePathSeparator); i >= 0 {
+			rest = rest[:i]
+		}
+		if p.children == nil {
+			p.children = make(map[string]bool)
+		}
+		// true marks the mount points themselves
+		p.children[rest] = p.children[rest] || prefix == filepath.Join(p.key, rest)
+	}
+	return p
+}
+
+// mountDirInfo is the os.FileInfo of a directory leading to a mount point
+// that is not provided by any filesystem.
+type mountDirInfo struct {
+	name string
+}
+
+func (i mountDirInfo) Name() string       { return i.name }
+func (i mountDirInfo) Size() int64        { return 0 }
+func (i mountDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (i mountDirInfo) ModTime() time.Time { return time.Time{} }
+func (i mountDirInfo) IsDir() bool        { return true }
+func (i mountDirInfo) Sys() interface{}   { return nil }
+
+// renamedFileInfo is the os.FileInfo of the root of a mounted filesystem,
+// named after the mount point.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i renamedFileInfo) Name() string { return i.name }
+
+func (m *MountFs) stat(op, name string, lstat bool) (os.FileInfo, bool, error) {
+	p := m.lookup(name)
+	var (
+		fi      os.FileInfo
+		lstated bool
+		err     error = ErrFileNotFound
+	)
+	if p.fs != nil {
+		if lstat {
+			if lsf, ok := p.fs.(Lstater); ok {
+				fi, lstated, err = lsf.LstatIfPossible(p.rel)
+			} else {
+				fi, err = p.fs.Stat(p.rel)
+			}
+		} else {
+			fi, err = p.fs.Stat(p.rel)
+		}
+	}
+	if len(p.children) > 0 && (err != nil || !fi.IsDir()) {
+		return mountDirInfo{name: filepath.Base(p.key)}, false, nil
+	}
+	if err != nil {
+		if p.fs == nil {
+			err = &os.PathError{Op: op, Path: name, Err: err}
+		}
+		return nil, false, err
+	}
+	if p.prefix == p.key {
+		fi = renamedFileInfo{FileInfo: fi, name: filepath.Base(p.key)}
+	}
+	return fi, lstated, nil
+}
+
+func (m *MountFs) Name() string {
+	return "MountFs"
+}
+
+// Capabilities returns the features all the mounted filesystems have. It is
+// read only only if they all are.
+func (m *MountFs) Capabilities() Features {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var f Features
+	first, readOnly := true, true
+	for _, fs := range m.mounts {
+		mf := Capabilities(fs)
+		readOnly = readOnly && mf.ReadOnly
+		if first {
+			f, first = mf, false
+		} else {
+			f = f.intersect(mf)
+		}
+	}
+	f.ReadOnly = readOnly && !first
+	return f
+}
+
+// Unwrap returns the mounted filesystems, sorted by mount point.
+func (m *MountFs) Unwrap() []Fs {
+	prefixes := m.Mounts()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var fss []Fs
+	for _, prefix := range prefixes {
+		if fs, ok := m.mounts[prefix]; ok {
+			fss = append(fss, fs)
+		}
+	}
+	return fss
+}
+
+func (m *MountFs) mapPath(name string) (Fs, string, bool) {
+	p := m.lookup(name)
+	return p.fs, p.rel, p.fs != nil
+}
+
+func (m *MountFs) Stat(name string) (os.FileInfo, error) {
+	fi, _, err := m.stat("stat", name, false)
+	return fi, err
+}
+
+func (m *MountFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return m.stat("lstat", name, true)
+}
+
+func (m *MountFs) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (m *MountFs) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *MountFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	p := m.lookup(name)
+	if len(p.children) == 0 {
+		if p.fs == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileNotFound}
+		}
+		f, err := p.fs.OpenFile(p.rel, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return &MountFile{File: f, name: name}, nil
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	d := &mountDir{fs: m, name: name, children: p.children}
+	if p.fs != nil {
+		f, err := p.fs.OpenFile(p.rel, flag, perm)
+		if err == nil {
+			if fi, serr := f.Stat(); serr == nil && fi.IsDir() {
+				d.dir = f
+			} else {
+				f.Close()
+			}
+		}
+	}
+	return d, nil
+}
+
+func (m *MountFs) Mkdir(name string, perm os.FileMode) error {
+	p := m.lookup(name)
+	if p.virtual() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
+	}
+	if p.fs == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileNotFound}
+	}
+	return p.fs.Mkdir(p.rel, perm)
+}
+
+func (m *MountFs) MkdirAll(path string, perm os.FileMode) error {
+	p := m.lookup(path)
+	if len(p.children) > 0 {
+		return nil
+	}
+	if p.fs == nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: ErrFileNotFound}
+	}
+	return p.fs.MkdirAll(p.rel, perm)
+}
+
+func (m *MountFs) Remove(name string) error {
+	p := m.lookup(name)
+	if p.virtual() {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	if p.fs == nil {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrFileNotFound}
+	}
+	return p.fs.Remove(p.rel)
+}
+
+func (m *MountFs) RemoveAll(path string) error {
+	p := m.lookup(path)
+	if p.virtual() {
+		return &os.PathError{Op: "removeall", Path: path, Err: syscall.EBUSY}
+	}
+	if p.fs == nil {
+		return nil
+	}
+	return p.fs.RemoveAll(p.rel)
+}
+
+func (m *MountFs) Rename(oldname, newname string) error {
+	op, np := m.lookup(oldname), m.lookup(newname)
+	if op.virtual() || np.virtual() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EBUSY}
+	}
+	if op.fs == nil || np.fs == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrFileNotFound}
+	}
+	if op.prefix == np.prefix {
+		return op.fs.Rename(op.rel, np.rel)
+	}
+	if !m.CopyAcrossMounts {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	if _, err := lstatIfPossible(op.fs, op.rel); err != nil {
+		return err
+	}
+	if err := LoadFrom(op.fs, op.rel, np.fs, np.rel, nil); err != nil {
+		return err
+	}
+	return op.fs.RemoveAll(op.rel)
+}
+
+func (m *MountFs) Chmod(name string, mode os.FileMode) error {
+	p := m.lookup(name)
+	if p.fs == nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: m.missingErr(p)}
+	}
+	return p.fs.Chmod(p.rel, mode)
+}
+
+func (m *MountFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	p := m.lookup(name)
+	if p.fs == nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: m.missingErr(p)}
+	}
+	return p.fs.Chtimes(p.rel, atime, mtime)
+}
+
+// missingErr is the error for a path no filesystem is mounted for.
+func (m *MountFs) missingErr(p *mountPath) error {
+	if len(p.children) > 0 {
+		return syscall.EPERM
+	}
+	return ErrFileNotFound
+}
+
+func (m *MountFs) SymlinkIfPossible(oldname, newname string) error {
+	p := m.lookup(newname)
+	if p.virtual() {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	if p.fs == nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileNotFound}
+	}
+	if linker, ok := p.fs.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, p.rel)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (m *MountFs) ReadlinkIfPossible(name string) (string, error) {
+	p := m.lookup(name)
+	if p.fs == nil || p.virtual() {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrInvalid}
+	}
+	if reader, ok := p.fs.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(p.rel)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// MountFile is a File opened through a MountFs, named as it was opened.
+type MountFile struct {
+	File
+	name string
+}
+
+func (f *MountFile) Name() string {
+	return f.name
+}
+
+// mountDir is a directory of a MountFs containing mount points. Its listing
+// is the one of the directory of the underlying filesystem, if any, with the
+// entries leading to mount points added or replaced.
+type mountDir struct {
+	fs       *MountFs
+	name     string
+	dir      File
+	children map[string]bool
+	entries  []os.FileInfo
+	listed   bool
+	closed   bool
+}
+
+func (d *mountDir) list() error {
+	if d.listed {
+		return nil
+	}
+	byName := make(map[string]os.FileInfo)
+	if d.dir != nil {
+		fis, err := d.dir.Readdir(-1)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			byName[fi.Name()] = fi
+		}
+	}
+	for child, mountPoint := range d.children {
+		if fi, ok := byName[child]; ok && fi.IsDir() && !mountPoint {
+			continue
+		}
+		fi, err := d.fs.Stat(filepath.Join(d.name, child))
+		if err != nil {
+			fi = mountDirInfo{name: child}
+		}
+		byName[child] = fi
+	}
+	for _, fi := range byName {
+		d.entries = append(d.entries, fi)
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	d.listed = true
+	return nil
+}
+
+func (d *mountDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.closed {
+		return nil, &os.PathError{Op: "readdir", Path: d.name, Err: ErrFileClosed}
+	}
+	if err := d.list(); err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		fis := d.entries
+		d.entries = nil
+		return fis, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	fis := d.entries[:count]
+	d.entries = d.entries[count:]
+	return fis, nil
+}
+
+func (d *mountDir) Readdirnames(n int) ([]string, error) {
+	fis, err := d.Readdir(n)
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+func (d *mountDir) Close() error {
+	if d.closed {
+		return &os.PathError{Op: "close", Path: d.name, Err: ErrFileClosed}
+	}
+	d.closed = true
+	if d.dir != nil {
+		return d.dir.Close()
+	}
+	return nil
+}
+
+func (d *mountDir) Name() string {
+	return d.name
+}
+
+func (d *mountDir) Stat() (os.FileInfo, error) {
+	return d.fs.Stat(d.name)
+}
+
+func (d *mountDir) Sync() error {
+	return nil
+}
+
+func (d *mountDir) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		d.entries, d.listed = nil, false
+		if d.dir != nil {
+			return d.dir.Seek(offset, whence)
+		}
+		return 0, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) isDir(op string) error {
+	return &os.PathError{Op: op, Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) Read(p []byte) (int, error)                { return 0, d.isDir("read") }
+func (d *mountDir) ReadAt(p []byte, off int64) (int, error)   { return 0, d.isDir("read") }
+func (d *mountDir) Write(p []byte) (int, error)               { return 0, d.isDir("write") }
+func (d *mountDir) WriteAt(p []byte, off int64) (int, error)  { return 0, d.isDir("write") }
+func (d *mountDir) WriteString(s string) (ret int, err error) { return 0, d.isDir("write") }
+func (d *mountDir) Truncate(size int64) error                 { return d.isDir("truncate") }
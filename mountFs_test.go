@@ -0,0 +1,190 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func newTestMountFs(t *testing.T) (*MountFs, Fs, Fs, Fs) {
+	root, data, tmp := NewMemMapFs(), NewMemMapFs(), NewMemMapFs()
+	root.MkdirAll("/etc", 0755)
+	WriteFile(root, "/etc/hosts", []byte("localhost"), 0644)
+	WriteFile(root, "/data", []byte("shadowed"), 0644)
+	WriteFile(data, "/a.txt", []byte("data a"), 0644)
+	WriteFile(tmp, "/t.txt", []byte("tmp t"), 0644)
+
+	m := NewMountFs(root)
+	if err := m.Mount("/data", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mount("/var/lib/tmp", tmp); err != nil {
+		t.Fatal(err)
+	}
+	return m, root, data, tmp
+}
+
+func TestMountFsRouting(t *testing.T) {
+	m, _, data, _ := newTestMountFs(t)
+
+	for name, want := range map[string]string{
+		"/etc/hosts":         "localhost",
+		"/data/a.txt":        "data a",
+		"/var/lib/tmp/t.txt": "tmp t",
+	} {
+		got, err := ReadFile(m, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+
+	if err := WriteFile(m, "/data/b.txt", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := data.Stat("/b.txt"); err != nil {
+		t.Error("file not written to the mounted filesystem:", err)
+	}
+	f, err := m.Open("/data/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != "/data/b.txt" {
+		t.Errorf("file name %q", f.Name())
+	}
+	f.Close()
+}
+
+func TestMountFsSyntheticDirs(t *testing.T) {
+	m, _, _, _ := newTestMountFs(t)
+
+	names, err := ReadDir(m, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range names {
+		got = append(got, fi.Name())
+		if fi.Name() == "data" && !fi.IsDir() {
+			t.Error("mount point /data should shadow the file of the root filesystem")
+		}
+	}
+	if want := []string{"data", "etc", "var"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("root listing %v, want %v", got, want)
+	}
+
+	fi, err := m.Stat("/var/lib")
+	if err != nil || !fi.IsDir() || fi.Name() != "lib" {
+		t.Errorf("synthetic directory: %v, %v", fi, err)
+	}
+	f, err := m.Open("/var/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil || !reflect.DeepEqual(sub, []string{"tmp"}) {
+		t.Errorf("listing of /var/lib: %v, %v", sub, err)
+	}
+
+	if err := m.Remove("/data"); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("removing a mount point: %v", err)
+	}
+	if err := m.RemoveAll("/var"); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("removing a directory containing a mount point: %v", err)
+	}
+	if err := m.Mkdir("/var/lib", 0755); !os.IsExist(err) {
+		t.Errorf("mkdir on a synthetic directory: %v", err)
+	}
+	if _, err := m.Create("/var"); err == nil {
+		t.Error("created a file over a synthetic directory")
+	}
+
+	var visited []string
+	Walk(m, "/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path)
+		return nil
+	})
+	want := []string{"/", "/data", "/data/a.txt", "/etc", "/etc/hosts", "/var", "/var/lib", "/var/lib/tmp", "/var/lib/tmp/t.txt"}
+	for i := range want {
+		want[i] = filepath.FromSlash(want[i])
+	}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("walked %v, want %v", visited, want)
+	}
+}
+
+func TestMountFsRename(t *testing.T) {
+	m, root, data, _ := newTestMountFs(t)
+
+	if err := m.Rename("/data/a.txt", "/data/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Rename("/data/c.txt", "/etc/c.txt")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Fatalf("rename across mounts: %v", err)
+	}
+	if _, ok := err.(*os.LinkError); !ok {
+		t.Errorf("expected a *os.LinkError, got %T", err)
+	}
+
+	m.CopyAcrossMounts = true
+	if err := m.Rename("/data/c.txt", "/etc/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(root, "/etc/c.txt"); err != nil || string(got) != "data a" {
+		t.Errorf("copied file: %q, %v", got, err)
+	}
+	if _, err := data.Stat("/c.txt"); !os.IsNotExist(err) {
+		t.Error("source of a cross mount rename was not removed")
+	}
+}
+
+func TestMountFsUnmount(t *testing.T) {
+	m, _, _, _ := newTestMountFs(t)
+
+	if err := m.Mount("/data", NewMemMapFs()); !os.IsExist(err) {
+		t.Errorf("mounting twice: %v", err)
+	}
+	if err := m.Unmount("/var/lib/tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/var"); !os.IsNotExist(err) {
+		t.Errorf("synthetic directory left after unmount: %v", err)
+	}
+	if err := m.Unmount("/var/lib/tmp"); !os.IsNotExist(err) {
+		t.Errorf("unmounting twice: %v", err)
+	}
+	if got := m.Mounts(); !reflect.DeepEqual(got, []string{FilePathSeparator, filepath.FromSlash("/data")}) {
+		t.Errorf("mounts %v", got)
+	}
+}
+
+func TestMountFsConcurrentMounts(t *testing.T) {
+	m := NewMountFs(NewMemMapFs())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Mount("/mnt", NewMemMapFs())
+				m.Unmount("/mnt")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Stat("/mnt")
+				ReadDir(m, "/")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -16,6 +16,7 @@ package afero
 
 import (
 	"os"
+	"runtime"
 	"time"
 )
 
@@ -33,6 +34,21 @@ func NewOsFs() Fs {
 
 func (OsFs) Name() string { return "OsFs" }
 
+// Capabilities returns the features of the usual filesystems of the
+// operating system: Windows only has a read-only attribute, Windows and
+// macOS are case insensitive.
+func (OsFs) Capabilities() Features {
+	return Features{
+		Lstat:           true,
+		Symlinks:        true,
+		Chmod:           runtime.GOOS != "windows",
+		Chtimes:         true,
+		AtomicRename:    true,
+		CaseInsensitive: runtime.GOOS == "windows" || runtime.GOOS == "darwin",
+		MaxNameLength:   255,
+	}
+}
+
 func (OsFs) Create(name string) (File, error) {
 	f, e := os.Create(name)
 	if f == nil {
@@ -0,0 +1,238 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PathStyle selects the rules followed by the names of the files of a
+// filesystem, see MemMapFsOptions. The path style of any filesystem is
+// reported by Capabilities.
+type PathStyle int
+
+const (
+	// HostPaths are the paths of the operating system the program runs
+	// on, as handled by path/filepath.
+	HostPaths PathStyle = iota
+
+	// WindowsPaths are the paths of Windows, whatever the operating system
+	// the program runs on: both \ and / separate the names, which are
+	// written with \, and the volume is either a drive letter, like C:, or
+	// a UNC share, like \\server\share. The names differing only in case
+	// are the same file. The trailing dots and spaces of the names are
+	// dropped, so a.txt. is a.txt. The names containing one of
+	// <>:"|?* or a control character, and the names of devices like CON,
+	// NUL or COM1, with or without an extension, can't be created and fail
+	// with ErrInvalidName.
+	WindowsPaths
+)
+
+// ErrInvalidName is returned when creating a file whose name is not allowed
+// by the path style of the filesystem, like CON with WindowsPaths.
+var ErrInvalidName = errors.New("invalid file name")
+
+func (s PathStyle) String() string {
+	switch s {
+	case HostPaths:
+		return "HostPaths"
+	case WindowsPaths:
+		return "WindowsPaths"
+	}
+	return "PathStyle(" + strconv.Itoa(int(s)) + ")"
+}
+
+// pathStyleOf returns the path style of fs.
+func pathStyleOf(fs Fs) PathStyle {
+	return Capabilities(fs).PathStyle
+}
+
+func (s PathStyle) separator() string {
+	if s == WindowsPaths {
+		return `\`
+	}
+	return FilePathSeparator
+}
+
+func (s PathStyle) isSeparator(c byte) bool {
+	if s == WindowsPaths {
+		return c == '\\' || c == '/'
+	}
+	return os.IsPathSeparator(c)
+}
+
+// volumeName is filepath.VolumeName.
+func (s PathStyle) volumeName(path string) string {
+	if s != WindowsPaths {
+		return filepath.VolumeName(path)
+	}
+	if len(path) >= 2 && path[1] == ':' && isDriveLetter(path[0]) {
+		return path[:2]
+	}
+	// \\server\share
+	if len(path) < 5 || !s.isSeparator(path[0]) || !s.isSeparator(path[1]) || s.isSeparator(path[2]) || path[2] == '.' {
+		return ""
+	}
+	n := 3
+	for n < len(path)-1 && !s.isSeparator(path[n]) {
+		n++
+	}
+	n++
+	if n >= len(path) || s.isSeparator(path[n]) {
+		return ""
+	}
+	for n < len(path) && !s.isSeparator(path[n]) {
+		n++
+	}
+	return path[:n]
+}
+
+func isDriveLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// clean is filepath.Clean. With WindowsPaths, it also writes the drive
+// letter in upper case, drops the trailing dots and spaces of the names,
+// and roots the paths on a UNC share, which are always absolute.
+func (s PathStyle) clean(p string) string {
+	if s != WindowsPaths {
+		return filepath.Clean(p)
+	}
+	vol := s.volumeName(p)
+	rest := strings.Replace(p[len(vol):], `\`, "/", -1)
+	if len(vol) == 2 {
+		vol = strings.ToUpper(vol)
+	} else if vol != "" {
+		vol = strings.Replace(vol, "/", `\`, -1)
+		rest = "/" + rest
+	}
+	names := strings.Split(rest, "/")
+	for i, name := range names {
+		if name != "." && name != ".." {
+			names[i] = strings.TrimRight(name, ". ")
+		}
+	}
+	rest = path.Clean(strings.Join(names, "/"))
+	return vol + strings.Replace(rest, "/", `\`, -1)
+}
+
+// join is filepath.Join.
+func (s PathStyle) join(elem ...string) string {
+	if s != WindowsPaths {
+		return filepath.Join(elem...)
+	}
+	for i, e := range elem {
+		if e != "" {
+			return s.clean(strings.Join(elem[i:], `\`))
+		}
+	}
+	return ""
+}
+
+// split is filepath.Split.
+func (s PathStyle) split(path string) (dir, file string) {
+	if s != WindowsPaths {
+		return filepath.Split(path)
+	}
+	vol := s.volumeName(path)
+	i := len(path) - 1
+	for i >= len(vol) && !s.isSeparator(path[i]) {
+		i--
+	}
+	return path[:i+1], path[i+1:]
+}
+
+// dir is filepath.Dir.
+func (s PathStyle) dir(path string) string {
+	if s != WindowsPaths {
+		return filepath.Dir(path)
+	}
+	dir, _ := s.split(path)
+	if dir == "" {
+		return "."
+	}
+	return s.clean(dir)
+}
+
+// splitNames returns the non-empty names of path.
+func (s PathStyle) splitNames(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r < utf8.RuneSelf && s.isSeparator(byte(r))
+	})
+}
+
+// isAbs is filepath.IsAbs.
+func (s PathStyle) isAbs(path string) bool {
+	if s != WindowsPaths {
+		return filepath.IsAbs(path)
+	}
+	vol := s.volumeName(path)
+	if len(vol) != 2 {
+		// the paths on a UNC share are always absolute
+		return vol != ""
+	}
+	return len(path) > 2 && s.isSeparator(path[2])
+}
+
+// rooted returns the clean absolute path of name in a MemMapFs, where the
+// relative names are relative to the root. With WindowsPaths the root is
+// that of C: for the names without a volume.
+func (s PathStyle) rooted(name string) string {
+	if s != WindowsPaths {
+		return normalizePath(name)
+	}
+	name = s.clean(name)
+	vol := s.volumeName(name)
+	if vol == "" {
+		vol = "C:"
+	}
+	return s.clean(vol + `\` + name[len(s.volumeName(name)):])
+}
+
+// root returns the root of the volume of the path returned by rooted.
+func (s PathStyle) root(path string) string {
+	if s != WindowsPaths {
+		return FilePathSeparator
+	}
+	return s.volumeName(path) + `\`
+}
+
+// validate returns ErrInvalidName if a file named path can't be created.
+func (s PathStyle) validate(path string) error {
+	if s != WindowsPaths {
+		return nil
+	}
+	rest := path[len(s.volumeName(path)):]
+	for _, name := range s.splitNames(rest) {
+		if isReservedWindowsName(name) {
+			return ErrInvalidName
+		}
+		for i := 0; i < len(name); i++ {
+			if name[i] < ' ' || strings.IndexByte(`<>:"|?*`, name[i]) >= 0 {
+				return ErrInvalidName
+			}
+		}
+	}
+	return nil
+}
+
+// isReservedWindowsName reports whether name refers to a device on Windows,
+// like CON, nul.txt or COM1.
+func isReservedWindowsName(name string) bool {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.ToUpper(strings.TrimRight(name, " "))
+	switch name {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(name) == 4 && (name[:3] == "COM" || name[:3] == "LPT") {
+		return '1' <= name[3] && name[3] <= '9'
+	}
+	return false
+}
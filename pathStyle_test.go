@@ -0,0 +1,131 @@
+package afero
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWindowsPathsClean(t *testing.T) {
+	for _, test := range []struct{ path, clean, dir string }{
+		{`c:\a\b`, `C:\a\b`, `C:\a`},
+		{`C:/a//b/`, `C:\a\b`, `C:\a\b`},
+		{`C:\`, `C:\`, `C:\`},
+		{`C:\..\a`, `C:\a`, `C:\`},
+		{`C:a\b`, `C:a\b`, `C:a`},
+		{`\a\.\b`, `\a\b`, `\a`},
+		{`a\..\..\b`, `..\b`, `..`},
+		{`a.txt.`, `a.txt`, `.`},
+		{`\dir. \file .`, `\dir\file`, `\dir`},
+		{`\\server\share`, `\\server\share\`, `\\server\share\`},
+		{`//server/share/a/../b`, `\\server\share\b`, `\\server\share\`},
+	} {
+		if got := WindowsPaths.clean(test.path); got != test.clean {
+			t.Errorf("clean(%q) = %q, want %q", test.path, got, test.clean)
+		}
+		if got := WindowsPaths.dir(test.path); got != test.dir {
+			t.Errorf("dir(%q) = %q, want %q", test.path, got, test.dir)
+		}
+	}
+}
+
+func TestWindowsPathsRooted(t *testing.T) {
+	for _, test := range []struct{ name, rooted string }{
+		{``, `C:\`},
+		{`..`, `C:\`},
+		{`tmp`, `C:\tmp`},
+		{`/tmp/a`, `C:\tmp\a`},
+		{`d:tmp`, `D:\tmp`},
+		{`D:\tmp\`, `D:\tmp`},
+		{`\\server\share\tmp`, `\\server\share\tmp`},
+	} {
+		if got := WindowsPaths.rooted(test.name); got != test.rooted {
+			t.Errorf("rooted(%q) = %q, want %q", test.name, got, test.rooted)
+		}
+	}
+}
+
+func TestWindowsPathsIsAbs(t *testing.T) {
+	for path, abs := range map[string]bool{
+		`C:\a`:            true,
+		`C:/a`:            true,
+		`C:a`:             false,
+		`\a`:              false,
+		`a`:               false,
+		`\\server\share`:  true,
+		`\\server\share\`: true,
+	} {
+		if WindowsPaths.isAbs(path) != abs {
+			t.Errorf("isAbs(%q) = %v", path, !abs)
+		}
+	}
+}
+
+func TestWindowsPathsValidate(t *testing.T) {
+	for path, valid := range map[string]bool{
+		`C:\dir\file.txt`:  true,
+		`C:\CONSOLE`:       true,
+		`C:\COM0`:          true,
+		`C:\con`:           false,
+		`C:\dir\NUL.txt`:   false,
+		`C:\Aux \file`:     false,
+		`C:\LPT9`:          false,
+		`C:\a:b`:           false,
+		`C:\a?`:            false,
+		`C:\a<b>`:          false,
+		"C:\\a\x01":        false,
+		`\\server\share\a`: true,
+	} {
+		err := WindowsPaths.validate(path)
+		if (err == nil) != valid {
+			t.Errorf("validate(%q) = %v", path, err)
+		}
+	}
+	if err := HostPaths.validate("CON"); err != nil {
+		t.Errorf("HostPaths.validate(CON) = %v", err)
+	}
+}
+
+func TestPathHelpersWindowsPaths(t *testing.T) {
+	fs := NewMemMapFsWithOptions(MemMapFsOptions{PathStyle: WindowsPaths})
+	if err := WriteReader(fs, `C:/src/sub/a.go`, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, `C:\src\b.go`, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var walked []string
+	err := Walk(fs, `C:\src`, func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`C:\src`, `C:\src\b.go`, `C:\src\sub`, `C:\src\sub\a.go`}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("Walk: got %q, want %q", walked, want)
+	}
+
+	matches, err := Glob(fs, `C:\src\*\*.go`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`C:\src\sub\a.go`}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("Glob: got %q, want %q", matches, want)
+	}
+
+	name, err := TempDir(fs, `C:\tmp`, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := WindowsPaths.split(name); dir != `C:\tmp\` {
+		t.Errorf("TempDir: %q is not in C:\\tmp", name)
+	}
+
+	if style := Capabilities(NewReadOnlyFs(fs)).PathStyle; style != WindowsPaths {
+		t.Errorf("Capabilities: %v", style)
+	}
+}
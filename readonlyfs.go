@@ -21,17 +21,32 @@ func (r *ReadOnlyFs) ReadDir(name string) ([]os.FileInfo, error) {
 }
 
 func (r *ReadOnlyFs) Chtimes(n string, a, m time.Time) error {
-	return syscall.EPERM
+	return &os.PathError{Op: "chtimes", Path: n, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) Chmod(n string, m os.FileMode) error {
-	return syscall.EPERM
+	return &os.PathError{Op: "chmod", Path: n, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) Name() string {
 	return "ReadOnlyFilter"
 }
 
+func (r *ReadOnlyFs) Capabilities() Features {
+	f := wrapperFeatures(r, r.source)
+	f.Chmod, f.Chtimes, f.AtomicRename = false, false, false
+	f.ReadOnly = true
+	return f
+}
+
+func (r *ReadOnlyFs) Unwrap() []Fs {
+	return []Fs{r.source}
+}
+
+func (r *ReadOnlyFs) mapPath(name string) (Fs, string, bool) {
+	return r.source, name, true
+}
+
 func (r *ReadOnlyFs) Stat(name string) (os.FileInfo, error) {
 	return r.source.Stat(name)
 }
@@ -57,20 +72,20 @@ func (r *ReadOnlyFs) ReadlinkIfPossible(name string) (string, error) {
 }
 
 func (r *ReadOnlyFs) Rename(o, n string) error {
-	return syscall.EPERM
+	return &os.LinkError{Op: "rename", Old: o, New: n, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) RemoveAll(p string) error {
-	return syscall.EPERM
+	return &os.PathError{Op: "removeall", Path: p, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) Remove(n string) error {
-	return syscall.EPERM
+	return &os.PathError{Op: "remove", Path: n, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
 	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
-		return nil, syscall.EPERM
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
 	}
 	return r.source.OpenFile(name, flag, perm)
 }
@@ -80,13 +95,13 @@ func (r *ReadOnlyFs) Open(n string) (File, error) {
 }
 
 func (r *ReadOnlyFs) Mkdir(n string, p os.FileMode) error {
-	return syscall.EPERM
+	return &os.PathError{Op: "mkdir", Path: n, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) MkdirAll(n string, p os.FileMode) error {
-	return syscall.EPERM
+	return &os.PathError{Op: "mkdir", Path: n, Err: syscall.EPERM}
 }
 
 func (r *ReadOnlyFs) Create(n string) (File, error) {
-	return nil, syscall.EPERM
+	return nil, &os.PathError{Op: "open", Path: n, Err: syscall.EPERM}
 }
@@ -2,216 +2,111 @@ package afero
 
 import (
 	"os"
+	"path/filepath"
 	"regexp"
-	"syscall"
+	"sync"
 	"time"
 )
 
 // The RegexpFs filters files (not directories) by regular expression. Only
-// files matching the given regexp will be allowed, all others get a ENOENT error (
-// "No such file or directory").
+// files whose name, as given to the operations, matches the given regexp will
+// be allowed, all others get a ENOENT error ("No such file or directory").
+// Readdir matches the base names of the entries it lists.
 //
+// It is a FilterFs, see NewPredicateFs.
 type RegexpFs struct {
 	re     *regexp.Regexp
 	source Fs
+	once   sync.Once
+	fs     *FilterFs
 }
 
 func NewRegexpFs(source Fs, re *regexp.Regexp) Fs {
 	return &RegexpFs{source: source, re: re}
 }
 
-type RegexpFile struct {
-	f  File
-	re *regexp.Regexp
-}
+// RegexpFile is the File returned by a RegexpFs.
+//
+// Deprecated: RegexpFs returns a *FilterFile.
+type RegexpFile = FilterFile
 
-func (r *RegexpFs) matchesName(name string) error {
-	if r.re == nil {
-		return nil
+func (r *RegexpFs) hidden(name string, fi os.FileInfo) bool {
+	if r.re == nil || fi != nil && fi.IsDir() {
+		return false
 	}
-	if r.re.MatchString(name) {
-		return nil
-	}
-	return syscall.ENOENT
+	return !r.re.MatchString(name)
 }
 
-func (r *RegexpFs) dirOrMatches(name string) error {
-	dir, err := IsDir(r.source, name)
-	if err != nil {
-		return err
-	}
-	if dir {
-		return nil
-	}
-	return r.matchesName(name)
+// hiddenEntry is hidden for the entries listed by Readdir.
+func (r *RegexpFs) hiddenEntry(name string, fi os.FileInfo) bool {
+	return r.hidden(filepath.Base(name), fi)
+}
+
+// filter returns the FilterFs doing the work, built on first use since a
+// RegexpFs may be created without NewRegexpFs.
+func (r *RegexpFs) filter() *FilterFs {
+	r.once.Do(func() {
+		r.fs = &FilterFs{source: r.source, hidden: r.hidden, hiddenEntry: r.hiddenEntry}
+	})
+	return r.fs
 }
 
 func (r *RegexpFs) Chtimes(name string, a, m time.Time) error {
-	if err := r.dirOrMatches(name); err != nil {
-		return err
-	}
-	return r.source.Chtimes(name, a, m)
+	return r.filter().Chtimes(name, a, m)
 }
 
 func (r *RegexpFs) Chmod(name string, mode os.FileMode) error {
-	if err := r.dirOrMatches(name); err != nil {
-		return err
-	}
-	return r.source.Chmod(name, mode)
+	return r.filter().Chmod(name, mode)
 }
 
 func (r *RegexpFs) Name() string {
 	return "RegexpFs"
 }
 
+func (r *RegexpFs) Capabilities() Features {
+	return wrapperFeatures(r, r.source)
+}
+
+func (r *RegexpFs) Unwrap() []Fs {
+	return []Fs{r.source}
+}
+
+func (r *RegexpFs) mapPath(name string) (Fs, string, bool) {
+	return r.source, name, true
+}
+
 func (r *RegexpFs) Stat(name string) (os.FileInfo, error) {
-	if err := r.dirOrMatches(name); err != nil {
-		return nil, err
-	}
-	return r.source.Stat(name)
+	return r.filter().Stat(name)
 }
 
 func (r *RegexpFs) Rename(oldname, newname string) error {
-	dir, err := IsDir(r.source, oldname)
-	if err != nil {
-		return err
-	}
-	if dir {
-		return nil
-	}
-	if err := r.matchesName(oldname); err != nil {
-		return err
-	}
-	if err := r.matchesName(newname); err != nil {
-		return err
-	}
-	return r.source.Rename(oldname, newname)
+	return r.filter().Rename(oldname, newname)
 }
 
 func (r *RegexpFs) RemoveAll(p string) error {
-	dir, err := IsDir(r.source, p)
-	if err != nil {
-		return err
-	}
-	if !dir {
-		if err := r.matchesName(p); err != nil {
-			return err
-		}
-	}
-	return r.source.RemoveAll(p)
+	return r.filter().RemoveAll(p)
 }
 
 func (r *RegexpFs) Remove(name string) error {
-	if err := r.dirOrMatches(name); err != nil {
-		return err
-	}
-	return r.source.Remove(name)
+	return r.filter().Remove(name)
 }
 
 func (r *RegexpFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	if err := r.dirOrMatches(name); err != nil {
-		return nil, err
-	}
-	return r.source.OpenFile(name, flag, perm)
+	return r.filter().OpenFile(name, flag, perm)
 }
 
 func (r *RegexpFs) Open(name string) (File, error) {
-	dir, err := IsDir(r.source, name)
-	if err != nil {
-		return nil, err
-	}
-	if !dir {
-		if err := r.matchesName(name); err != nil {
-			return nil, err
-		}
-	}
-	f, err := r.source.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	return &RegexpFile{f: f, re: r.re}, nil
+	return r.filter().Open(name)
 }
 
 func (r *RegexpFs) Mkdir(n string, p os.FileMode) error {
-	return r.source.Mkdir(n, p)
+	return r.filter().Mkdir(n, p)
 }
 
 func (r *RegexpFs) MkdirAll(n string, p os.FileMode) error {
-	return r.source.MkdirAll(n, p)
+	return r.filter().MkdirAll(n, p)
 }
 
 func (r *RegexpFs) Create(name string) (File, error) {
-	if err := r.matchesName(name); err != nil {
-		return nil, err
-	}
-	return r.source.Create(name)
-}
-
-func (f *RegexpFile) Close() error {
-	return f.f.Close()
-}
-
-func (f *RegexpFile) Read(s []byte) (int, error) {
-	return f.f.Read(s)
-}
-
-func (f *RegexpFile) ReadAt(s []byte, o int64) (int, error) {
-	return f.f.ReadAt(s, o)
-}
-
-func (f *RegexpFile) Seek(o int64, w int) (int64, error) {
-	return f.f.Seek(o, w)
-}
-
-func (f *RegexpFile) Write(s []byte) (int, error) {
-	return f.f.Write(s)
-}
-
-func (f *RegexpFile) WriteAt(s []byte, o int64) (int, error) {
-	return f.f.WriteAt(s, o)
-}
-
-func (f *RegexpFile) Name() string {
-	return f.f.Name()
-}
-
-func (f *RegexpFile) Readdir(c int) (fi []os.FileInfo, err error) {
-	var rfi []os.FileInfo
-	rfi, err = f.f.Readdir(c)
-	if err != nil {
-		return nil, err
-	}
-	for _, i := range rfi {
-		if i.IsDir() || f.re.MatchString(i.Name()) {
-			fi = append(fi, i)
-		}
-	}
-	return fi, nil
-}
-
-func (f *RegexpFile) Readdirnames(c int) (n []string, err error) {
-	fi, err := f.Readdir(c)
-	if err != nil {
-		return nil, err
-	}
-	for _, s := range fi {
-		n = append(n, s.Name())
-	}
-	return n, nil
-}
-
-func (f *RegexpFile) Stat() (os.FileInfo, error) {
-	return f.f.Stat()
-}
-
-func (f *RegexpFile) Sync() error {
-	return f.f.Sync()
-}
-
-func (f *RegexpFile) Truncate(s int64) error {
-	return f.f.Truncate(s)
-}
-
-func (f *RegexpFile) WriteString(s string) (int, error) {
-	return f.f.WriteString(s)
+	return r.filter().Create(name)
 }
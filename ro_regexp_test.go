@@ -94,3 +94,24 @@ func TestFilterRegexReadDir(t *testing.T) {
 		t.Errorf("Got wrong number of names: %v", names)
 	}
 }
+
+func TestFilterRegexpDirs(t *testing.T) {
+	mfs := &MemMapFs{}
+	mfs.MkdirAll("/dir/sub", 0777)
+	WriteFile(mfs, "/dir/sub/afile.txt", []byte("a"), 0644)
+	fs := NewRegexpFs(mfs, regexp.MustCompile(`^a`))
+
+	// the full name is matched, the base name only by Readdir
+	if _, err := fs.Open("/dir/sub/afile.txt"); err == nil {
+		t.Errorf("opened a file whose full name doesn't match")
+	}
+	if names, _ := ReadDir(fs, "/dir/sub"); len(names) != 1 {
+		t.Errorf("Readdir doesn't match the base names: %v", names)
+	}
+	if err := fs.Rename("/dir/sub", "/dir/other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/dir/other/afile.txt"); err != nil {
+		t.Errorf("directory was not renamed: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright © 2015 Jerry Jacobs <jerry.jacobs@xor-gate.org>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sftpfs
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/sftp"
+)
+
+// statusErrnos maps the SFTP status codes to the errors the os package
+// returns for the same conditions, so that errors.Is and the os.IsXxx
+// helpers work on the errors of the Fs. Codes without an equivalent, like
+// the catch-all SSH_FX_FAILURE, are kept as *sftp.StatusError.
+var statusErrnos = map[uint32]error{
+	2:  syscall.ENOENT,    // SSH_FX_NO_SUCH_FILE
+	3:  syscall.EACCES,    // SSH_FX_PERMISSION_DENIED
+	8:  syscall.ENOTSUP,   // SSH_FX_OP_UNSUPPORTED
+	10: syscall.ENOENT,    // SSH_FX_NO_SUCH_PATH
+	11: syscall.EEXIST,    // SSH_FX_FILE_ALREADY_EXISTS
+	12: syscall.EROFS,     // SSH_FX_WRITE_PROTECT
+	14: syscall.ENOSPC,    // SSH_FX_NO_SPACE_ON_FILESYSTEM
+	15: syscall.EDQUOT,    // SSH_FX_QUOTA_EXCEEDED
+	18: syscall.ENOTEMPTY, // SSH_FX_DIR_NOT_EMPTY
+	19: syscall.ENOTDIR,   // SSH_FX_NOT_A_DIRECTORY
+	20: syscall.EINVAL,    // SSH_FX_INVALID_FILENAME
+	24: syscall.EISDIR,    // SSH_FX_FILE_IS_A_DIRECTORY
+}
+
+func convertErr(err error) error {
+	if se, ok := err.(*sftp.StatusError); ok {
+		if errno, ok := statusErrnos[se.Code]; ok {
+			return errno
+		}
+	}
+	return err
+}
+
+// pathError returns err as an *os.PathError with a converted underlying
+// error. io.EOF is returned as is, as readers compare it directly.
+func pathError(op, path string, err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *os.PathError:
+		e.Err = convertErr(e.Err)
+		return e
+	}
+	if err == io.EOF {
+		return err
+	}
+	return &os.PathError{Op: op, Path: path, Err: convertErr(err)}
+}
+
+func linkError(op, oldname, newname string, err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *os.LinkError:
+		e.Err = convertErr(e.Err)
+		return e
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: convertErr(err)}
+}
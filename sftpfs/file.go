@@ -25,7 +25,7 @@ type File struct {
 func FileOpen(s *sftp.Client, name string) (*File, error) {
 	fd, err := s.Open(name)
 	if err != nil {
-		return &File{}, err
+		return nil, pathError("open", name, err)
 	}
 	return &File{fd: fd}, nil
 }
@@ -33,13 +33,13 @@ func FileOpen(s *sftp.Client, name string) (*File, error) {
 func FileCreate(s *sftp.Client, name string) (*File, error) {
 	fd, err := s.Create(name)
 	if err != nil {
-		return &File{}, err
+		return nil, pathError("open", name, err)
 	}
 	return &File{fd: fd}, nil
 }
 
 func (f *File) Close() error {
-	return f.fd.Close()
+	return pathError("close", f.Name(), f.fd.Close())
 }
 
 func (f *File) Name() string {
@@ -47,7 +47,11 @@ func (f *File) Name() string {
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
-	return f.fd.Stat()
+	fi, err := f.fd.Stat()
+	if err != nil {
+		return nil, pathError("stat", f.Name(), err)
+	}
+	return fi, nil
 }
 
 func (f *File) Sync() error {
@@ -55,11 +59,12 @@ func (f *File) Sync() error {
 }
 
 func (f *File) Truncate(size int64) error {
-	return f.fd.Truncate(size)
+	return pathError("truncate", f.Name(), f.fd.Truncate(size))
 }
 
 func (f *File) Read(b []byte) (n int, err error) {
-	return f.fd.Read(b)
+	n, err = f.fd.Read(b)
+	return n, pathError("read", f.Name(), err)
 }
 
 // TODO
@@ -78,11 +83,13 @@ func (f *File) Readdirnames(n int) (names []string, err error) {
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.fd.Seek(offset, whence)
+	ret, err := f.fd.Seek(offset, whence)
+	return ret, pathError("seek", f.Name(), err)
 }
 
 func (f *File) Write(b []byte) (n int, err error) {
-	return f.fd.Write(b)
+	n, err = f.fd.Write(b)
+	return n, pathError("write", f.Name(), err)
 }
 
 // TODO
@@ -91,5 +98,5 @@ func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
-	return f.fd.Write([]byte(s))
+	return f.Write([]byte(s))
 }
@@ -15,6 +15,7 @@ package sftpfs
 
 import (
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -35,16 +36,30 @@ func New(client *sftp.Client) afero.Fs {
 
 func (s Fs) Name() string { return "sftpfs" }
 
+// Capabilities returns the features of an SFTP version 3 server, whose
+// Rename fails if the new name exists.
+func (s Fs) Capabilities() afero.Features {
+	return afero.Features{Chmod: true, Chtimes: true}
+}
+
 func (s Fs) Create(name string) (afero.File, error) {
-	return FileCreate(s.client, name)
+	f, err := FileCreate(s.client, name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 func (s Fs) Mkdir(name string, perm os.FileMode) error {
 	err := s.client.Mkdir(name)
 	if err != nil {
-		return err
+		// servers commonly answer SSH_FX_FAILURE for an existing path
+		if _, lerr := s.client.Lstat(name); lerr == nil {
+			err = syscall.EEXIST
+		}
+		return pathError("mkdir", name, err)
 	}
-	return s.client.Chmod(name, perm)
+	return pathError("chmod", name, s.client.Chmod(name, perm))
 }
 
 func (s Fs) MkdirAll(path string, perm os.FileMode) error {
@@ -91,7 +106,11 @@ func (s Fs) MkdirAll(path string, perm os.FileMode) error {
 }
 
 func (s Fs) Open(name string) (afero.File, error) {
-	return FileOpen(s.client, name)
+	f, err := FileOpen(s.client, name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 // OpenFile calls the OpenFile method on the SSHFS connection. The mode argument
@@ -99,37 +118,62 @@ func (s Fs) Open(name string) (afero.File, error) {
 func (s Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
 	sshfsFile, err := s.client.OpenFile(name, flag)
 	if err != nil {
-		return nil, err
+		return nil, pathError("open", name, err)
 	}
 	return &File{fd: sshfsFile}, nil
 }
 
 func (s Fs) Remove(name string) error {
-	return s.client.Remove(name)
+	return pathError("remove", name, s.client.Remove(name))
 }
 
 func (s Fs) RemoveAll(path string) error {
-	// TODO have a look at os.RemoveAll
-	// https://github.com/golang/go/blob/master/src/os/path.go#L66
-	return nil
+	fi, err := s.client.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return pathError("lstat", path, err)
+	}
+	if !fi.IsDir() {
+		return pathError("remove", path, s.client.Remove(path))
+	}
+	entries, err := s.client.ReadDir(path)
+	if err != nil {
+		return pathError("readdir", path, err)
+	}
+	for _, entry := range entries {
+		if err := s.RemoveAll(s.client.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return pathError("remove", path, s.client.RemoveDirectory(path))
 }
 
 func (s Fs) Rename(oldname, newname string) error {
-	return s.client.Rename(oldname, newname)
+	return linkError("rename", oldname, newname, s.client.Rename(oldname, newname))
 }
 
 func (s Fs) Stat(name string) (os.FileInfo, error) {
-	return s.client.Stat(name)
+	fi, err := s.client.Stat(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fi, nil
 }
 
 func (s Fs) Lstat(p string) (os.FileInfo, error) {
-	return s.client.Lstat(p)
+	fi, err := s.client.Lstat(p)
+	if err != nil {
+		return nil, pathError("lstat", p, err)
+	}
+	return fi, nil
 }
 
 func (s Fs) Chmod(name string, mode os.FileMode) error {
-	return s.client.Chmod(name, mode)
+	return pathError("chmod", name, s.client.Chmod(name, mode))
 }
 
 func (s Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return s.client.Chtimes(name, atime, mtime)
+	return pathError("chtimes", name, s.client.Chtimes(name, atime, mtime))
 }
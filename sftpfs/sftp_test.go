@@ -18,12 +18,15 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -276,4 +279,61 @@ func TestSftpCreate(t *testing.T) {
 
 	fmt.Println("done")
 	// TODO check here if "hello\tworld\n" is in buffer b
+
+	f2, err := fs.Create("test/dir1/dir2/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2.Close()
+	if err := fs.RemoveAll("test/dir1"); err != nil {
+		t.Errorf("RemoveAll: %v", err)
+	}
+	if _, err := fs.Stat("test/dir1"); !os.IsNotExist(err) {
+		t.Errorf("test/dir1 not removed: %v", err)
+	}
+	if err := fs.RemoveAll("test/dir1"); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+
+	_, err = fs.Open("test/missing")
+	if pe, ok := err.(*os.PathError); !ok || pe.Path != "test/missing" || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open of a missing file: got %#v, want a not exist *os.PathError", err)
+	}
+	if err := fs.Mkdir("test/bar", 0777); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Mkdir of an existing dir: got %v, want os.ErrExist", err)
+	}
+}
+
+func TestSftpErrors(t *testing.T) {
+	for _, tt := range []struct {
+		code   uint32
+		target error
+	}{
+		{2, os.ErrNotExist},
+		{3, os.ErrPermission},
+		{10, os.ErrNotExist},
+		{11, os.ErrExist},
+		{18, syscall.ENOTEMPTY},
+		{19, syscall.ENOTDIR},
+		{24, syscall.EISDIR},
+	} {
+		err := pathError("open", "foo", &sftp.StatusError{Code: tt.code})
+		if pe, ok := err.(*os.PathError); !ok || pe.Op != "open" || pe.Path != "foo" {
+			t.Errorf("code %d: got %#v, want an *os.PathError", tt.code, err)
+		}
+		if !errors.Is(err, tt.target) {
+			t.Errorf("code %d: got %v, want %v", tt.code, err, tt.target)
+		}
+	}
+
+	failure := &sftp.StatusError{Code: 4}
+	if err := pathError("open", "foo", failure); !errors.Is(err, failure) {
+		t.Errorf("got %v, want the SSH_FX_FAILURE status kept", err)
+	}
+	if err := pathError("read", "foo", io.EOF); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+	if err := linkError("rename", "a", "b", &sftp.StatusError{Code: 11}); !os.IsExist(err) {
+		t.Errorf("got %v, want an exist *os.LinkError", err)
+	}
 }
@@ -0,0 +1,319 @@
+package afero
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/spf13/afero/mem"
+)
+
+// DefaultSpillThreshold is the file size above which a SpillFs moves the
+// contents of a file to disk when no threshold is given.
+const DefaultSpillThreshold = 1 << 20
+
+// The SpillFs is a MemMapFs that keeps the contents of the files larger than
+// a threshold in a private temporary directory on the OS filesystem instead
+// of on the heap. Directories, metadata and small files stay in memory, the
+// semantics are exactly those of a MemMapFs.
+//
+// The temporary directory is created on the first spill and removed by Close,
+// or as soon as no file is spilled to it. The temporary file of a file is
+// removed when the file shrinks back to the threshold, when it is removed or
+// replaced once its last handle is closed, and when it is garbage collected.
+// Files opened through a SpillFs must not be used after it is closed.
+type SpillFs struct {
+	*MemMapFs
+	spill *spillDir
+}
+
+// NewSpillFs returns an empty SpillFs spilling files larger than threshold
+// bytes to a new directory created in dir. A threshold <= 0 means
+// DefaultSpillThreshold, an empty dir means the default directory for
+// temporary files.
+func NewSpillFs(threshold int64, dir string) *SpillFs {
+	if threshold <= 0 {
+		threshold = DefaultSpillThreshold
+	}
+	d := &spillDir{parent: dir, files: make(map[*os.File]struct{})}
+	fs := &SpillFs{spill: d}
+	fs.MemMapFs = &MemMapFs{
+		opts: MemMapFsOptions{NewStorage: func() mem.Storage {
+			return &spillStorage{dir: d, threshold: threshold}
+		}},
+		unlinked: unlinkSpilled,
+	}
+	return fs
+}
+
+func (*SpillFs) Name() string { return "SpillFs" }
+
+func (fs *SpillFs) Create(name string) (File, error) {
+	return trackSpilled(fs.MemMapFs.Create(name))
+}
+
+func (fs *SpillFs) Open(name string) (File, error) {
+	return trackSpilled(fs.MemMapFs.Open(name))
+}
+
+func (fs *SpillFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return trackSpilled(fs.MemMapFs.OpenFile(name, flag, perm))
+}
+
+// Close removes the temporary directory and everything spilled to it.
+func (fs *SpillFs) Close() error {
+	return fs.spill.close()
+}
+
+// spillDir is the private directory the files of a SpillFs spill to. It
+// tracks the open temporary files so they can be closed before the directory
+// is removed.
+type spillDir struct {
+	parent string
+	mu     sync.Mutex
+	path   string
+	files  map[*os.File]struct{}
+	closed bool
+}
+
+func (d *spillDir) create() (*os.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, os.ErrClosed
+	}
+	if d.path == "" {
+		path, err := ioutil.TempDir(d.parent, "afero-spill-")
+		if err != nil {
+			return nil, err
+		}
+		d.path = path
+	}
+	f, err := ioutil.TempFile(d.path, "")
+	if err != nil {
+		return nil, err
+	}
+	d.files[f] = struct{}{}
+	return f, nil
+}
+
+// release closes and removes a temporary file that is no longer referenced,
+// and the directory along with the last one.
+func (d *spillDir) release(f *os.File) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.files[f]; !ok {
+		return
+	}
+	delete(d.files, f)
+	f.Close()
+	os.Remove(f.Name())
+	if len(d.files) == 0 {
+		os.Remove(d.path)
+		d.path = ""
+	}
+}
+
+func (d *spillDir) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	for f := range d.files {
+		f.Close()
+	}
+	d.files = nil
+	if d.path == "" {
+		return nil
+	}
+	return os.RemoveAll(d.path)
+}
+
+// trackSpilled counts f, returned by a MemMapFs method along with err, among
+// the open handles of its file.
+func trackSpilled(f File, err error) (File, error) {
+	mf, ok := f.(*mem.File)
+	if !ok {
+		return f, err
+	}
+	data := mf.Data()
+	s, ok := mem.GetStorage(data).(*spillStorage)
+	if !ok {
+		return f, err
+	}
+	data.Lock()
+	s.handles++
+	data.Unlock()
+	return &spillFile{File: mf, storage: s}, err
+}
+
+// unlinkSpilled is called for the files removed from the tree of a SpillFs,
+// whose temporary file is released once they have no open handles left.
+func unlinkSpilled(f *mem.FileData) {
+	s, ok := mem.GetStorage(f).(*spillStorage)
+	if !ok {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	s.unlinked = true
+	s.releaseUnused()
+}
+
+// spillFile is a handle on a file of a SpillFs.
+type spillFile struct {
+	*mem.File
+	storage *spillStorage
+}
+
+func (f *spillFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	data := f.Data()
+	data.Lock()
+	defer data.Unlock()
+	f.storage.handles--
+	f.storage.releaseUnused()
+	return nil
+}
+
+// spillStorage is a mem.Storage holding its contents in memory until they
+// grow beyond threshold, and in a temporary file from then on. Besides the
+// Storage methods, its fields are guarded by the lock of its mem.FileData.
+type spillStorage struct {
+	dir       *spillDir
+	threshold int64
+	data      []byte
+	file      *os.File
+	size      int64
+	// handles counts the open handles of the file, unlinked is set once the
+	// file is removed from the tree
+	handles  int
+	unlinked bool
+}
+
+func (s *spillStorage) Len() int64 {
+	if s.file != nil {
+		return s.size
+	}
+	return int64(len(s.data))
+}
+
+// spill moves the contents to a temporary file.
+func (s *spillStorage) spill() error {
+	f, err := s.dir.create()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(s.data, 0); err != nil {
+		s.dir.release(f)
+		return err
+	}
+	s.file, s.size, s.data = f, int64(len(s.data)), nil
+	// released along with the mem.FileData once neither the SpillFs nor a
+	// handle refer to it, like a removed file whose handles aren't closed
+	runtime.SetFinalizer(s, func(s *spillStorage) { s.dir.release(s.file) })
+	return nil
+}
+
+// release closes and removes the temporary file, leaving the storage empty.
+func (s *spillStorage) release() {
+	runtime.SetFinalizer(s, nil)
+	s.dir.release(s.file)
+	s.file, s.size = nil, 0
+}
+
+// releaseUnused drops the contents of a removed file without open handles.
+func (s *spillStorage) releaseUnused() {
+	if !s.unlinked || s.handles > 0 {
+		return
+	}
+	if s.file != nil {
+		s.release()
+	}
+	s.data = nil
+}
+
+func (s *spillStorage) ReadAt(p []byte, off int64) (n int, err error) {
+	if s.file == nil {
+		if off >= int64(len(s.data)) {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			return 0, io.EOF
+		}
+		n = copy(p, s.data[off:])
+		if n < len(p) {
+			err = io.EOF
+		}
+		return n, err
+	}
+	if off >= s.size {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	if rest := s.size - off; int64(len(p)) > rest {
+		p = p[:rest]
+		err = io.EOF
+	}
+	n, rerr := s.file.ReadAt(p, off)
+	if rerr != nil {
+		return n, rerr
+	}
+	return n, err
+}
+
+func (s *spillStorage) WriteAt(p []byte, off int64) (n int, err error) {
+	end := off + int64(len(p))
+	if s.file == nil && end > s.threshold {
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+	if s.file != nil {
+		n, err = s.file.WriteAt(p, off)
+		if end := off + int64(n); end > s.size {
+			s.size = end
+		}
+		return n, err
+	}
+	if l := int64(len(s.data)); end > l {
+		s.data = append(s.data, make([]byte, end-l)...)
+	}
+	return copy(s.data[off:], p), nil
+}
+
+func (s *spillStorage) Truncate(size int64) error {
+	if s.file != nil && size <= s.threshold {
+		// the contents fit in memory again
+		data := make([]byte, size)
+		if _, err := s.ReadAt(data, 0); err != nil && err != io.EOF {
+			return err
+		}
+		s.release()
+		s.data = data
+		return nil
+	}
+	if s.file == nil && size > s.threshold {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	if s.file != nil {
+		if err := s.file.Truncate(size); err != nil {
+			return err
+		}
+		s.size = size
+		return nil
+	}
+	if l := int64(len(s.data)); size > l {
+		s.data = append(s.data, make([]byte, size-l)...)
+	} else {
+		s.data = s.data[:size]
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package afero
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func spilledFiles(t *testing.T, parent string) []string {
+	t.Helper()
+	dirs, err := filepath.Glob(filepath.Join(parent, "afero-spill-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, d := range dirs {
+		names, err := filepath.Glob(filepath.Join(d, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, names...)
+	}
+	return files
+}
+
+func TestSpillFs(t *testing.T) {
+	parent, err := ioutil.TempDir("", "afero-spilltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	fs := NewSpillFs(16, parent)
+	if err := fs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/a/small", []byte("tiny"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if files := spilledFiles(t, parent); len(files) != 0 {
+		t.Fatalf("small file was spilled: %v", files)
+	}
+
+	big := bytes.Repeat([]byte("0123456789"), 10)
+	f, err := fs.Create("/a/b/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// grow the file past the threshold in several writes
+	for i := 0; i < len(big); i += 10 {
+		if _, err := f.Write(big[i : i+10]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.WriteAt([]byte("XY"), 5); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	copy(big[5:], "XY")
+
+	if files := spilledFiles(t, parent); len(files) != 1 {
+		t.Fatalf("expected one spilled file, got %v", files)
+	}
+	got, err := ReadFile(fs, "/a/b/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Errorf("got %q, want %q", got, big)
+	}
+	fi, err := fs.Stat("/a/b/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(big)) {
+		t.Errorf("Stat size %d, want %d", fi.Size(), len(big))
+	}
+
+	// the directory semantics are those of MemMapFs
+	if err := fs.Rename("/a/b/big", "/a/big"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ReadDir(fs, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Errorf("expected 3 entries in /a, got %d", len(names))
+	}
+	f, err = fs.OpenFile("/a/big", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got, _ := ReadFile(fs, "/a/big"); string(got) != "0123" {
+		t.Errorf("after truncate got %q", got)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(parent, "afero-spill-*")); len(dirs) != 0 {
+		t.Errorf("Close left %v behind", dirs)
+	}
+}
+
+func TestSpillFsReleases(t *testing.T) {
+	parent, err := ioutil.TempDir("", "afero-spilltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	fs := NewSpillFs(16, parent)
+	defer fs.Close()
+	big := bytes.Repeat([]byte("0123456789"), 10)
+	spill := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			if err := WriteFile(fs, name, big, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expect := func(n int) {
+		t.Helper()
+		if files := spilledFiles(t, parent); len(files) != n {
+			t.Fatalf("expected %d spilled files, got %v", n, files)
+		}
+	}
+
+	spill("/a", "/b")
+	expect(2)
+	if err := fs.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	expect(1)
+	if err := fs.Rename("/b", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	expect(1)
+
+	spill("/d")
+	if err := fs.Rename("/d", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	expect(1)
+
+	// overwriting with small contents brings them back to memory
+	if err := WriteFile(fs, "/c", []byte("tiny"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(0)
+
+	// a removed file stays readable until its last handle is closed
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	spill("/dir/e", "/dir/f")
+	f, err := fs.Open("/dir/e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	expect(1)
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Errorf("got %q from the removed file, want %q", got, big)
+	}
+	f.Close()
+	expect(0)
+	if dirs, _ := filepath.Glob(filepath.Join(parent, "afero-spill-*")); len(dirs) != 0 {
+		t.Errorf("%v left behind without spilled files", dirs)
+	}
+}
+
+func TestSpillFsOutlivedByFile(t *testing.T) {
+	parent, err := ioutil.TempDir("", "afero-spilltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	f, err := NewSpillFs(10, parent).Create("/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := bytes.Repeat([]byte("0123456789"), 10)
+	if _, err := f.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	// the handle keeps the spilled contents alive without the SpillFs
+	runtime.GC()
+	runtime.GC()
+	if _, err := f.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(big))
+	if _, err := f.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Errorf("got %q, want %q", got, big)
+	}
+	f.Close()
+}
@@ -0,0 +1,301 @@
+package afero
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SyncCompare selects how Sync decides whether a file has changed.
+type SyncCompare int
+
+const (
+	// CompareSizeAndModTime considers a file changed when its size or
+	// modification time differ, like rsync does by default.
+	CompareSizeAndModTime SyncCompare = iota
+	// CompareChecksum considers a file changed when its contents differ.
+	// Both copies are read entirely.
+	CompareChecksum
+)
+
+// SyncOptions controls Sync. The embedded LoadOptions select the entries
+// that are synchronised; entries filtered out are left alone on both sides.
+type SyncOptions struct {
+	LoadOptions
+
+	// Compare is the change detection method.
+	Compare SyncCompare
+
+	// ModifyWindow is the largest difference between two modification
+	// times considered equal, one second if zero since some filesystems,
+	// like sftpfs, only keep whole seconds. A negative window compares the
+	// times exactly.
+	ModifyWindow time.Duration
+
+	// Delete removes the entries of the destination that are missing from
+	// the source.
+	Delete bool
+
+	// DryRun only computes the actions, the destination is left untouched.
+	DryRun bool
+}
+
+// SyncOp is the kind of a SyncAction.
+type SyncOp string
+
+const (
+	SyncMkdir   SyncOp = "mkdir"   // create a directory
+	SyncCopy    SyncOp = "copy"    // copy the contents and metadata of a file
+	SyncSymlink SyncOp = "symlink" // recreate a symlink
+	SyncMeta    SyncOp = "meta"    // only update the mode and times
+	SyncDelete  SyncOp = "delete"  // remove the entry and its contents
+)
+
+// SyncAction is one step of a synchronisation. Path is relative to the
+// synchronised roots.
+type SyncAction struct {
+	Op   SyncOp
+	Path string
+}
+
+// Sync makes the tree of dst identical to the one of src by copying only
+// the files that changed, preserving modes, modification times and, if both
+// filesystems support them, symlinks. It returns the actions taken, or, in
+// dry-run mode, the actions that would have been taken.
+//
+// Both filesystems are synchronised from their root, wrap them in a
+// BasePathFs to synchronise sub trees.
+func Sync(src, dst Fs, opts *SyncOptions) ([]SyncAction, error) {
+	return syncTrees(src, FilePathSeparator, dst, FilePathSeparator, opts)
+}
+
+func syncTrees(src Fs, srcPath string, dst Fs, dstPath string, opts *SyncOptions) ([]SyncAction, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	s := &syncer{
+		loader:  loader{src: src, dst: dst, opts: &opts.LoadOptions},
+		opts:    opts,
+		srcPath: srcPath,
+		dstPath: dstPath,
+		infos:   make(map[string]os.FileInfo),
+	}
+	if err := s.plan(); err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return s.actions, nil
+	}
+	return s.actions, s.apply()
+}
+
+type syncer struct {
+	loader
+	opts             *SyncOptions
+	srcPath, dstPath string
+	actions          []SyncAction
+	// infos maps the relative paths of the source entries to their
+	// os.FileInfo, symlinks that are followed are resolved
+	infos map[string]os.FileInfo
+}
+
+func (s *syncer) add(op SyncOp, rel string) {
+	s.actions = append(s.actions, SyncAction{Op: op, Path: rel})
+}
+
+func (s *syncer) plan() error {
+	err := Walk(s.src, s.srcPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.srcPath, path)
+		if err != nil {
+			return err
+		}
+		if s.opts.skip(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 && s.followsSymlinks() {
+			if fi, err = s.src.Stat(path); err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				// links to directories are not followed, but the
+				// destination entry must not be deleted either
+				s.infos[rel] = nil
+				return nil
+			}
+		}
+		s.infos[rel] = fi
+		return s.planEntry(rel, fi)
+	})
+	if err != nil || !s.opts.Delete {
+		return err
+	}
+
+	return Walk(s.dst, s.dstPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == s.dstPath && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(s.dstPath, path)
+		if err != nil {
+			return err
+		}
+		if s.opts.skip(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		sfi, ok := s.infos[rel]
+		if !ok {
+			s.add(SyncDelete, rel)
+		}
+		if fi.IsDir() && (sfi == nil || !sfi.IsDir()) {
+			// deleted or replaced along with its contents, or the
+			// target of a link to a directory, which is left alone
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (s *syncer) planEntry(rel string, fi os.FileInfo) error {
+	srcName := filepath.Join(s.srcPath, rel)
+	dstName := filepath.Join(s.dstPath, rel)
+	create := SyncCopy
+	switch {
+	case fi.IsDir():
+		create = SyncMkdir
+	case fi.Mode()&os.ModeSymlink != 0:
+		create = SyncSymlink
+	}
+
+	dfi, err := lstatIfPossible(s.dst, dstName)
+	if os.IsNotExist(err) {
+		s.add(create, rel)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeType != dfi.Mode()&os.ModeType {
+		if rel != "." {
+			s.add(SyncDelete, rel)
+		}
+		s.add(create, rel)
+		return nil
+	}
+
+	switch create {
+	case SyncMkdir:
+		if fi.Mode() != dfi.Mode() {
+			s.add(SyncMeta, rel)
+		}
+	case SyncSymlink:
+		// a target that can't be read differs
+		same := false
+		srcReader, srcOk := s.src.(LinkReader)
+		dstReader, dstOk := s.dst.(LinkReader)
+		if srcOk && dstOk {
+			oldTarget, err := srcReader.ReadlinkIfPossible(srcName)
+			if err != nil {
+				return err
+			}
+			newTarget, err := dstReader.ReadlinkIfPossible(dstName)
+			same = err == nil && oldTarget == newTarget
+		}
+		if !same {
+			s.add(SyncDelete, rel)
+			s.add(SyncSymlink, rel)
+		}
+	default:
+		changed, err := s.changed(srcName, dstName, fi, dfi)
+		if err != nil {
+			return err
+		}
+		if changed {
+			s.add(SyncCopy, rel)
+		} else if fi.Mode() != dfi.Mode() || !s.sameModTime(fi.ModTime(), dfi.ModTime()) {
+			s.add(SyncMeta, rel)
+		}
+	}
+	return nil
+}
+
+// changed reports whether the file at dstName needs to be copied again.
+func (s *syncer) changed(srcName, dstName string, fi, dfi os.FileInfo) (bool, error) {
+	if fi.Size() != dfi.Size() {
+		return true, nil
+	}
+	if s.opts.Compare != CompareChecksum {
+		return !s.sameModTime(fi.ModTime(), dfi.ModTime()), nil
+	}
+	a, err := Checksum(s.src, srcName, ChecksumSHA256)
+	if err != nil {
+		return false, err
+	}
+	b, err := Checksum(s.dst, dstName, ChecksumSHA256)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(a, b), nil
+}
+
+// sameModTime reports whether the modification times a and b are equal
+// within the modify window.
+func (s *syncer) sameModTime(a, b time.Time) bool {
+	window := s.opts.ModifyWindow
+	switch {
+	case window < 0:
+		return a.Equal(b)
+	case window == 0:
+		window = time.Second
+	}
+	d := a.Sub(b)
+	return -window <= d && d <= window
+}
+
+func (s *syncer) apply() error {
+	for _, a := range s.actions {
+		srcName := filepath.Join(s.srcPath, a.Path)
+		dstName := filepath.Join(s.dstPath, a.Path)
+		fi := s.infos[a.Path]
+		var err error
+		switch a.Op {
+		case SyncDelete:
+			err = s.dst.RemoveAll(dstName)
+		case SyncMkdir:
+			err = s.copyDir(dstName, fi)
+		case SyncCopy:
+			err = s.copyFile(srcName, dstName, fi)
+		case SyncSymlink:
+			err = s.copySymlink(srcName, dstName)
+		case SyncMeta:
+			// the directories are fixed once their contents are in place
+			if !fi.IsDir() {
+				if err = s.dst.Chmod(dstName, fi.Mode()&chmodBits); err == nil {
+					err = s.dst.Chtimes(dstName, fi.ModTime(), fi.ModTime())
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// set the modes and times of the directories once their contents are in
+	// place
+	for rel, fi := range s.infos {
+		if fi != nil && fi.IsDir() {
+			s.deferDir(filepath.Join(s.dstPath, rel), fi)
+		}
+	}
+	return s.fixDirs()
+}
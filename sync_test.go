@@ -0,0 +1,203 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+func sortedActions(actions []SyncAction) []SyncAction {
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Path != actions[j].Path {
+			return actions[i].Path < actions[j].Path
+		}
+		return actions[i].Op < actions[j].Op
+	})
+	return actions
+}
+
+func TestSync(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	writeTree(t, src, "/", loadFiles)
+
+	actions, err := Sync(src, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) == 0 {
+		t.Fatal("expected actions for the initial sync")
+	}
+
+	actions, err = Sync(src, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Fatalf("second sync should be a no-op, got %v", actions)
+	}
+
+	WriteFile(src, "/a.txt", []byte("changed"), 0640)
+	src.Chmod("/b.log", 0600)
+	src.Remove("/sub/c.txt")
+	WriteFile(dst, "/extra", []byte("x"), 0644)
+
+	dry, err := Sync(src, dst, &SyncOptions{Delete: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncAction{
+		{SyncCopy, "a.txt"},
+		{SyncMeta, "b.log"},
+		{SyncDelete, "extra"},
+		{SyncDelete, filepath.Join("sub", "c.txt")},
+	}
+	if got := sortedActions(dry); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if b, _ := ReadFile(dst, "/a.txt"); string(b) != "alpha" {
+		t.Error("dry run modified the destination")
+	}
+
+	if _, err := Sync(src, dst, &SyncOptions{Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ReadFile(dst, "/a.txt"); string(b) != "changed" {
+		t.Errorf("a.txt not copied: %q", b)
+	}
+	if fi, _ := dst.Stat("/b.log"); fi.Mode().Perm() != 0600 {
+		t.Errorf("b.log mode not updated: %v", fi.Mode())
+	}
+	if ok, _ := Exists(dst, "/extra"); ok {
+		t.Error("extra not deleted")
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	mtime := time.Date(2019, 5, 6, 7, 8, 9, 0, time.UTC)
+	WriteFile(src, "/f", []byte("same"), 0644)
+	WriteFile(dst, "/f", []byte("same"), 0644)
+	WriteFile(src, "/g", []byte("abcd"), 0644)
+	WriteFile(dst, "/g", []byte("wxyz"), 0644)
+	for _, fs := range []Fs{src, dst} {
+		fs.Chtimes("/g", mtime, mtime)
+	}
+	dst.Chtimes("/f", mtime, mtime)
+
+	actions, err := Sync(src, dst, &SyncOptions{Compare: CompareChecksum, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncAction{{SyncMeta, "f"}, {SyncCopy, "g"}}
+	if got := sortedActions(actions); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSyncDirReplacedByFile(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	WriteFile(src, "/x", []byte("file"), 0644)
+	dst.MkdirAll("/x/sub", 0755)
+
+	actions, err := Sync(src, dst, &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncAction{{SyncDelete, "x"}, {SyncCopy, "x"}}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v, want %v", actions, want)
+	}
+	if b, _ := ReadFile(dst, "/x"); string(b) != "file" {
+		t.Errorf("x not copied: %q", b)
+	}
+}
+
+func TestSyncModifyWindow(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	mtime := time.Date(2019, 5, 6, 7, 8, 9, 500000000, time.UTC)
+	WriteFile(src, "/f", []byte("same"), 0644)
+	WriteFile(dst, "/f", []byte("same"), 0644)
+	src.Chtimes("/f", mtime, mtime)
+	// whole seconds, like on sftpfs
+	dst.Chtimes("/f", mtime, mtime.Truncate(time.Second))
+
+	actions, err := Sync(src, dst, &SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Errorf("times within the window differ: %v", actions)
+	}
+	actions, err = Sync(src, dst, &SyncOptions{DryRun: true, ModifyWindow: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SyncAction{{SyncCopy, "f"}}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v, want %v", actions, want)
+	}
+}
+
+// linkOnlyFs creates symlinks but can't read them.
+type linkOnlyFs struct {
+	Fs
+}
+
+func (l linkOnlyFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return l.Fs.(Lstater).LstatIfPossible(name)
+}
+
+func (l linkOnlyFs) SymlinkIfPossible(oldname, newname string) error {
+	return l.Fs.(Linker).SymlinkIfPossible(oldname, newname)
+}
+
+func TestSyncSymlinkUnreadableDestination(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	osFs := NewOsFs()
+	var fss []Fs
+	for i := 0; i < 2; i++ {
+		root, err := TempDir(osFs, "", "afero-sync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer osFs.RemoveAll(root)
+		if err := os.Symlink("target", filepath.Join(root, "link")); err != nil {
+			t.Fatal(err)
+		}
+		fss = append(fss, NewBasePathFs(osFs, root))
+	}
+
+	actions, err := Sync(fss[0], linkOnlyFs{fss[1]}, &SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncAction{{SyncDelete, "link"}, {SyncSymlink, "link"}}
+	if got := sortedActions(actions); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSyncReadOnlyDir(t *testing.T) {
+	src, dst := NewMemMapFs(), NewMemMapFs()
+	writeTree(t, src, "/", map[string]string{"new/f": "f", "old/f": "f"})
+	src.Chmod("/new", os.ModeDir|0555)
+	src.Chmod("/old", os.ModeDir|0555)
+	dst.MkdirAll("/old", 0755)
+
+	if _, err := Sync(src, permFs{dst}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/new", "/old"} {
+		if b, _ := ReadFile(dst, dir+"/f"); string(b) != "f" {
+			t.Errorf("%s/f not copied: %q", dir, b)
+		}
+		if fi, err := dst.Stat(dir); err != nil || fi.Mode().Perm() != 0555 {
+			t.Errorf("%s: %v, %v", dir, fi, err)
+		}
+	}
+}
@@ -187,20 +187,18 @@ func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
 		f.files = append(f.files, merged...)
 	}
 
-	if c <= 0 && len(f.files) == 0 {
-		return f.files, nil
+	if c <= 0 {
+		ofi = f.files[f.off:]
+		f.off = len(f.files)
+		return ofi, nil
 	}
 
 	if f.off >= len(f.files) {
 		return nil, io.EOF
 	}
 
-	if c <= 0 {
-		return f.files[f.off:], nil
-	}
-
-	if c > len(f.files) {
-		c = len(f.files)
+	if c > len(f.files)-f.off {
+		c = len(f.files) - f.off
 	}
 
 	defer func() { f.off += c }()
@@ -307,7 +305,7 @@ func copyToLayer(base Fs, layer Fs, name string) error {
 	if err != nil || bfi.Size() != n {
 		layer.Remove(name)
 		lfh.Close()
-		return syscall.EIO
+		return &os.PathError{Op: "copy", Path: name, Err: syscall.EIO}
 	}
 
 	err = lfh.Close()
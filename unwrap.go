@@ -0,0 +1,106 @@
+package afero
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Unwrapper is an optional interface in Afero. It is implemented by the
+// filesystems wrapping others, like BasePathFs or CopyOnWriteFs, to give
+// access to them.
+type Unwrapper interface {
+	// Unwrap returns the filesystems directly wrapped, in a fixed order.
+	Unwrap() []Fs
+}
+
+// Unwrap returns the filesystems directly wrapped by fs, or nil if fs
+// doesn't implement Unwrapper.
+func Unwrap(fs Fs) []Fs {
+	if u, ok := fs.(Unwrapper); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
+
+// errStopChain stops WalkChain without an error.
+var errStopChain = errors.New("stop walking the chain")
+
+// WalkChain calls fn for fs and, depth first, for each of the filesystems it
+// wraps, with their depth in the chain, 0 for fs. It stops at the first
+// error returned by fn, and returns it.
+func WalkChain(fs Fs, fn func(fs Fs, depth int) error) error {
+	return walkChain(fs, 0, fn)
+}
+
+func walkChain(fs Fs, depth int, fn func(fs Fs, depth int) error) error {
+	if err := fn(fs, depth); err != nil {
+		return err
+	}
+	for _, inner := range Unwrap(fs) {
+		if err := walkChain(inner, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindFs finds the first filesystem of the chain of fs, in the order of
+// WalkChain, that is assignable to the value pointed to by target, and if
+// so, sets target to it and returns true. Like errors.As, it panics if
+// target is not a non-nil pointer to a type implementing Fs or to an
+// interface.
+//
+//	var osFs *afero.OsFs
+//	if afero.FindFs(fs, &osFs) {
+//		...
+//	}
+func FindFs(fs Fs, target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if target == nil || val.Kind() != reflect.Ptr || val.IsNil() {
+		panic("afero: FindFs target must be a non-nil pointer")
+	}
+	typ := val.Type().Elem()
+	fsType := reflect.TypeOf((*Fs)(nil)).Elem()
+	if typ.Kind() != reflect.Interface && !typ.Implements(fsType) {
+		panic("afero: FindFs *target must be an interface or implement Fs")
+	}
+	found := false
+	WalkChain(fs, func(fs Fs, depth int) error {
+		if reflect.TypeOf(fs).AssignableTo(typ) {
+			val.Elem().Set(reflect.ValueOf(fs))
+			found = true
+			return errStopChain
+		}
+		return nil
+	})
+	return found
+}
+
+// pathMapper is implemented by the wrappers storing the file name in a
+// wrapped filesystem, with the same contents.
+type pathMapper interface {
+	// mapPath returns the wrapped filesystem holding name and the name it
+	// has there, or false if there is none.
+	mapPath(name string) (Fs, string, bool)
+}
+
+// OsPath returns the path of the file name of fs in the OS filesystem, and
+// true, if fs is an OsFs or a chain of wrappers storing the file as is in
+// an OsFs, like BasePathFs, ReadOnlyFs or the layer holding it of a
+// CopyOnWriteFs. The wrappers changing the names or the contents of the
+// files, like EncryptedFs or CompressedFs, have no OS path.
+func OsPath(fs Fs, name string) (string, bool) {
+	for {
+		switch f := fs.(type) {
+		case *OsFs, OsFs:
+			return name, true
+		case pathMapper:
+			var ok bool
+			if fs, name, ok = f.mapPath(name); !ok {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package afero
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWalkChain(t *testing.T) {
+	mem := NewMemMapFs()
+	fs := NewBasePathFs(NewCopyOnWriteFs(NewReadOnlyFs(NewOsFs()), mem), "/app")
+
+	var chain []string
+	err := WalkChain(fs, func(fs Fs, depth int) error {
+		chain = append(chain, strings.Repeat(" ", depth)+fs.Name())
+		return nil
+	})
+	want := []string{"BasePathFs", " CopyOnWriteFs", "  ReadOnlyFilter", "   OsFs", "  MemMapFS"}
+	if err != nil || !reflect.DeepEqual(chain, want) {
+		t.Errorf("got %q, %v, want %q", chain, err, want)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = WalkChain(fs, func(fs Fs, depth int) error {
+		n++
+		if depth == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 3 {
+		t.Errorf("stopped after %d filesystems with %v", n, err)
+	}
+
+	if inner := Unwrap(fs); len(inner) != 1 || inner[0].Name() != "CopyOnWriteFs" {
+		t.Errorf("Unwrap: %v", inner)
+	}
+	if inner := Unwrap(mem); inner != nil {
+		t.Errorf("Unwrap of a MemMapFs: %v", inner)
+	}
+}
+
+func TestFindFs(t *testing.T) {
+	mem := NewMemMapFs()
+	fs := NewReadOnlyFs(NewCopyOnWriteFs(NewOsFs(), mem))
+
+	var osFs *OsFs
+	if !FindFs(fs, &osFs) || osFs == nil {
+		t.Error("OsFs not found")
+	}
+	var memFs *MemMapFs
+	if !FindFs(fs, &memFs) || memFs != mem {
+		t.Errorf("found %v, want the layer", memFs)
+	}
+	var lstater Lstater
+	if !FindFs(fs, &lstater) || lstater.(Fs) != fs {
+		t.Errorf("found Lstater %v, want the ReadOnlyFs", lstater)
+	}
+	var base *BasePathFs
+	if FindFs(fs, &base) {
+		t.Error("found a BasePathFs")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for a target not implementing Fs")
+		}
+	}()
+	var s string
+	FindFs(fs, &s)
+}
+
+func TestOsPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "afero-ospath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "base"), []byte("base"), 0644)
+
+	layerDir := filepath.Join(dir, "layer")
+	os.Mkdir(layerDir, 0755)
+	cow := NewCopyOnWriteFs(NewReadOnlyFs(NewBasePathFs(NewOsFs(), dir)), NewBasePathFs(NewOsFs(), layerDir))
+	WriteFile(cow, "/new", []byte("new"), 0644)
+
+	mount := NewMountFs(NewMemMapFs())
+	mount.Mount("/cow", cow)
+	keys := &StaticKeys{Current: "k", Keys: map[string][]byte{"k": make([]byte, 32)}}
+	mount.Mount("/enc", NewEncryptedFs(NewOsFs(), EncryptedFsOptions{Keys: keys}))
+
+	for _, tt := range []struct {
+		fs   Fs
+		name string
+		want string
+	}{
+		{NewOsFs(), "/etc/hosts", "/etc/hosts"},
+		{cow, "/base", filepath.Join(dir, "base")},
+		{cow, "/new", filepath.Join(layerDir, "new")},
+		{cow, "/missing", filepath.Join(layerDir, "missing")},
+		{mount, "/cow/base", filepath.Join(dir, "base")},
+		{mount, "/mem", ""},
+		{mount, "/enc/file", ""},
+		{NewMemMapFs(), "/file", ""},
+	} {
+		got, ok := OsPath(tt.fs, tt.name)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("OsPath(%s, %s) = %q, %v, want %q", tt.fs.Name(), tt.name, got, ok, tt.want)
+		}
+	}
+
+	if p, ok := OsPath(NewBasePathFs(NewOsFs(), dir), "../../x"); ok && !strings.HasPrefix(p, dir) {
+		t.Errorf("OsPath escapes the base path: %q, %v", p, ok)
+	}
+}
@@ -38,7 +38,7 @@ func (a Afero) WriteReader(path string, r io.Reader) (err error) {
 }
 
 func WriteReader(fs Fs, path string, r io.Reader) (err error) {
-	dir, _ := filepath.Split(path)
+	dir, _ := pathStyleOf(fs).split(path)
 	ospath := filepath.FromSlash(dir)
 
 	if ospath != "" {
@@ -66,7 +66,7 @@ func (a Afero) SafeWriteReader(path string, r io.Reader) (err error) {
 }
 
 func SafeWriteReader(fs Fs, path string, r io.Reader) (err error) {
-	dir, _ := filepath.Split(path)
+	dir, _ := pathStyleOf(fs).split(path)
 	ospath := filepath.FromSlash(dir)
 
 	if ospath != "" {
@@ -321,7 +321,7 @@ func Exists(fs Fs, path string) (bool, error) {
 }
 
 func FullBaseFsPath(basePathFs *BasePathFs, relativePath string) string {
-	combinedPath := filepath.Join(basePathFs.path, relativePath)
+	combinedPath := basePathFs.style.join(basePathFs.path, relativePath)
 	if parent, ok := basePathFs.source.(*BasePathFs); ok {
 		return FullBaseFsPath(parent, combinedPath)
 	}
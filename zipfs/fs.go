@@ -2,6 +2,7 @@ package zipfs
 
 import (
 	"archive/zip"
+	"encoding/binary"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -46,11 +47,17 @@ func New(r *zip.Reader) afero.Fs {
 	return fs
 }
 
-func (fs *Fs) Create(name string) (afero.File, error) { return nil, syscall.EPERM }
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+}
 
-func (fs *Fs) Mkdir(name string, perm os.FileMode) error { return syscall.EPERM }
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
 
-func (fs *Fs) MkdirAll(path string, perm os.FileMode) error { return syscall.EPERM }
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: syscall.EPERM}
+}
 
 func (fs *Fs) Open(name string) (afero.File, error) {
 	d, f := splitpath(name)
@@ -69,16 +76,22 @@ func (fs *Fs) Open(name string) (afero.File, error) {
 
 func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
 	if flag != os.O_RDONLY {
-		return nil, syscall.EPERM
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
 	}
 	return fs.Open(name)
 }
 
-func (fs *Fs) Remove(name string) error { return syscall.EPERM }
+func (fs *Fs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
 
-func (fs *Fs) RemoveAll(path string) error { return syscall.EPERM }
+func (fs *Fs) RemoveAll(path string) error {
+	return &os.PathError{Op: "removeall", Path: path, Err: syscall.EPERM}
+}
 
-func (fs *Fs) Rename(oldname, newname string) error { return syscall.EPERM }
+func (fs *Fs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
 
 type pseudoRoot struct{}
 
@@ -104,8 +117,42 @@ func (fs *Fs) Stat(name string) (os.FileInfo, error) {
 	return file.FileInfo(), nil
 }
 
+// LstatIfPossible returns the FileInfo of name, symlinks in the archive are
+// not supported and are reported as files.
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fi, err := fs.Stat(name)
+	return fi, false, err
+}
+
 func (fs *Fs) Name() string { return "zipfs" }
 
-func (fs *Fs) Chmod(name string, mode os.FileMode) error { return syscall.EPERM }
+func (fs *Fs) Capabilities() afero.Features {
+	return afero.Features{ReadOnly: true}
+}
 
-func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error { return syscall.EPERM }
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// HashIfPossible returns the CRC-32 of the file name recorded in the archive,
+// other digests are not known.
+func (fs *Fs) HashIfPossible(name string, algo afero.ChecksumAlgo) ([]byte, bool, error) {
+	if algo != afero.ChecksumCRC32 {
+		return nil, false, nil
+	}
+	d, f := splitpath(name)
+	file, ok := fs.files[d][f]
+	if !ok || f == "" {
+		return nil, false, &os.PathError{Op: "stat", Path: name, Err: syscall.ENOENT}
+	}
+	if file.FileInfo().IsDir() {
+		return nil, false, &os.PathError{Op: "hash", Path: name, Err: syscall.EISDIR}
+	}
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, file.CRC32)
+	return sum, true, nil
+}
@@ -4,6 +4,9 @@ import (
 	"github.com/spf13/afero"
 
 	"archive/zip"
+	"errors"
+	"io"
+	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -101,3 +104,84 @@ func TestZipFS(t *testing.T) {
 		}
 	}
 }
+
+func TestZipFSHash(t *testing.T) {
+	zrc, err := zip.OpenReader("testdata/t.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zfs := New(&zrc.Reader)
+
+	sum, ok, err := zfs.(afero.Hasher).HashIfPossible("/sub/testDir2/testFile", afero.ChecksumCRC32)
+	if err != nil || !ok {
+		t.Fatalf("HashIfPossible: %v, %v", ok, err)
+	}
+	// the CRC-32 computed from the contents
+	data, _ := afero.ReadFile(zfs, "/sub/testDir2/testFile")
+	mem := afero.NewMemMapFs()
+	afero.WriteFile(mem, "/f", data, 0644)
+	want, err := afero.Checksum(mem, "/f", afero.ChecksumCRC32)
+	if err != nil || !reflect.DeepEqual(sum, want) {
+		t.Errorf("CRC-32 %x, computed %x (%v)", sum, want, err)
+	}
+
+	if _, ok, _ := zfs.(afero.Hasher).HashIfPossible("/testFile", afero.ChecksumSHA256); ok {
+		t.Error("knows the SHA-256 of a zip entry")
+	}
+	if _, err := afero.ChecksumTree(zfs, "/", afero.ChecksumCRC32); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestZipFSLstat(t *testing.T) {
+	zrc, err := zip.OpenReader("testdata/t.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zfs := New(&zrc.Reader)
+	lstater, ok := zfs.(afero.Lstater)
+	if !ok {
+		t.Fatal("zipfs doesn't implement Lstater")
+	}
+	fi, lstat, err := lstater.LstatIfPossible("testFile")
+	if err != nil || lstat || fi.Size() != 8192 {
+		t.Errorf("LstatIfPossible: %v, %v, %v", fi, lstat, err)
+	}
+	if _, _, err := lstater.LstatIfPossible("missing"); !os.IsNotExist(err) {
+		t.Errorf("LstatIfPossible of a missing file: %v", err)
+	}
+}
+
+func TestZipFSErrors(t *testing.T) {
+	zrc, err := zip.OpenReader("testdata/t.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zfs := New(&zrc.Reader)
+
+	var pe *os.PathError
+	if err := zfs.Remove("/testFile"); !errors.As(err, &pe) || pe.Path != "/testFile" || !os.IsPermission(err) {
+		t.Errorf("Remove: got %#v, want a permission *os.PathError", err)
+	}
+	if err := zfs.Rename("/testFile", "/moved"); !os.IsPermission(err) {
+		t.Errorf("Rename: got %v, want a permission error", err)
+	}
+
+	f, err := zfs.Open("/testFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); !errors.As(err, &pe) || !os.IsPermission(err) {
+		t.Errorf("Write: got %#v, want a permission *os.PathError", err)
+	}
+	if _, err := f.Readdir(-1); !afero.IsNotDir(err) {
+		t.Errorf("Readdir of a file: got %v, want ErrNotDir", err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); !afero.IsInvalid(err) {
+		t.Errorf("Seek before the start: got %v, want ErrInvalid", err)
+	}
+	f.Close()
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Read of a closed file: got %v, want os.ErrClosed", err)
+	}
+}